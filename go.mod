@@ -1,3 +1,11 @@
 module appinstaller
 
 go 1.22
+
+require (
+	github.com/BurntSushi/toml v1.4.0
+	github.com/klauspost/compress v1.17.11
+	github.com/pierrec/lz4/v4 v4.1.21
+	github.com/ulikunitz/xz v0.5.12
+	golang.org/x/crypto v0.31.0
+)
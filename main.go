@@ -1,104 +1,106 @@
 package main
 
 import (
+	"appinstaller/pkg/appid"
+	"appinstaller/pkg/appimage"
 	"appinstaller/pkg/desktop"
 	"appinstaller/pkg/fileutil"
+	"appinstaller/pkg/icons"
 	"appinstaller/pkg/manager"
+	"appinstaller/pkg/registry"
+	"appinstaller/pkg/settings"
 	"appinstaller/pkg/types"
+	"appinstaller/pkg/update"
+	"appinstaller/pkg/xdg"
 	"fmt"
+	"io"
+	"io/fs"
 	"log"
 	"os"
 	"os/exec"
+	"os/user"
 	"path/filepath"
+	"sort"
 	"strings"
 	"strconv"
+	"syscall"
+	"time"
 )
 
-func checkDependencies() error {
-	dependencies := []struct {
-		lib     string
-		pkg     string
-		desc    string
-	}{
-		{"libfuse.so.2", "libfuse2", "FUSE library"},
-		{"libz.so.1", "zlib1g", "zlib compression library"},
-	}
-
-	for _, dep := range dependencies {
-		cmd := exec.Command("ldconfig", "-p")
-		output, err := cmd.Output()
-		if err != nil {
-			return fmt.Errorf("failed to check libraries: %v", err)
-		}
-
-		if !strings.Contains(string(output), dep.lib) {
-			return fmt.Errorf("%s (%s) is missing. Please install it using:\nsudo apt-get install %s", dep.desc, dep.lib, dep.pkg)
-		}
-	}
-	return nil
-}
-
-func extractApp(appPath string) {
+func extractApp(config types.Config) error {
+	appPath := config.InputPath
 	if err := os.Chmod(appPath, 0755); err != nil {
-		log.Fatal("failed to set executable permissions: ", err)
+		return fmt.Errorf("failed to set executable permissions: %w", err)
 	}
 
-	file, err := os.Open(appPath)
+	app, err := appimage.Open(appPath)
 	if err != nil {
-		log.Fatal("failed to open file: ", err)
+		return err
 	}
-	defer file.Close()
+	defer app.Close()
 
-	magic := make([]byte, 16)
-	if _, err := file.Read(magic); err != nil {
-		log.Fatal("failed to read file header: ", err)
+	extractionMethods := []func(*appimage.AppImage) error{
+		tryNativeExtract,
+		tryExtractWithUnsquashfs,
+	}
+	if config.AllowExecExtract {
+		extractionMethods = append(extractionMethods, tryExtractWithAppImage)
 	}
+	extractionMethods = append(extractionMethods, tryManualExtract)
 
-	if string(magic[:4]) != "\x7FELF" {
-		log.Fatal("not a valid AppImage file (missing ELF header)")
+	var failures []string
+	for _, method := range extractionMethods {
+		err := method(app)
+		if err == nil {
+			return nil
+		}
+		fmt.Printf("Extraction method failed: %v\nTrying next method...\n", err)
+		failures = append(failures, err.Error())
 	}
 
-	extractionMethods := []func(string) error{
-		tryExtractWithUnsquashfs,
-		tryExtractWithAppImage,
-		tryManualExtract,
+	if !config.AllowExecExtract {
+		failures = append(failures, "executing the AppImage was skipped (use --allow-exec-extract to allow it)")
 	}
+	return fmt.Errorf("all extraction methods failed:\n  %s", strings.Join(failures, "\n  "))
+}
 
-	for _, method := range extractionMethods {
-		if err := method(appPath); err == nil {
-			return
-		} else {
-			fmt.Printf("Extraction method failed: %v\nTrying next method...\n", err)
-		}
+func tryNativeExtract(app *appimage.AppImage) error {
+	fmt.Println("Trying built-in extraction of desktop integration files...")
+
+	fsys, err := app.FS()
+	if err != nil {
+		return err
 	}
 
-	log.Fatal("all extraction methods failed")
+	if err := appimage.ExtractIntegration(fsys, "squashfs-root"); err != nil {
+		return fmt.Errorf("built-in extraction failed: %v", err)
+	}
+	return nil
 }
 
-func tryExtractWithUnsquashfs(appPath string) error {
+func tryExtractWithUnsquashfs(app *appimage.AppImage) error {
 	fmt.Println("Trying extraction with unsquashfs...")
-	
-	if _, err := exec.LookPath("unsquashfs"); err != nil {
-		return fmt.Errorf("unsquashfs not found: %v", err)
+
+	if app.Type != appimage.Type2 {
+		return fmt.Errorf("type %d AppImage has no squashfs payload", app.Type)
 	}
 
-	offsetCmd := exec.Command("sh", "-c", fmt.Sprintf("dd if=%s bs=1 skip=0 count=100000 2>/dev/null | grep -a -b -o hsqs | cut -d ':' -f 1", appPath))
-	output, err := offsetCmd.Output()
-	if err != nil || len(output) == 0 {
-		return fmt.Errorf("failed to find squashfs offset: %v", err)
+	if _, err := exec.LookPath("unsquashfs"); err != nil {
+		return fmt.Errorf("unsquashfs not found: %v", err)
 	}
 
-	offset, err := strconv.Atoi(strings.TrimSpace(string(output)))
+	squashFile := fmt.Sprintf("%s.squashfs", app.Path)
+	out, err := os.Create(squashFile)
 	if err != nil {
-		return fmt.Errorf("invalid offset value: %v", err)
+		return fmt.Errorf("failed to create squashfs file: %v", err)
 	}
+	defer os.Remove(squashFile)
 
-	squashFile := fmt.Sprintf("%s.squashfs", appPath)
-	ddCmd := exec.Command("dd", fmt.Sprintf("if=%s", appPath), fmt.Sprintf("of=%s", squashFile), fmt.Sprintf("bs=1", appPath), fmt.Sprintf("skip=%d", offset))
-	if err := ddCmd.Run(); err != nil {
+	_, err = io.Copy(out, app.Payload())
+	out.Close()
+	if err != nil {
 		return fmt.Errorf("failed to extract squashfs part: %v", err)
 	}
-	defer os.Remove(squashFile)
 
 	unsquashCmd := exec.Command("unsquashfs", "-f", "-d", "squashfs-root", squashFile)
 	unsquashCmd.Stdout = os.Stdout
@@ -110,26 +112,17 @@ func tryExtractWithUnsquashfs(appPath string) error {
 	return nil
 }
 
-func tryExtractWithAppImage(appPath string) error {
-	fmt.Println("Trying native AppImage extraction...")
-
-	cmd := exec.Command(appPath, "--appimage-extract")
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	
-	env := os.Environ()
-	env = append(env, "APPIMAGE_EXTRACT_AND_RUN=1", "NO_CLEANUP=1")
-	cmd.Env = env
+func tryExtractWithAppImage(app *appimage.AppImage) error {
+	fmt.Println("Trying native AppImage extraction as an unprivileged user...")
 
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("native extraction failed: %v", err)
-	}
-	return nil
+	return app.ExtractWithRuntime("squashfs-root")
 }
 
-func tryManualExtract(appPath string) error {
+func tryManualExtract(app *appimage.AppImage) error {
 	fmt.Println("Trying manual extraction...")
 
+	appPath := app.Path
+
 	if err := os.MkdirAll("squashfs-root", 0755); err != nil {
 		return fmt.Errorf("failed to create extraction directory: %v", err)
 	}
@@ -186,23 +179,109 @@ func createDirectories(config types.Config) error {
 	if err != nil {
 		return err
 	}
-	err = os.MkdirAll(config.ExecDir, os.ModePerm)
+	err = os.MkdirAll(config.Path(config.ExecDir), os.ModePerm)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(config.Path(config.ImgPath), os.ModePerm)
 	if err != nil {
 		return err
 	}
-	err = os.MkdirAll(config.ImgPath, os.ModePerm)
+	err = os.MkdirAll(config.Path(config.GnomeDesktopDir), 0755)
 	if err != nil {
 		return err
 	}
-	err = os.Chmod(config.ExecDir, 0777)
+	if config.Scope == types.ScopeUser {
+		return nil
+	}
+	err = os.Chmod(config.Path(config.ExecDir), 0777)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+// scope is the installation scope chosen with --user or --system. Without
+// either, installs are system-wide and list and delete cover both scopes.
+var scope string
+
+// rootDir is the directory given with --root that all install paths are
+// relative to.
+var rootDir string
+
+// verbose is set by --verbose and turns on debug output.
+var verbose bool
+
+// parseGlobalFlags removes the scope and root flags from the command line,
+// so they can be given anywhere.
+func parseGlobalFlags() error {
+	args := os.Args[:1]
+	for i := 1; i < len(os.Args); i++ {
+		arg := os.Args[i]
+		chosen := ""
+		switch {
+		case arg == "--user":
+			chosen = types.ScopeUser
+		case arg == "--system":
+			chosen = types.ScopeSystem
+		case arg == "--verbose":
+			verbose = true
+			continue
+		case arg == "--root" || strings.HasPrefix(arg, "--root="):
+			dir, found := strings.CutPrefix(arg, "--root=")
+			if !found {
+				if i+1 >= len(os.Args) {
+					return fmt.Errorf("--root requires a directory")
+				}
+				i++
+				dir = os.Args[i]
+			}
+			abs, err := filepath.Abs(dir)
+			if err != nil || dir == "" {
+				return fmt.Errorf("invalid --root directory %q", dir)
+			}
+			rootDir = abs
+			continue
+		default:
+			args = append(args, arg)
+			continue
+		}
+		if scope != "" && scope != chosen {
+			return fmt.Errorf("--user and --system cannot be combined")
+		}
+		scope = chosen
+	}
+	os.Args = args
+	return nil
+}
+
+// scopes returns the scopes list and delete look at.
+func scopes() []string {
+	if scope != "" {
+		return []string{scope}
+	}
+	return []string{types.ScopeSystem, types.ScopeUser}
+}
+
 func setConfig(path string) types.Config {
+	if scope == types.ScopeUser {
+		return scopeConfig(types.ScopeUser, path)
+	}
+	return scopeConfig(types.ScopeSystem, path)
+}
+
+func scopeConfig(installScope string, path string) types.Config {
+	config, _ := configWithSources(installScope, path)
+	return config
+}
+
+// configWithSources builds the configuration of a scope from its defaults,
+// the configuration files and the environment, and reports where each
+// adjustable setting came from.
+func configWithSources(installScope string, path string) (types.Config, []*settings.Setting) {
 	config := types.Config{
+		Root:            rootDir,
+		Scope:           types.ScopeSystem,
 		AppExtractDir:   "squashfs-root",
 		ExtractDir:      "/tmp/appInstaller",
 		ExecDir:         "/usr/share/appImages/",
@@ -210,13 +289,47 @@ func setConfig(path string) types.Config {
 		AutostartDir:    "/etc/xdg/autostart/",
 		Debug:           false,
 		ImgPath:         "/usr/share/pixmaps/",
+		IconThemeDir:    "/usr/share/icons/hicolor/",
+		RegistryPath:    "/var/lib/appinstaller/registry.json",
+		KeepVersions:    3,
+		VerifyPolicy:    types.VerifyRepair,
 		InputPath:       path,
 	}
-	config.ExecPath = filepath.Join(config.ExecDir, filepath.Base(config.InputPath))
+	if installScope == types.ScopeUser {
+		config.Scope = types.ScopeUser
+		config.ExtractDir = filepath.Join(xdg.CacheHome(), "appinstaller")
+		config.ExecDir = filepath.Join(xdg.DataHome(), "appimages")
+		config.GnomeDesktopDir = filepath.Join(xdg.DataHome(), "applications")
+		config.ImgPath = filepath.Join(xdg.DataHome(), "icons")
+		config.IconThemeDir = filepath.Join(xdg.DataHome(), "icons", icons.Theme)
+		config.AutostartDir = filepath.Join(xdg.ConfigHome(), "autostart")
+		config.RegistryPath = filepath.Join(xdg.StateHome(), "appinstaller", "registry.json")
+	}
+	sources, err := settings.Apply(&config)
+	if err != nil {
+		log.Fatal("invalid configuration: ", err)
+	}
+	if verbose {
+		config.Debug = true
+	}
 	config.AppExtractDir = filepath.Join(config.ExtractDir, "squashfs-root")
 	config.InputDir = filepath.Dir(config.InputPath)
 	config.InputFileName = filepath.Base(config.InputPath)
-	return config
+	return config, sources
+}
+
+func showConfig() error {
+	installScope := types.ScopeSystem
+	if scope == types.ScopeUser {
+		installScope = types.ScopeUser
+	}
+	_, sources := configWithSources(installScope, "")
+
+	fmt.Printf("Scope: %s\n\n", installScope)
+	for _, s := range sources {
+		fmt.Printf("%-15s = %-40s (%s)\n", s.Key, s.Value, s.Source)
+	}
+	return nil
 }
 
 func preInstall(config types.Config) error {
@@ -228,19 +341,28 @@ func preInstall(config types.Config) error {
 	if err != nil {
 		return err
 	}
-	err = fileutil.Copy(config.InputPath, config.ExecDir)
-	if err != nil {
-		return err
-	}
 	return nil
 }
 
-func findInternalDesktop(path string) string {
-	desktopPath, err := fileutil.FindFile(path, []string{".desktop"})
+// findInternalDesktop returns the desktop entry of an extracted AppImage.
+// Symlinks are resolved inside the extraction directory, entries pointing
+// out of it are skipped.
+func findInternalDesktop(path string) (string, error) {
+	desktopPaths, err := fileutil.FindFiles(path, []string{".desktop"})
 	if err != nil {
-		log.Fatal("failed to find desktop file: ", err)
+		return "", fmt.Errorf("failed to find desktop file: %w", err)
+	}
+	for _, desktopPath := range desktopPaths {
+		resolved, err := fileutil.ResolveIn(path, desktopPath)
+		if err != nil {
+			fmt.Println("skipping desktop file:", err)
+			continue
+		}
+		if info, err := os.Stat(resolved); err == nil && info.Mode().IsRegular() {
+			return resolved, nil
+		}
 	}
-	return desktopPath
+	return "", fmt.Errorf("failed to find desktop file in %s", path)
 }
 
 func generateDesktopFile(path string) (*desktop.DesktopFile, error) {
@@ -252,189 +374,326 @@ func generateDesktopFile(path string) (*desktop.DesktopFile, error) {
 	return deskFile, nil
 }
 
+// checkDesktopEntry applies the verification policy: unless it is strict,
+// what can be repaired without changing the meaning of the entry is
+// repaired. Remaining errors, and under the strict policy any problem, fail
+// the check; the warn policy only reports them.
+func checkDesktopEntry(deskFile *desktop.DesktopFile, policy string) error {
+	if policy != types.VerifyStrict {
+		for _, problem := range deskFile.Repair() {
+			fmt.Println("repaired desktop entry:", problem)
+		}
+	}
+
+	report := deskFile.Validate()
+	failing := report.Errors()
+	if policy == types.VerifyStrict {
+		failing = report.Problems
+	} else {
+		for _, problem := range report.Warnings() {
+			fmt.Println("desktop entry:", problem)
+		}
+	}
+	if len(failing) == 0 {
+		return nil
+	}
+
+	var lines []string
+	for _, problem := range failing {
+		lines = append(lines, problem.String())
+	}
+	if policy == types.VerifyWarn {
+		for _, line := range lines {
+			fmt.Println("desktop entry:", line)
+		}
+		return nil
+	}
+	return fmt.Errorf("invalid desktop entry %s:\n  %s", filepath.Base(deskFile.GetSource()), strings.Join(lines, "\n  "))
+}
+
 func editDesktop(deskFile *desktop.DesktopFile, config types.Config) {
-	execPath := filepath.Join(config.ExecDir, config.InputFileName)
-	deskFile.Category("Desktop Entry").Set("Exec", execPath)
+	execPath := config.ExecPath
+
+	for _, category := range deskFile.Categories() {
+		if category != "Desktop Entry" && !strings.HasPrefix(category, "Desktop Action ") {
+			continue
+		}
+		if category != "Desktop Entry" && !deskFile.HasValues(category, []string{"Exec"}) {
+			continue
+		}
+		if err := deskFile.Category(category).SetExecProgram(execPath); err != nil {
+			fmt.Printf("failed to rewrite Exec in [%s]: %v\n", category, err)
+		}
+	}
+
+	if deskFile.HasValues("Desktop Entry", []string{"TryExec"}) {
+		deskFile.Category("Desktop Entry").SetString("TryExec", execPath)
+	}
 }
 
-func copyImage(deskFile *desktop.DesktopFile, config types.Config) error {
-	icon, err := deskFile.Category("Desktop Entry").Get("Icon")
-	if err != nil || icon == "" {
-		priorityDirs := []string{
-			filepath.Join(config.AppExtractDir, "usr/share/icons"),
-			filepath.Join(config.AppExtractDir, "usr/share/pixmaps"),
-			filepath.Join(config.AppExtractDir, ".DirIcon"),
-			config.AppExtractDir,
+// chooseIcon picks the icon to install: the file given with --icon, or the
+// best ranked icon of the extracted AppImage.
+func chooseIcon(deskFile *desktop.DesktopFile, config types.Config) (icons.Candidate, error) {
+	if config.IconPath != "" {
+		if config.Debug {
+			fmt.Println("icon: using", config.IconPath, "given with --icon")
 		}
-		
-		for _, dir := range priorityDirs {
-			if _, statErr := os.Stat(dir); statErr == nil {
-				iconFiles, _ := fileutil.FindFiles(dir, []string{".png", ".svg", ".xpm", ".ico"})
-				if len(iconFiles) > 0 {
-					icon = iconFiles[0]
-					break
-				}
-			} else if strings.HasSuffix(dir, ".DirIcon") {
-				if _, statErr := os.Stat(filepath.Join(config.AppExtractDir, ".DirIcon")); statErr == nil {
-					icon = filepath.Join(config.AppExtractDir, ".DirIcon")
-					break
-				}
-			}
+		return icons.Candidate{Path: config.IconPath, Rel: config.IconPath}, nil
+	}
+
+	icon, _ := deskFile.Category("Desktop Entry").Get("Icon")
+	desktopName := strings.TrimSuffix(filepath.Base(deskFile.GetSource()), ".desktop")
+	candidates, err := icons.Resolve(config.AppExtractDir, icon, []string{desktopName, config.AppID})
+	if err != nil {
+		return icons.Candidate{}, err
+	}
+	if config.Debug {
+		fmt.Println("icon: chose", candidates[0])
+		for _, c := range candidates[1:min(len(candidates), 6)] {
+			fmt.Println("  over", c)
 		}
-		
-		if icon == "" {
-			iconFiles, _ := fileutil.FindFiles(config.AppExtractDir, []string{".png", ".svg", ".xpm", ".ico"})
-			if len(iconFiles) > 0 {
-				icon = iconFiles[0]
-			} else {
-				return fmt.Errorf("no icon found in desktop file or directory")
-			}
+	}
+	return candidates[0], nil
+}
+
+// installIcons installs the application's icon into the host's hicolor
+// theme under a namespaced name and points Icon= at that name. When the
+// chosen icon is part of the AppImage's hicolor theme, every size of it is
+// installed and missing standard sizes are generated from the largest. Any
+// other icon is converted to PNGs at the standard sizes, or if it cannot be
+// decoded, copied next to the unthemed icons. It returns the installed files.
+func installIcons(deskFile *desktop.DesktopFile, config types.Config) ([]string, error) {
+	name := icons.Namespaced(config.AppID)
+	themeDir := config.Path(config.IconThemeDir)
+
+	var installed []string
+	record := func(paths []string) {
+		for _, path := range paths {
+			installed = append(installed, filepath.Join(config.IconThemeDir, path))
 		}
 	}
 
-	newPath := filepath.Join(config.ImgPath, filepath.Base(icon))
-	
-	if filepath.IsAbs(icon) {
-		iconPath := filepath.Join(config.AppExtractDir, icon)
-		err = fileutil.Copy(iconPath, newPath)
+	source, err := chooseIcon(deskFile, config)
+	if err != nil {
+		return nil, err
+	}
+
+	if themed := icons.FindThemed(config.AppExtractDir, source.ThemeName); len(themed) > 0 {
+		paths, err := icons.Install(themed, themeDir, name)
+		record(paths)
 		if err != nil {
-			iconName := filepath.Base(icon)
-			possiblePaths := []string{
-				filepath.Join(config.AppExtractDir, iconName),
-				filepath.Join(config.AppExtractDir, "usr/share/icons", iconName),
-				filepath.Join(config.AppExtractDir, "usr/share/pixmaps", iconName),
-				filepath.Join(config.AppExtractDir, ".DirIcon"),
-			}
-			
-			for _, path := range possiblePaths {
-				if _, statErr := os.Stat(path); statErr == nil {
-					err = fileutil.Copy(path, newPath)
-					if err == nil {
-						deskFile.Category("Desktop Entry").Set("Icon", newPath)
-						return nil
-					}
-				}
-			}
-			
-			if _, err := os.Stat(icon); err == nil {
-				err = fileutil.Copy(icon, newPath)
-				if err == nil {
-					deskFile.Category("Desktop Entry").Set("Icon", newPath)
-					return nil
-				}
-			}
-			
-			return fmt.Errorf("failed to copy icon: %v", err)
-		}
-		deskFile.Category("Desktop Entry").Set("Icon", newPath)
-	} else {
-		iconPath := ""
-		possiblePaths := []string{
-			filepath.Join(config.AppExtractDir, icon),
-			filepath.Join(config.AppExtractDir, "usr/share/icons", icon),
-			filepath.Join(config.AppExtractDir, "usr/share/pixmaps", icon),
+			return installed, err
 		}
-		
-		extensions := []string{"", ".png", ".svg", ".xpm", ".ico"}
-		
-		for _, path := range possiblePaths {
-			for _, ext := range extensions {
-				fullPath := path + ext
-				if _, statErr := os.Stat(fullPath); statErr == nil {
-					iconPath = fullPath
-					break
-				}
-			}
-			if iconPath != "" {
-				break
-			}
+		paths, err = icons.FillSizes(themed, themeDir, name)
+		record(paths)
+		if err != nil {
+			fmt.Println("failed to generate icon sizes:", err)
 		}
-		
-		if iconPath != "" {
-			err = fileutil.Copy(iconPath, newPath)
-			if err == nil {
-				deskFile.Category("Desktop Entry").Set("Icon", newPath)
-				return nil
-			}
-		} else {
-			err = fileutil.Copy(icon, newPath)
-			if err == nil {
-				deskFile.Category("Desktop Entry").Set("Icon", newPath)
-				return nil
+	} else {
+		paths, err := icons.InstallFile(source.Path, themeDir, name)
+		record(paths)
+		if err != nil {
+			fmt.Println(err)
+			newPath := filepath.Join(config.ImgPath, name+filepath.Ext(source.Path))
+			if err := fileutil.Copy(source.Path, config.Path(newPath)); err != nil {
+				return installed, fmt.Errorf("failed to copy icon: %v", err)
 			}
+			installed = append(installed, newPath)
 		}
 	}
-	
-	if icon != "" && err == nil {
-		deskFile.Category("Desktop Entry").Set("Icon", newPath)
-		return nil
-	}
-	
-	return fmt.Errorf("failed to find or copy icon: %v", err)
+
+	deskFile.Category("Desktop Entry").Set("Icon", name)
+	return installed, nil
 }
 
-func install(config types.Config, autostart bool) {
+func install(config types.Config, autostart bool) error {
 	err := preInstall(config)
 	if err != nil {
-		log.Fatal("setup failed: ", err)
+		return fmt.Errorf("setup failed: %w", err)
+	}
+	if err := os.Chdir(config.ExtractDir); err != nil {
+		return err
+	}
+	if err := extractApp(config); err != nil {
+		return err
+	}
+	desktopPath, err := findInternalDesktop(config.AppExtractDir)
+	if err != nil {
+		return err
+	}
+	deskFile, err := loadInternalDesktop(desktopPath, config)
+	if err != nil {
+		return err
+	}
+	if config.AppID == "" {
+		name, _ := deskFile.Category("Desktop Entry").Get("Name")
+		config.AppID = appid.Derive(config.AppExtractDir, desktopPath, name)
+	}
+	m := manager.New(config)
+	config.ExecPath = m.CurrentPath(config.AppID)
+	entry := newRegistryEntry(deskFile, config)
+	err = m.AddVersion(entry, config.InputPath, config.KeepVersions)
+	if err != nil {
+		return fmt.Errorf("failed to copy AppImage: %w", err)
+	}
+	err = integrate(deskFile, config, entry, autostart)
+	if err != nil {
+		return err
+	}
+
+	err = m.Register(entry)
+	if err != nil {
+		return fmt.Errorf("failed to record installation: %w", err)
 	}
-	os.Chdir(config.ExtractDir)
-	extractApp(config.InputPath)
-	desktopPath := findInternalDesktop(config.AppExtractDir)
+	postTransaction(config)
+	return nil
+}
+
+// loadInternalDesktop parses the desktop entry found in an extracted
+// AppImage and checks it against the verify policy.
+func loadInternalDesktop(desktopPath string, config types.Config) (*desktop.DesktopFile, error) {
 	deskFile, err := generateDesktopFile(desktopPath)
 	if err != nil {
-		log.Fatal("failed to parse desktop file: ", err)
+		return nil, fmt.Errorf("failed to parse desktop file: %w", err)
 	}
+	err = checkDesktopEntry(deskFile, config.VerifyPolicy)
+	if err != nil {
+		return nil, fmt.Errorf("refusing to install: %w", err)
+	}
+	return deskFile, nil
+}
+
+// integrate installs the desktop entry and icons of the extracted AppImage
+// for entry, and its autostart entry if requested, recording the files in
+// entry.
+func integrate(deskFile *desktop.DesktopFile, config types.Config, entry *registry.Entry, autostart bool) error {
 	editDesktop(deskFile, config)
-	err = copyImage(deskFile, config)
+	var err error
+	entry.Files.Icons, err = installIcons(deskFile, config)
 	if err != nil {
 		fmt.Println(err)
 	}
-	err = deskFile.ToFile(filepath.Join(config.GnomeDesktopDir, filepath.Base(desktopPath)))
+	err = deskFile.ToFile(config.Path(entry.Files.Desktop))
 	if err != nil {
-		log.Fatal("failed to write desktop file ", err)
+		return fmt.Errorf("failed to write desktop file: %w", err)
 	}
 
+	entry.Files.Autostart = ""
 	if autostart {
-		err = deskFile.CreateAutostart(config.AutostartDir)
+		autostartPath, err := deskFile.CreateAutostart(config.Path(config.AutostartDir), config.AppID)
 		if err != nil {
-			log.Fatal("failed to create autostart entry: ", err)
+			return fmt.Errorf("failed to create autostart entry: %w", err)
 		}
+		entry.Files.Autostart = filepath.Join(config.AutostartDir, filepath.Base(autostartPath))
 	}
+	return nil
 }
 
-func runInstallScript(appPath string) error {
-	if err := checkDependencies(); err != nil {
-		return err
+// postTransaction runs after applications were installed, updated or
+// removed, so that running desktops pick up their MIME types and icons
+// without logging out. Failures are reported but do not undo the change.
+func postTransaction(config types.Config) {
+	hooks := []struct {
+		name string
+		run  func() error
+	}{
+		{"update MIME cache", func() error {
+			return desktop.UpdateMimeCache(config.Path(config.GnomeDesktopDir))
+		}},
+		{"update icon cache", func() error {
+			return icons.UpdateCache(config.Path(config.IconThemeDir))
+		}},
+		{"touch directories", func() error {
+			for _, dir := range []string{config.GnomeDesktopDir, config.ImgPath} {
+				if _, err := os.Stat(config.Path(dir)); err != nil {
+					continue
+				}
+				if err := fileutil.Touch(config.Path(dir)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}},
 	}
 
-	path, _ := filepath.Abs(appPath)
-	_, err := os.Stat(path)
-	if err != nil {
-		return fmt.Errorf("install %s: %w", appPath, err)
+	for _, hook := range hooks {
+		if err := hook.run(); err != nil {
+			fmt.Printf("failed to %s: %v\n", hook.name, err)
+		}
 	}
-	config := setConfig(path)
-	err = os.RemoveAll(config.ExtractDir)
+}
+
+func newRegistryEntry(deskFile *desktop.DesktopFile, config types.Config) *registry.Entry {
+	name, _ := deskFile.Category("Desktop Entry").Get("Name")
+	version, _ := deskFile.Category("Desktop Entry").Get("X-AppImage-Version")
+	sum, err := fileutil.SHA256(config.InputPath)
 	if err != nil {
-		return fmt.Errorf("removing extract directory: %w", err)
+		log.Fatal("failed to hash AppImage: ", err)
 	}
-	install(config, false)
-	err = os.RemoveAll(config.ExtractDir)
-	if err != nil {
-		return fmt.Errorf("removing extract directory: %w", err)
+
+	var updateInfo string
+	if app, err := appimage.Open(config.InputPath); err == nil {
+		updateInfo = app.UpdateInfo
+		app.Close()
+	}
+
+	installUser := os.Getenv("SUDO_USER")
+	if installUser == "" {
+		if current, err := user.Current(); err == nil {
+			installUser = current.Username
+		}
+	}
+
+	source := config.InputPath
+	if config.Source != "" {
+		source = config.Source
+	}
+
+	return &registry.Entry{
+		ID:         config.AppID,
+		Name:       name,
+		Version:    version,
+		SourcePath: source,
+		SHA256:     sum,
+		Files: registry.Files{
+			AppImage: config.ExecPath,
+			Desktop:  filepath.Join(config.GnomeDesktopDir, config.AppID+".desktop"),
+		},
+		InstalledAt: time.Now().UTC(),
+		User:        installUser,
+		UpdateInfo:  updateInfo,
+		ConfigDirs:  []string{config.AppID},
 	}
-	return nil
 }
 
 func help() {
 	fmt.Println("Usage: sudo appinstaller [OPTIONS]")
+	fmt.Println("       appinstaller --user [OPTIONS]")
 	fmt.Println("\nOptions:")
-	fmt.Println("  -l, --list            List installed apps (from this tool only)")
-	fmt.Println("  -d, --delete <name>   Delete the specified app (installed by this tool)")
+	fmt.Println("  --user                Install into and manage your own XDG directories, without sudo")
+	fmt.Println("  --system              Only manage system-wide apps (list and delete cover both scopes by default)")
+	fmt.Println("  --root <dir>          Install below <dir>, e.g. a chroot; entries keep the paths as seen from inside it")
+	fmt.Println("  --verbose             Explain decisions such as which icon was chosen")
+	fmt.Println("  -l, --list            List installed apps of both scopes (from this tool only)")
+	fmt.Println("  -d, --delete <id>     Delete the specified app (installed by this tool)")
+	fmt.Println("  update <id> <path>    Replace an installed app with a new AppImage, keeping its autostart state")
+	fmt.Println("  check-updates         Check installed apps for updates using their embedded update information")
+	fmt.Println("  upgrade [id]          Download and install available updates (all apps if no ID is given)")
+	fmt.Println("  versions <id>         List the kept versions of an installed app")
+	fmt.Println("  rollback <id> [ver]   Switch an app back to a kept version (the previous one by default)")
+	fmt.Println("  --keep-versions <n>   Number of versions to keep per app, default 3 (use with --install or update)")
+	fmt.Println("  --keep-config         Keep the user's ~/.config/<id> directory (default, use with --delete)")
+	fmt.Println("  --purge               Also remove the user's ~/.config/<id> directory after asking (use with --delete)")
 	fmt.Println("  -h, --help            Show this help message")
 	fmt.Println("  -v, --version         Show version information")
 	fmt.Println("  -i, --install <path>  Install the specified app")
 	fmt.Println("  -a, --autostart       Add to autostart (use with --install)")
+	fmt.Println("  --icon <file>         Install <file> as the icon instead of choosing one from the AppImage (use with --install)")
+	fmt.Println("  --no-autostart        Do not add to autostart, even if the configuration says so (use with --install)")
+	fmt.Println("  --allow-exec-extract  Allow running the AppImage as an unprivileged user to extract it")
+	fmt.Println("                        when it cannot be read directly (use with --install)")
+	fmt.Println("  validate <path>       Check the desktop entry of an AppImage or .desktop file")
+	fmt.Println("  config show           Print the effective configuration and where each value comes from")
 }
 
 func checkFzf() bool {
@@ -442,17 +701,98 @@ func checkFzf() bool {
 	return err == nil
 }
 
-func listingWithFzf(m *manager.Manager, entries []*desktop.DesktopFile) error {
-	var items []string
-	for _, entry := range entries {
-		name, _ := entry.Category("Desktop Entry").Get("Name")
-		exec, _ := entry.Category("Desktop Entry").Get("Exec")
-		execPath := strings.Split(exec, " ")[0]
-		isAutostart := "[ ]"
-		if _, err := os.Stat(filepath.Join(m.Config().AutostartDir, fmt.Sprintf("%s.desktop", strings.ToLower(strings.ReplaceAll(name, " ", "-"))))); err == nil {
-			isAutostart = "[*]"
+func autostartMark(m *manager.Manager, entry *registry.Entry) string {
+	if m.IsAutostart(entry) {
+		return "[*]"
+	}
+	return "[ ]"
+}
+
+func toggleAutostart(m *manager.Manager, entry *registry.Entry) error {
+	label := m.DisplayName(entry)
+	if m.IsAutostart(entry) {
+		if err := m.SetAutostart(entry, false); err != nil {
+			fmt.Printf("Error removing from autostart: %v\n", err)
+			return err
+		}
+		fmt.Printf("Removed '%s' from autostart\n", label)
+		return nil
+	}
+
+	if err := m.SetAutostart(entry, true); err != nil {
+		fmt.Printf("Error adding to autostart: %v\n", err)
+		return err
+	}
+	fmt.Printf("Added '%s' to autostart\n", label)
+	return nil
+}
+
+// installedApp is an installed application together with the manager of
+// its scope.
+type installedApp struct {
+	manager *manager.Manager
+	entry   *registry.Entry
+}
+
+func (app installedApp) scope() string {
+	return app.manager.Config().Scope
+}
+
+func installedApps() ([]installedApp, error) {
+	var apps []installedApp
+	for _, s := range scopes() {
+		m := manager.New(scopeConfig(s, ""))
+		entries, err := m.List()
+		if err != nil {
+			return nil, fmt.Errorf("%s applications: %w", s, err)
+		}
+		for _, entry := range entries {
+			apps = append(apps, installedApp{manager: m, entry: entry})
+		}
+	}
+	return apps, nil
+}
+
+// findApp looks an application up in every scope list and delete cover.
+func findApp(appID string) (installedApp, error) {
+	var found []installedApp
+	var firstErr error
+	for _, s := range scopes() {
+		m := manager.New(scopeConfig(s, ""))
+		entry, err := m.Find(appID)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
 		}
-		items = append(items, fmt.Sprintf("%s %-30s | %s", isAutostart, name, execPath))
+		found = append(found, installedApp{manager: m, entry: entry})
+	}
+
+	switch len(found) {
+	case 0:
+		return installedApp{}, firstErr
+	case 1:
+		return found[0], nil
+	}
+	return installedApp{}, fmt.Errorf("%s is installed both system-wide and for the user, choose one with --system or --user", appID)
+}
+
+func deleteInstalled(app installedApp, purge bool) (*manager.Removal, error) {
+	if app.scope() == types.ScopeSystem && !hasPrivileges() {
+		return nil, fmt.Errorf("removing system-wide application %s requires superuser privileges", app.entry.ID)
+	}
+	removal, err := app.manager.Delete(app.entry.ID, purge)
+	if removal != nil {
+		postTransaction(app.manager.Config())
+	}
+	return removal, err
+}
+
+func listingWithFzf(apps []installedApp) error {
+	var items []string
+	for _, app := range apps {
+		items = append(items, fmt.Sprintf("%s %-30s | %-6s | %-30s | %s", autostartMark(app.manager, app.entry), app.entry.ID, app.scope(), app.manager.DisplayName(app.entry), app.entry.Files.AppImage))
 	}
 
 	cmd := exec.Command("fzf", "--header=Select application (Enter: toggle autostart, Del: delete, ESC: exit)", "--height=40%", "--bind=del:execute-silent(echo {+} > /tmp/to_delete)")
@@ -483,71 +823,61 @@ func listingWithFzf(m *manager.Manager, entries []*desktop.DesktopFile) error {
 		return nil
 	}
 
+	fields := strings.Split(selected[4:], "|")
+	if len(fields) < 2 {
+		return nil
+	}
+	id := strings.TrimSpace(fields[0])
+	appScope := strings.TrimSpace(fields[1])
+
+	var app *installedApp
+	for i := range apps {
+		if apps[i].entry.ID == id && apps[i].scope() == appScope {
+			app = &apps[i]
+		}
+	}
+	if app == nil {
+		return nil
+	}
+
 	if _, err := os.Stat("/tmp/to_delete"); err == nil {
 		os.Remove("/tmp/to_delete")
-		name := strings.TrimSpace(strings.Split(selected[4:], "|")[0])
-		fmt.Printf("Deleting application '%s'... ", name)
-		if err := m.Delete(name); err != nil {
+		fmt.Printf("Deleting application '%s'... ", id)
+		removal, err := deleteInstalled(*app, false)
+		if err != nil {
 			fmt.Printf("error: %v\n", err)
 			return err
 		}
 		fmt.Println("success")
+		printRemoval(removal)
 		return nil
 	}
 
-	name := strings.TrimSpace(strings.Split(selected[4:], "|")[0])
-	autostartPath := filepath.Join(m.Config().AutostartDir, fmt.Sprintf("%s.desktop", strings.ToLower(strings.ReplaceAll(name, " ", "-"))))
-	
-	for _, entry := range entries {
-		entryName, _ := entry.Category("Desktop Entry").Get("Name")
-		if entryName == name {
-			if _, err := os.Stat(autostartPath); err == nil {
-				if err := os.Remove(autostartPath); err != nil {
-					fmt.Printf("Error removing from autostart: %v\n", err)
-					return err
-				}
-				fmt.Printf("Removed '%s' from autostart\n", name)
-			} else {
-				if err := entry.CreateAutostart(m.Config().AutostartDir); err != nil {
-					fmt.Printf("Error adding to autostart: %v\n", err)
-					return err
-				}
-				fmt.Printf("Added '%s' to autostart\n", name)
-			}
-			break
-		}
-	}
-	return nil
+	return toggleAutostart(app.manager, app.entry)
 }
 
 func listing() error {
-	config := setConfig("")
-	m := manager.New(config)
-	entries := m.List()
+	apps, err := installedApps()
+	if err != nil {
+		return err
+	}
 	
-	if len(entries) == 0 {
+	if len(apps) == 0 {
 		fmt.Println("No installed applications found")
 		return nil
 	}
 
 	if checkFzf() {
-		return listingWithFzf(m, entries)
+		return listingWithFzf(apps)
 	}
 
-	fmt.Printf("\n%-4s | %-4s | %-30s | %-50s\n", "#", "Auto", "Name", "Executable Path")
-	fmt.Println(strings.Repeat("-", 95))
+	fmt.Printf("\n%-4s | %-6s | %-4s | %-30s | %-30s | %-50s\n", "#", "Scope", "Auto", "ID", "Name", "Executable Path")
+	fmt.Println(strings.Repeat("-", 137))
 
-	for i, entry := range entries {
-		name, _ := entry.Category("Desktop Entry").Get("Name")
-		exec, _ := entry.Category("Desktop Entry").Get("Exec")
-		execPath := strings.Split(exec, " ")[0]
-		isAutostart := "[ ]"
-		if _, err := os.Stat(filepath.Join(m.Config().AutostartDir, fmt.Sprintf("%s.desktop", strings.ToLower(strings.ReplaceAll(name, " ", "-"))))); err == nil {
-			isAutostart = "[*]"
-		}
-		fmt.Printf("%-4d | %-4s | %-30s | %-50s\n", i+1, isAutostart, name, execPath)
+	for i, app := range apps {
+		fmt.Printf("%-4d | %-6s | %-4s | %-30s | %-30s | %-50s\n", i+1, app.scope(), autostartMark(app.manager, app.entry), app.entry.ID, app.manager.DisplayName(app.entry), app.entry.Files.AppImage)
 	}
-	fmt.Println(strings.Repeat("-", 95))
+	fmt.Println(strings.Repeat("-", 137))
 
 	fmt.Print("\nEnter application number to manage (or 'q' to exit): ")
 	var input string
@@ -557,9 +887,9 @@ func listing() error {
 		return nil
 	}
 
-	if num, err := strconv.Atoi(input); err == nil && num > 0 && num <= len(entries) {
-		name, _ := entries[num-1].Category("Desktop Entry").Get("Name")
-		autostartPath := filepath.Join(m.Config().AutostartDir, fmt.Sprintf("%s.desktop", strings.ToLower(strings.ReplaceAll(name, " ", "-"))))
+	if num, err := strconv.Atoi(input); err == nil && num > 0 && num <= len(apps) {
+		app := apps[num-1]
+		label := app.manager.DisplayName(app.entry)
 		
 		fmt.Print("Choose action ([d]elete, [t]oggle autostart): ")
 		var action string
@@ -567,26 +897,16 @@ func listing() error {
 
 		switch action {
 		case "d":
-			fmt.Printf("Deleting application '%s'... ", name)
-			if err := m.Delete(name); err != nil {
+			fmt.Printf("Deleting application '%s'... ", label)
+			removal, err := deleteInstalled(app, false)
+			if err != nil {
 				fmt.Printf("error: %v\n", err)
 				return err
 			}
 			fmt.Println("success")
+			printRemoval(removal)
 		case "t":
-			if _, err := os.Stat(autostartPath); err == nil {
-				if err := os.Remove(autostartPath); err != nil {
-					fmt.Printf("Error removing from autostart: %v\n", err)
-					return err
-				}
-				fmt.Printf("Removed '%s' from autostart\n", name)
-			} else {
-				if err := entries[num-1].CreateAutostart(m.Config().AutostartDir); err != nil {
-					fmt.Printf("Error adding to autostart: %v\n", err)
-					return err
-				}
-				fmt.Printf("Added '%s' to autostart\n", name)
-			}
+			return toggleAutostart(app.manager, app.entry)
 		default:
 			fmt.Println("Invalid action")
 		}
@@ -597,10 +917,465 @@ func listing() error {
 	return nil
 }
 
-func deleteApp(appName string) error {
-	config := setConfig("")
-	m := manager.New(config)
-	return m.Delete(appName)
+// loadDesktopEntry reads a .desktop file, or the top-level desktop entry of
+// an AppImage.
+func loadDesktopEntry(path string) (*desktop.DesktopFile, error) {
+	deskFile := desktop.New()
+	if strings.HasSuffix(path, ".desktop") {
+		return deskFile, deskFile.FromFile(path)
+	}
+
+	app, err := appimage.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer app.Close()
+
+	fsys, err := app.FS()
+	if err != nil {
+		return nil, err
+	}
+	matches, err := fs.Glob(fsys, "*.desktop")
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("no desktop entry found in %s", path)
+	}
+
+	data, err := fs.ReadFile(fsys, matches[0])
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", matches[0], err)
+	}
+	return deskFile, deskFile.FromBytes(data, path+":"+matches[0])
+}
+
+func validate(path string) error {
+	deskFile, err := loadDesktopEntry(path)
+	if err != nil {
+		return err
+	}
+
+	report := deskFile.Validate()
+	if len(report.Problems) > 0 {
+		fmt.Println(report)
+	}
+	if report.HasErrors() {
+		return fmt.Errorf("%s: %d error(s), %d warning(s)", deskFile.GetSource(), len(report.Errors()), len(report.Warnings()))
+	}
+	return nil
+}
+
+// runInstall installs from a private work directory, see inWorkDir.
+func runInstall(config types.Config, autostart bool) error {
+	return inWorkDir(config, func(config types.Config) error {
+		return install(config, autostart)
+	})
+}
+
+// inWorkDir runs fn with a private work directory created inside the
+// extract directory as config.ExtractDir; only that work directory is
+// removed afterwards.
+func inWorkDir(config types.Config, fn func(config types.Config) error) error {
+	if err := checkExtractDir(config.ExtractDir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(config.ExtractDir, 0755); err != nil {
+		return fmt.Errorf("creating extract directory: %w", err)
+	}
+	if err := checkExtractDirOwner(config.ExtractDir); err != nil {
+		return err
+	}
+	workDir, err := os.MkdirTemp(config.ExtractDir, workDirPrefix)
+	if err != nil {
+		return fmt.Errorf("creating work directory: %w", err)
+	}
+	config.ExtractDir = workDir
+	config.AppExtractDir = filepath.Join(workDir, "squashfs-root")
+
+	fnErr := fn(config)
+	err = os.RemoveAll(workDir)
+	if fnErr != nil {
+		return fnErr
+	}
+	if err != nil {
+		return fmt.Errorf("removing work directory: %w", err)
+	}
+	return nil
+}
+
+const workDirPrefix = "appinstaller-"
+
+// checkExtractDir refuses extract directories whose contents are not ours:
+// the filesystem root, the home directory or one above it, and directories
+// holding anything but work directories of earlier installs.
+func checkExtractDir(dir string) error {
+	dir = filepath.Clean(dir)
+	if dir == "/" {
+		return fmt.Errorf("refusing to use / as extract directory")
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		home = filepath.Clean(home)
+		if home == dir || strings.HasPrefix(home, dir+"/") {
+			return fmt.Errorf("refusing to use %s as extract directory, it contains the home directory", dir)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading extract directory: %w", err)
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), workDirPrefix) {
+			return fmt.Errorf("refusing to use %s as extract directory, it is not empty (found %s)", dir, entry.Name())
+		}
+	}
+	return nil
+}
+
+// checkExtractDirOwner refuses an extract directory that someone else could
+// swap work directories in: a symlink, one owned by another user, or one that
+// is group or world writable.
+func checkExtractDirOwner(dir string) error {
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return fmt.Errorf("reading extract directory: %w", err)
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		return fmt.Errorf("refusing to use %s as extract directory, it is a symlink", dir)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("refusing to use %s as extract directory, it is not a directory", dir)
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); !ok || int(stat.Uid) != os.Geteuid() {
+		return fmt.Errorf("refusing to use %s as extract directory, it is owned by another user", dir)
+	}
+	if info.Mode().Perm()&0o022 != 0 {
+		return fmt.Errorf("refusing to use %s as extract directory, it is writable by other users (mode %04o)", dir, info.Mode().Perm())
+	}
+	return nil
+}
+
+// updateApp installs a new AppImage over an installed application, keeping its
+// ID and autostart state. Files of the old version are removed once the new
+// one is in place. A non-empty source is recorded as where the AppImage came
+// from instead of appPath.
+func updateApp(installScope, appID, appPath, source string, allowExecExtract bool, keepVersions int) error {
+	path, _ := filepath.Abs(appPath)
+	_, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("update %s: %w", appPath, err)
+	}
+
+	m := manager.New(scopeConfig(installScope, ""))
+	entry, err := m.Find(appID)
+	if err != nil {
+		return err
+	}
+
+	sum, err := fileutil.SHA256(path)
+	if err != nil {
+		return err
+	}
+	if sum == entry.SHA256 {
+		fmt.Printf("%s is already installed from this AppImage\n", entry.ID)
+		return nil
+	}
+
+	config := scopeConfig(installScope, path)
+	config.AppID = entry.ID
+	config.Source = source
+	config.AllowExecExtract = allowExecExtract
+	if keepVersions > 0 {
+		config.KeepVersions = keepVersions
+	}
+	previousVersion := entry.Version
+	err = runInstall(config, m.IsAutostart(entry))
+	if err != nil {
+		return err
+	}
+
+	updated, err := manager.New(scopeConfig(installScope, "")).Find(entry.ID)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Updated %s %s -> %s\n", entry.ID, versionLabel(previousVersion), versionLabel(updated.Version))
+	return nil
+}
+
+// checkUpdates reports for the applications of every scope list covers
+// whether an update is available.
+func checkUpdates() error {
+	apps, err := installedApps()
+	if err != nil {
+		return err
+	}
+
+	client := update.NewClient()
+	for _, app := range apps {
+		entry := app.entry
+		if entry.UpdateInfo == "" {
+			fmt.Printf("%-30s %-6s no update information\n", entry.ID, app.scope())
+			continue
+		}
+
+		check, err := client.Check(entry.UpdateInfo, app.manager.Config().Path(entry.Files.AppImage))
+		switch {
+		case err != nil:
+			fmt.Printf("%-30s %-6s error: %v\n", entry.ID, app.scope(), err)
+		case check.Available:
+			fmt.Printf("%-30s %-6s update available: %s\n", entry.ID, app.scope(), check.Control.Filename)
+		default:
+			fmt.Printf("%-30s %-6s up to date\n", entry.ID, app.scope())
+		}
+	}
+	return nil
+}
+
+// upgrade downloads and installs available updates, for one application or
+// for all that carry update information, in every scope list covers. Only
+// blocks that differ from the installed AppImage are downloaded.
+func upgrade(appID string) error {
+	var apps []installedApp
+	if appID != "" {
+		app, err := findApp(appID)
+		if err != nil {
+			return err
+		}
+		if app.entry.UpdateInfo == "" {
+			return fmt.Errorf("%s has no update information", app.entry.ID)
+		}
+		apps = append(apps, app)
+	} else {
+		all, err := installedApps()
+		if err != nil {
+			return err
+		}
+		for _, app := range all {
+			if app.entry.UpdateInfo != "" {
+				apps = append(apps, app)
+			}
+		}
+	}
+
+	client := update.NewClient()
+	var failed []string
+	for _, app := range apps {
+		if err := upgradeApp(client, app); err != nil {
+			fmt.Printf("%s: %v\n", app.entry.ID, err)
+			failed = append(failed, app.entry.ID)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("upgrade failed for %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func upgradeApp(client *update.Client, app installedApp) error {
+	entry := app.entry
+	installed := app.manager.Config().Path(entry.Files.AppImage)
+	check, err := client.Check(entry.UpdateInfo, installed)
+	if err != nil {
+		return err
+	}
+	if !check.Available {
+		fmt.Printf("%s is up to date\n", entry.ID)
+		return nil
+	}
+
+	dir, err := os.MkdirTemp("", "appinstaller-upgrade-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	name := filepath.Base(check.Control.Filename)
+	if name == "." || name == "/" || name == "" {
+		name = entry.ID + ".AppImage"
+	}
+	dest := filepath.Join(dir, name)
+
+	fmt.Printf("Downloading %s...\n", name)
+	stats, err := client.Download(check, installed, dest)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Reused %d of %d blocks, downloaded %d bytes\n", stats.Reused, stats.Blocks, stats.Downloaded)
+
+	source, err := check.TargetURL()
+	if err != nil {
+		return err
+	}
+	return updateApp(app.scope(), entry.ID, dest, source, false, 0)
+}
+
+func versionLabel(version string) string {
+	if version == "" {
+		return "(unknown version)"
+	}
+	return version
+}
+
+func listVersions(appID string) error {
+	app, err := findApp(appID)
+	if err != nil {
+		return err
+	}
+	entry := app.entry
+	if len(entry.Versions) == 0 {
+		fmt.Printf("%s has no kept versions\n", entry.ID)
+		return nil
+	}
+
+	fmt.Printf("Versions of %s:\n", entry.ID)
+	for i := len(entry.Versions) - 1; i >= 0; i-- {
+		v := entry.Versions[i]
+		mark := " "
+		if v.Name == entry.Current {
+			mark = "*"
+		}
+		fmt.Printf("%s %-20s %s  %s\n", mark, v.Name, v.InstalledAt.Local().Format("2006-01-02 15:04"), v.SourcePath)
+	}
+	return nil
+}
+
+// rollback makes an earlier version current and reinstalls the desktop
+// entry and icons from that version's AppImage, so they match it again.
+func rollback(appID, version string) error {
+	m := manager.New(setConfig(""))
+	target, err := m.Rollback(appID, version)
+	if err != nil {
+		return err
+	}
+	entry, err := m.Find(appID)
+	if err != nil {
+		return err
+	}
+
+	config := setConfig(m.Config().Path(target.Path))
+	config.AppID = entry.ID
+	config.ExecPath = m.CurrentPath(entry.ID)
+	err = inWorkDir(config, func(config types.Config) error {
+		return reintegrate(m, entry, config)
+	})
+	if err != nil {
+		return fmt.Errorf("rolled back %s to %s, but restoring its desktop integration failed: %w", entry.ID, target.Name, err)
+	}
+	fmt.Printf("Rolled back %s to %s\n", entry.ID, target.Name)
+	return nil
+}
+
+// reintegrate extracts the installed AppImage at config.InputPath and
+// replaces the desktop entry, icons and autostart entry of entry with its
+// own. Files the previous version installed and this one does not are
+// removed.
+func reintegrate(m *manager.Manager, entry *registry.Entry, config types.Config) error {
+	if err := createDirectories(config); err != nil {
+		return err
+	}
+	if err := os.Chdir(config.ExtractDir); err != nil {
+		return err
+	}
+	if err := extractApp(config); err != nil {
+		return err
+	}
+	desktopPath, err := findInternalDesktop(config.AppExtractDir)
+	if err != nil {
+		return err
+	}
+	deskFile, err := loadInternalDesktop(desktopPath, config)
+	if err != nil {
+		return err
+	}
+
+	autostart := m.IsAutostart(entry)
+	updated := *entry
+	updated.Files.Icons = nil
+	if err := integrate(deskFile, config, &updated, autostart); err != nil {
+		return err
+	}
+	updated.Name, _ = deskFile.Category("Desktop Entry").Get("Name")
+	if err := m.Register(&updated); err != nil {
+		return err
+	}
+	postTransaction(config)
+	return nil
+}
+
+// parseKeepVersions reads the value of --keep-versions.
+func parseKeepVersions(args []string, i int) (int, error) {
+	if i+1 >= len(args) {
+		return 0, fmt.Errorf("--keep-versions requires a number")
+	}
+	keep, err := strconv.Atoi(args[i+1])
+	if err != nil || keep < 1 {
+		return 0, fmt.Errorf("invalid --keep-versions value %s", args[i+1])
+	}
+	return keep, nil
+}
+
+func deleteApp(appName string, purge bool) error {
+	app, err := findApp(appName)
+	if err != nil {
+		return err
+	}
+	if purge {
+		purge = confirmPurge(app)
+	}
+	removal, err := deleteInstalled(app, purge)
+	if removal != nil {
+		printRemoval(removal)
+	}
+	return err
+}
+
+// confirmPurge lists the configuration directories --purge would remove and
+// asks before removing them. Candidates that do not exist are listed too, so
+// configuration kept under another name is not mistaken for removed.
+func confirmPurge(app installedApp) bool {
+	dirs, missing := app.manager.ConfigDirs(app.entry)
+	for _, dir := range missing {
+		fmt.Println("--purge: no configuration in", dir)
+	}
+	if len(dirs) == 0 {
+		fmt.Println("--purge found no configuration directory; configuration stored under other names is kept")
+		return false
+	}
+	fmt.Println("--purge will also remove:")
+	for _, dir := range dirs {
+		fmt.Println("  ", dir)
+	}
+	fmt.Print("Remove these directories? [y/N] ")
+	var answer string
+	fmt.Scanln(&answer)
+	if answer == "y" || answer == "Y" || answer == "yes" {
+		return true
+	}
+	fmt.Println("Keeping the configuration")
+	return false
+}
+
+func printRemoval(removal *manager.Removal) {
+	fmt.Printf("Removed %s (%s):\n", removal.Entry.Name, removal.Scope)
+	for _, path := range removal.Removed {
+		fmt.Println("  removed  ", path)
+	}
+	for _, path := range removal.Missing {
+		fmt.Println("  missing  ", path)
+	}
+	var shared []string
+	for path := range removal.Shared {
+		shared = append(shared, path)
+	}
+	sort.Strings(shared)
+	for _, path := range shared {
+		fmt.Printf("  kept      %s (used by %s)\n", path, strings.Join(removal.Shared[path], ", "))
+	}
 }
 
 func chooseScript() error {
@@ -614,9 +1389,21 @@ func chooseScript() error {
 		return listing()
 	case "-d", "--delete":
 		if len(os.Args) >= 3 {
-			return deleteApp(os.Args[2])
+			purge := false
+			for _, arg := range os.Args[3:] {
+				switch arg {
+				case "--purge":
+					purge = true
+				case "--keep-config":
+					purge = false
+				default:
+					help()
+					return fmt.Errorf("unknown delete option %s", arg)
+				}
+			}
+			return deleteApp(os.Args[2], purge)
 		}
-		fmt.Println("Error: Application name required for delete operation")
+		fmt.Println("Error: Application ID required for delete operation")
 		help()
 		return fmt.Errorf("missing application name")
 	case "-h", "--help":
@@ -625,15 +1412,51 @@ func chooseScript() error {
 	case "-v", "--version":
 		fmt.Println("1.0")
 		return nil
+	case "validate":
+		if len(os.Args) < 3 {
+			fmt.Println("Error: AppImage or .desktop path required for validate")
+			help()
+			return fmt.Errorf("missing path")
+		}
+		return validate(os.Args[2])
 	case "-i", "--install":
 		if len(os.Args) < 3 {
 			fmt.Println("Error: Application path required for install operation")
 			help()
 			return fmt.Errorf("missing application path")
 		}
-		autostart := false
-		if len(os.Args) > 3 && (os.Args[3] == "-a" || os.Args[3] == "--autostart") {
-			autostart = true
+		autostart, autostartSet := false, false
+		allowExecExtract := false
+		keepVersions := 0
+		iconPath := ""
+		for i := 3; i < len(os.Args); i++ {
+			switch os.Args[i] {
+			case "-a", "--autostart":
+				autostart, autostartSet = true, true
+			case "--no-autostart":
+				autostart, autostartSet = false, true
+			case "--allow-exec-extract":
+				allowExecExtract = true
+			case "--keep-versions":
+				keep, err := parseKeepVersions(os.Args, i)
+				if err != nil {
+					return err
+				}
+				keepVersions = keep
+				i++
+			case "--icon":
+				if i+1 >= len(os.Args) {
+					return fmt.Errorf("--icon requires a file")
+				}
+				i++
+				iconPath, _ = filepath.Abs(os.Args[i])
+				if info, err := os.Stat(iconPath); err != nil || !info.Mode().IsRegular() {
+					return fmt.Errorf("icon %s is not a file", os.Args[i])
+				}
+			default:
+				help()
+				return fmt.Errorf("unknown install option %s", os.Args[i])
+			}
 		}
 		path, _ := filepath.Abs(os.Args[2])
 		_, err := os.Stat(path)
@@ -641,22 +1464,104 @@ func chooseScript() error {
 			return fmt.Errorf("install %s: %w", os.Args[2], err)
 		}
 		config := setConfig(path)
-		err = os.RemoveAll(config.ExtractDir)
-		if err != nil {
-			return fmt.Errorf("removing extract directory: %w", err)
+		config.AllowExecExtract = allowExecExtract
+		config.IconPath = iconPath
+		if keepVersions > 0 {
+			config.KeepVersions = keepVersions
 		}
-		install(config, autostart)
-		err = os.RemoveAll(config.ExtractDir)
-		if err != nil {
-			return fmt.Errorf("removing extract directory: %w", err)
+		if !autostartSet {
+			autostart = config.Autostart
 		}
-		return nil
+		return runInstall(config, autostart)
+	case "config":
+		if len(os.Args) < 3 || os.Args[2] != "show" {
+			help()
+			return fmt.Errorf("unknown config command")
+		}
+		return showConfig()
+	case "check-updates":
+		return checkUpdates()
+	case "upgrade":
+		appID := ""
+		if len(os.Args) >= 3 {
+			appID = os.Args[2]
+		}
+		return upgrade(appID)
+	case "update":
+		if len(os.Args) < 4 {
+			fmt.Println("Error: Application ID and AppImage path required for update")
+			help()
+			return fmt.Errorf("missing arguments")
+		}
+		allowExecExtract := false
+		keepVersions := 0
+		for i := 4; i < len(os.Args); i++ {
+			switch os.Args[i] {
+			case "--allow-exec-extract":
+				allowExecExtract = true
+			case "--keep-versions":
+				keep, err := parseKeepVersions(os.Args, i)
+				if err != nil {
+					return err
+				}
+				keepVersions = keep
+				i++
+			default:
+				help()
+				return fmt.Errorf("unknown update option %s", os.Args[i])
+			}
+		}
+		return updateApp(setConfig("").Scope, os.Args[2], os.Args[3], "", allowExecExtract, keepVersions)
+	case "versions":
+		if len(os.Args) < 3 {
+			fmt.Println("Error: Application ID required for versions")
+			help()
+			return fmt.Errorf("missing application ID")
+		}
+		return listVersions(os.Args[2])
+	case "rollback":
+		if len(os.Args) < 3 {
+			fmt.Println("Error: Application ID required for rollback")
+			help()
+			return fmt.Errorf("missing application ID")
+		}
+		version := ""
+		if len(os.Args) >= 4 {
+			version = os.Args[3]
+		}
+		return rollback(os.Args[2], version)
 	default:
 		help()
 		return nil
 	}
 }
 
+// requiresSuperuser reports whether the requested command changes the
+// system; read-only commands and the user scope run as any user. List and
+// delete check per application, since they cover both scopes.
+func requiresSuperuser() bool {
+	if scope == types.ScopeUser {
+		return false
+	}
+	if len(os.Args) < 2 {
+		return true
+	}
+	switch os.Args[1] {
+	case "validate", "config", "versions", "check-updates", "-l", "--list", "-d", "--delete":
+		return false
+	}
+	return true
+}
+
+// hasPrivileges reports whether system-wide installs may be changed: as
+// superuser, or when the --root directory is writable.
+func hasPrivileges() bool {
+	if rootDir != "" {
+		return fileutil.Writable(rootDir)
+	}
+	return checkSuperuser()
+}
+
 func checkSuperuser() bool {
 	testDirs := []string{
 		"/usr/share/applications",
@@ -686,9 +1591,15 @@ func checkSuperuser() bool {
 }
 
 func main() {
-	if !checkSuperuser() {
+	if err := parseGlobalFlags(); err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+
+	if requiresSuperuser() && !hasPrivileges() {
 		fmt.Println("Error: This application requires superuser privileges")
 		fmt.Println("Please run with sudo: sudo appinstaller [options]")
+		fmt.Println("or install for your user only: appinstaller --user [options]")
 		os.Exit(1)
 	}
 
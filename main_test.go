@@ -0,0 +1,406 @@
+package main
+
+import (
+	"appinstaller/pkg/registry"
+	"appinstaller/pkg/types"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// TestMain runs the installer itself when a test starts the test binary with
+// runMainEnv set, so commands can be run end to end in a child process.
+func TestMain(m *testing.M) {
+	if os.Getenv(runMainEnv) == "1" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+const runMainEnv = "APPINSTALLER_TEST_RUN_MAIN"
+
+// testdata/my-app.AppImage is a type 2 AppImage with a gzip squashfs
+// payload holding AppRun, my-app.desktop (MimeType text/x-my-app, version
+// 1.0) and a 48x48 PNG icon. my-app-2.0.AppImage is version 2.0, named
+// "My App Two", with a 64x64 icon and update information.
+
+type installer struct {
+	t    *testing.T
+	root string
+	home string
+	env  []string
+}
+
+func newInstaller(t *testing.T) *installer {
+	home := t.TempDir()
+	return &installer{
+		t:    t,
+		root: t.TempDir(),
+		home: home,
+		env: []string{
+			runMainEnv + "=1",
+			"HOME=" + home,
+			"XDG_CONFIG_HOME=" + filepath.Join(home, ".config"),
+			"APPINSTALLER_EXTRACT_DIR=" + filepath.Join(home, "extract"),
+			// No fzf or cache tools from the host.
+			"PATH=" + filepath.Join(home, "bin"),
+		},
+	}
+}
+
+// run runs the installer with --root and the given arguments.
+func (in *installer) run(stdin string, args ...string) string {
+	in.t.Helper()
+	out, err := in.try(stdin, args...)
+	if err != nil {
+		in.t.Fatalf("%s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return out
+}
+
+// try is run for commands that may fail.
+func (in *installer) try(stdin string, args ...string) (string, error) {
+	cmd := exec.Command(os.Args[0], append([]string{"--root", in.root}, args...)...)
+	cmd.Env = in.env
+	cmd.Stdin = strings.NewReader(stdin)
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
+
+// files lists the files and symlinks below the root.
+func (in *installer) files() []string {
+	in.t.Helper()
+	var files []string
+	err := filepath.WalkDir(in.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			rel, _ := filepath.Rel(in.root, path)
+			files = append(files, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		in.t.Fatal(err)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// entry returns the registry entry of an installed application.
+func (in *installer) entry(id string) *registry.Entry {
+	in.t.Helper()
+	reg, err := registry.Open(filepath.Join(in.root, "var/lib/appinstaller/registry.json"))
+	if err != nil {
+		in.t.Fatal(err)
+	}
+	entry, ok := reg.Get(id)
+	if !ok {
+		in.t.Fatalf("%s is not in the registry", id)
+	}
+	return entry
+}
+
+func (in *installer) read(name string) string {
+	in.t.Helper()
+	data, err := os.ReadFile(filepath.Join(in.root, name))
+	if err != nil {
+		in.t.Fatal(err)
+	}
+	return string(data)
+}
+
+// fixture copies an AppImage from testdata, since install chmods its input.
+func fixture(t *testing.T, name string) (string, []byte) {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path, data
+}
+
+func TestInstallListDelete(t *testing.T) {
+	in := newInstaller(t)
+	appPath, fixture := fixture(t, "my-app.AppImage")
+
+	in.run("", "-i", appPath)
+
+	installed := []string{
+		"usr/share/appImages/my-app/1.0/my-app.AppImage",
+		"usr/share/appImages/my-app/current",
+		"usr/share/applications/mimeinfo.cache",
+		"usr/share/applications/my-app.desktop",
+		"usr/share/icons/hicolor/16x16/apps/appinstaller-my-app.png",
+		"usr/share/icons/hicolor/22x22/apps/appinstaller-my-app.png",
+		"usr/share/icons/hicolor/24x24/apps/appinstaller-my-app.png",
+		"usr/share/icons/hicolor/32x32/apps/appinstaller-my-app.png",
+		"usr/share/icons/hicolor/48x48/apps/appinstaller-my-app.png",
+		"var/lib/appinstaller/registry.json",
+	}
+	if got := in.files(); !reflect.DeepEqual(got, installed) {
+		t.Fatalf("files after install:\n  %s\nwant:\n  %s", strings.Join(got, "\n  "), strings.Join(installed, "\n  "))
+	}
+
+	if got := in.read("usr/share/appImages/my-app/1.0/my-app.AppImage"); got != string(fixture) {
+		t.Error("installed AppImage differs from the fixture")
+	}
+	if target, err := os.Readlink(filepath.Join(in.root, "usr/share/appImages/my-app/current")); err != nil || target != "1.0" {
+		t.Errorf("current -> %q, %v; want 1.0", target, err)
+	}
+	// Paths written into files are the ones on the target system.
+	deskFile := in.read("usr/share/applications/my-app.desktop")
+	for _, want := range []string{
+		"Exec=/usr/share/appImages/my-app/current/my-app.AppImage %F\n",
+		"Icon=appinstaller-my-app\n",
+	} {
+		if !strings.Contains(deskFile, want) {
+			t.Errorf("desktop entry lacks %q:\n%s", want, deskFile)
+		}
+	}
+	if strings.Contains(deskFile, in.root) || strings.Contains(in.read("var/lib/appinstaller/registry.json"), in.root) {
+		t.Error("installed files refer to the --root directory")
+	}
+	if cache := in.read("usr/share/applications/mimeinfo.cache"); !strings.Contains(cache, "text/x-my-app=my-app.desktop;\n") {
+		t.Errorf("mimeinfo.cache lacks the application:\n%s", cache)
+	}
+
+	list := in.run("q\n", "-l")
+	if !strings.Contains(list, "| system | [ ]  | my-app ") || !strings.Contains(list, "| My App ") {
+		t.Errorf("list does not show my-app:\n%s", list)
+	}
+
+	out := in.run("", "-d", "my-app")
+	removed := []string{
+		"/usr/share/appImages/my-app/current/my-app.AppImage",
+		"/usr/share/applications/my-app.desktop",
+		"/usr/share/icons/hicolor/48x48/apps/appinstaller-my-app.png",
+		"/usr/share/icons/hicolor/16x16/apps/appinstaller-my-app.png",
+		"/usr/share/icons/hicolor/22x22/apps/appinstaller-my-app.png",
+		"/usr/share/icons/hicolor/24x24/apps/appinstaller-my-app.png",
+		"/usr/share/icons/hicolor/32x32/apps/appinstaller-my-app.png",
+		"/usr/share/appImages/my-app",
+	}
+	for _, path := range removed {
+		if !strings.Contains(out, "removed   "+path+"\n") {
+			t.Errorf("delete did not report removing %s:\n%s", path, out)
+		}
+	}
+
+	remaining := []string{
+		"usr/share/applications/mimeinfo.cache",
+		"var/lib/appinstaller/registry.json",
+	}
+	if got := in.files(); !reflect.DeepEqual(got, remaining) {
+		t.Fatalf("files after delete:\n  %s\nwant:\n  %s", strings.Join(got, "\n  "), strings.Join(remaining, "\n  "))
+	}
+	if cache := in.read("usr/share/applications/mimeinfo.cache"); cache != "[MIME Cache]\n" {
+		t.Errorf("mimeinfo.cache still lists handlers:\n%s", cache)
+	}
+
+	list = in.run("", "-l")
+	if !strings.Contains(list, "No installed applications found") {
+		t.Errorf("list after delete:\n%s", list)
+	}
+
+	// The work directory was removed and nothing else left behind.
+	entries, err := os.ReadDir(filepath.Join(in.home, "extract"))
+	if err != nil || len(entries) != 0 {
+		t.Errorf("extract directory holds %v, %v", entries, err)
+	}
+}
+
+func TestFailedInstallRemovesWorkDir(t *testing.T) {
+	in := newInstaller(t)
+	appPath := filepath.Join(t.TempDir(), "broken.AppImage")
+	if err := os.WriteFile(appPath, []byte("#!/bin/sh\nnot an AppImage\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if out, err := in.try("", "-i", appPath); err == nil {
+		t.Fatalf("installing a broken AppImage succeeded:\n%s", out)
+	}
+	entries, err := os.ReadDir(filepath.Join(in.home, "extract"))
+	if err != nil || len(entries) != 0 {
+		t.Errorf("extract directory holds %v, %v", entries, err)
+	}
+	if files := in.files(); len(files) != 0 {
+		t.Errorf("failed install left %v", files)
+	}
+}
+
+func TestPurge(t *testing.T) {
+	in := newInstaller(t)
+	appPath, _ := fixture(t, "my-app.AppImage")
+	configDir := filepath.Join(in.root, in.home, ".config", "my-app")
+
+	// Candidates that do not exist are reported, not silently skipped.
+	in.run("", "-i", appPath)
+	out := in.run("", "-d", "my-app", "--purge")
+	if !strings.Contains(out, "--purge: no configuration in /") || !strings.Contains(out, "found no configuration directory") {
+		t.Errorf("purge without configuration:\n%s", out)
+	}
+
+	in.run("", "-i", appPath)
+	if err := os.MkdirAll(configDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	out = in.run("y\n", "-d", "my-app", "--purge")
+	if !strings.Contains(out, "removed   "+configDir+"\n") {
+		t.Errorf("purge did not report removing %s:\n%s", configDir, out)
+	}
+	if _, err := os.Stat(configDir); !os.IsNotExist(err) {
+		t.Errorf("%s was not removed: %v", configDir, err)
+	}
+}
+
+func TestUpdateRollback(t *testing.T) {
+	in := newInstaller(t)
+	v1, _ := fixture(t, "my-app.AppImage")
+	v2, _ := fixture(t, "my-app-2.0.AppImage")
+
+	in.run("", "-i", v1)
+	v1Files := in.files()
+	in.run("", "update", "my-app", v2)
+
+	deskFile := in.read("usr/share/applications/my-app.desktop")
+	if !strings.Contains(deskFile, "Name=My App Two\n") {
+		t.Fatalf("desktop entry after update:\n%s", deskFile)
+	}
+	if _, err := os.Stat(filepath.Join(in.root, "usr/share/icons/hicolor/64x64/apps/appinstaller-my-app.png")); err != nil {
+		t.Fatal("update did not install the 64x64 icon")
+	}
+	if entry := in.entry("my-app"); entry.UpdateInfo != "zsync|https://example.com/my-app-latest.AppImage.zsync" {
+		t.Fatalf("update information after update = %q", entry.UpdateInfo)
+	}
+
+	out := in.run("", "rollback", "my-app")
+	if !strings.Contains(out, "Rolled back my-app to 1.0") {
+		t.Errorf("rollback output:\n%s", out)
+	}
+
+	// The files of 1.0 are back and those only 2.0 installed are gone; the
+	// 2.0 AppImage stays as a kept version.
+	want := append([]string{"usr/share/appImages/my-app/2.0/my-app.AppImage"}, v1Files...)
+	sort.Strings(want)
+	if got := in.files(); !reflect.DeepEqual(got, want) {
+		t.Errorf("files after rollback:\n  %s\nwant:\n  %s", strings.Join(got, "\n  "), strings.Join(want, "\n  "))
+	}
+	if target, err := os.Readlink(filepath.Join(in.root, "usr/share/appImages/my-app/current")); err != nil || target != "1.0" {
+		t.Errorf("current -> %q, %v; want 1.0", target, err)
+	}
+	deskFile = in.read("usr/share/applications/my-app.desktop")
+	if !strings.Contains(deskFile, "Name=My App\n") || !strings.Contains(deskFile, "Icon=appinstaller-my-app\n") {
+		t.Errorf("desktop entry after rollback:\n%s", deskFile)
+	}
+
+	if entry := in.entry("my-app"); entry.Name != "My App" || entry.Version != "1.0" || entry.UpdateInfo != "" {
+		t.Errorf("registry entry after rollback = %+v", entry)
+	}
+}
+
+func TestRequiresSuperuser(t *testing.T) {
+	defer func(args []string, s string) { os.Args, scope = args, s }(os.Args, scope)
+
+	tests := []struct {
+		args  []string
+		scope string
+		want  bool
+	}{
+		{[]string{"-i", "app.AppImage"}, "", true},
+		{[]string{"upgrade"}, "", true},
+		{[]string{"rollback", "my-app"}, "", true},
+		{[]string{"-i", "app.AppImage"}, types.ScopeUser, false},
+		{[]string{"versions", "my-app"}, "", false},
+		{[]string{"check-updates"}, "", false},
+		{[]string{"-l"}, "", false},
+		{[]string{"validate", "app.desktop"}, "", false},
+	}
+	for _, tt := range tests {
+		os.Args = append([]string{"appinstaller"}, tt.args...)
+		scope = tt.scope
+		if got := requiresSuperuser(); got != tt.want {
+			t.Errorf("requiresSuperuser() for %v in scope %q = %v, want %v", tt.args, tt.scope, got, tt.want)
+		}
+	}
+}
+
+func TestCheckUpdatesCoversBothScopes(t *testing.T) {
+	in := newInstaller(t)
+	appPath, _ := fixture(t, "my-app.AppImage")
+	in.run("", "-i", appPath)
+	in.run("", "--user", "-i", appPath)
+
+	out := in.run("", "check-updates")
+	for _, s := range []string{types.ScopeSystem, types.ScopeUser} {
+		if !regexp.MustCompile(`(?m)^my-app +` + s + ` +no update information$`).MatchString(out) {
+			t.Errorf("check-updates does not report the %s install:\n%s", s, out)
+		}
+	}
+}
+
+func TestCheckExtractDirOwner(t *testing.T) {
+	base := t.TempDir()
+	dir := func(name string, mode os.FileMode) string {
+		path := filepath.Join(base, name)
+		if err := os.Mkdir(path, 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(path, mode); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	link := filepath.Join(base, "link")
+	if err := os.Symlink(dir("target", 0755), link); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(base, "file")
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Only root can give a directory away.
+	other := dir("other", 0755)
+	if os.Geteuid() == 0 {
+		if err := os.Chown(other, 65534, 65534); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		dir  string
+		ok   bool
+	}{
+		{"private", dir("private", 0700), true},
+		{"world readable", dir("readable", 0755), true},
+		{"world writable", dir("tmp", 01777), false},
+		{"group writable", dir("group", 0775), false},
+		{"symlink", link, false},
+		{"file", file, false},
+		{"missing", filepath.Join(base, "missing"), false},
+		{"owned by another user", other, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.dir == other && os.Geteuid() != 0 {
+				t.Skip("changing the owner needs root")
+			}
+			if err := checkExtractDirOwner(tt.dir); (err == nil) != tt.ok {
+				t.Errorf("checkExtractDirOwner(%s) = %v, want ok %v", tt.name, err, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package appid
+
+import (
+    "encoding/xml"
+    "os"
+    "path/filepath"
+    "regexp"
+    "strings"
+)
+
+var (
+    validRegex   = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+    invalidRegex = regexp.MustCompile(`[^\pL\pN._-]+`)
+
+    // Basenames that AppImage tools generate and that identify nothing.
+    genericNames = []string{"AppRun", "appimage", "default", "app"}
+
+    metainfoDirs = []string{"usr/share/metainfo", "usr/share/appdata"}
+)
+
+type component struct {
+    Type       string `xml:"type,attr"`
+    ID         string `xml:"id"`
+    Launchable []struct {
+        Type  string `xml:"type,attr"`
+        Value string `xml:",chardata"`
+    } `xml:"launchable"`
+}
+
+// Derive returns the stable ID of an extracted AppImage: the AppStream
+// <id>, else the basename of its desktop entry, else its sanitized Name.
+func Derive(extractDir, desktopPath, name string) string {
+    desktopID := strings.TrimSuffix(filepath.Base(desktopPath), ".desktop")
+
+    if id := FromAppStream(extractDir, desktopID); id != "" {
+        return id
+    }
+    if Valid(desktopID) {
+        return desktopID
+    }
+    return Sanitize(name)
+}
+
+// FromAppStream looks for the AppStream component of a desktop application
+// under extractDir, preferring one that launches desktopID.
+func FromAppStream(extractDir, desktopID string) string {
+    var fallback string
+
+    for _, dir := range metainfoDirs {
+        paths, _ := filepath.Glob(filepath.Join(extractDir, dir, "*.xml"))
+        for _, path := range paths {
+            data, err := os.ReadFile(path)
+            if err != nil {
+                continue
+            }
+
+            var c component
+            if err := xml.Unmarshal(data, &c); err != nil {
+                continue
+            }
+            if c.Type != "desktop-application" && c.Type != "desktop" {
+                continue
+            }
+
+            // Legacy components use the desktop file name as their ID.
+            id := strings.TrimSuffix(strings.TrimSpace(c.ID), ".desktop")
+            if !Valid(id) {
+                continue
+            }
+
+            for _, l := range c.Launchable {
+                if l.Type == "desktop-id" && strings.TrimSuffix(strings.TrimSpace(l.Value), ".desktop") == desktopID {
+                    return id
+                }
+            }
+            if fallback == "" {
+                fallback = id
+            }
+        }
+    }
+
+    return fallback
+}
+
+func Valid(id string) bool {
+    if !validRegex.MatchString(id) {
+        return false
+    }
+    for _, generic := range genericNames {
+        if strings.EqualFold(id, generic) {
+            return false
+        }
+    }
+    return true
+}
+
+// Sanitize turns a display name into an ID, e.g. "My App 2" into
+// "my-app-2". Letters outside ASCII are kept.
+func Sanitize(name string) string {
+    id := invalidRegex.ReplaceAllString(strings.ToLower(name), "-")
+    id = strings.Trim(id, "-.")
+    if id == "" {
+        return "appimage"
+    }
+    return id
+}
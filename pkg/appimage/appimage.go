@@ -0,0 +1,194 @@
+package appimage
+
+import (
+    "bytes"
+    "debug/elf"
+    "encoding/binary"
+    "fmt"
+    "io"
+    "os"
+)
+
+const (
+    // Type 1 images keep their update information in the application use
+    // area of the ISO 9660 primary volume descriptor.
+    type1UpdateInfoOffset = 33651
+    type1UpdateInfoSize   = 512
+)
+
+const (
+    TypeUnknown = 0
+    Type1       = 1
+    Type2       = 2
+)
+
+var squashfsMagic = []byte("hsqs")
+
+type AppImage struct {
+    Path   string
+    Type   int
+    Arch   string
+    Offset int64
+    Size   int64
+
+    // UpdateInfo is the embedded update information, e.g.
+    // "gh-releases-zsync|owner|repo|latest|App-*x86_64.AppImage.zsync".
+    UpdateInfo string
+
+    reader io.ReaderAt
+    file   *os.File
+}
+
+func Open(path string) (*AppImage, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return nil, fmt.Errorf("error opening AppImage: %w", err)
+    }
+
+    app, err := New(file)
+    if err != nil {
+        file.Close()
+        return nil, err
+    }
+
+    info, err := file.Stat()
+    if err != nil {
+        file.Close()
+        return nil, fmt.Errorf("error getting file info %s: %w", path, err)
+    }
+
+    app.Path = path
+    app.Size = info.Size() - app.Offset
+    app.file = file
+    return app, nil
+}
+
+func New(r io.ReaderAt) (*AppImage, error) {
+    ident := make([]byte, elf.EI_NIDENT)
+    if _, err := r.ReadAt(ident, 0); err != nil {
+        return nil, fmt.Errorf("error reading file header: %w", err)
+    }
+
+    if !bytes.Equal(ident[:4], []byte(elf.ELFMAG)) {
+        return nil, fmt.Errorf("not a valid AppImage file (missing ELF header)")
+    }
+
+    elfFile, err := elf.NewFile(r)
+    if err != nil {
+        return nil, fmt.Errorf("error parsing ELF header: %w", err)
+    }
+
+    offset, err := payloadOffset(r, elfFile)
+    if err != nil {
+        return nil, err
+    }
+
+    app := &AppImage{
+        Type:   detectType(ident),
+        Arch:   archName(elfFile.Machine),
+        Offset: offset,
+        reader: r,
+    }
+
+    if app.Type == TypeUnknown {
+        magic := make([]byte, len(squashfsMagic))
+        if _, err := r.ReadAt(magic, offset); err == nil && bytes.Equal(magic, squashfsMagic) {
+            app.Type = Type2
+        }
+    }
+
+    switch app.Type {
+    case Type1:
+        app.Offset = 0
+        data := make([]byte, type1UpdateInfoSize)
+        if _, err := r.ReadAt(data, type1UpdateInfoOffset); err == nil {
+            app.UpdateInfo = trimUpdateInfo(data)
+        }
+    case Type2:
+        if section := elfFile.Section(".upd_info"); section != nil {
+            if data, err := section.Data(); err == nil {
+                app.UpdateInfo = trimUpdateInfo(data)
+            }
+        }
+    default:
+        return nil, fmt.Errorf("unknown AppImage type")
+    }
+
+    return app, nil
+}
+
+func (a *AppImage) Payload() *io.SectionReader {
+    size := a.Size
+    if size <= 0 {
+        size = 1<<63 - 1 - a.Offset
+    }
+    return io.NewSectionReader(a.reader, a.Offset, size)
+}
+
+func (a *AppImage) Close() error {
+    if a.file == nil {
+        return nil
+    }
+    return a.file.Close()
+}
+
+// trimUpdateInfo strips the NUL padding of the reserved update information
+// area.
+func trimUpdateInfo(data []byte) string {
+    if i := bytes.IndexByte(data, 0); i >= 0 {
+        data = data[:i]
+    }
+    return string(bytes.TrimSpace(data))
+}
+
+func detectType(ident []byte) int {
+    if ident[8] != 'A' || ident[9] != 'I' {
+        return TypeUnknown
+    }
+
+    switch ident[10] {
+    case 0x01:
+        return Type1
+    case 0x02:
+        return Type2
+    }
+    return TypeUnknown
+}
+
+func payloadOffset(r io.ReaderAt, elfFile *elf.File) (int64, error) {
+    var shoff uint64
+    var shentsize, shnum uint16
+
+    switch elfFile.Class {
+    case elf.ELFCLASS64:
+        var header elf.Header64
+        if err := binary.Read(io.NewSectionReader(r, 0, int64(binary.Size(header))), elfFile.ByteOrder, &header); err != nil {
+            return 0, fmt.Errorf("error reading ELF header: %w", err)
+        }
+        shoff, shentsize, shnum = header.Shoff, header.Shentsize, header.Shnum
+    case elf.ELFCLASS32:
+        var header elf.Header32
+        if err := binary.Read(io.NewSectionReader(r, 0, int64(binary.Size(header))), elfFile.ByteOrder, &header); err != nil {
+            return 0, fmt.Errorf("error reading ELF header: %w", err)
+        }
+        shoff, shentsize, shnum = uint64(header.Shoff), header.Shentsize, header.Shnum
+    default:
+        return 0, fmt.Errorf("unsupported ELF class %s", elfFile.Class)
+    }
+
+    return int64(shoff + uint64(shentsize)*uint64(shnum)), nil
+}
+
+func archName(machine elf.Machine) string {
+    switch machine {
+    case elf.EM_X86_64:
+        return "x86_64"
+    case elf.EM_386:
+        return "i686"
+    case elf.EM_AARCH64:
+        return "aarch64"
+    case elf.EM_ARM:
+        return "armhf"
+    }
+    return machine.String()
+}
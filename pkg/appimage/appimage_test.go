@@ -0,0 +1,183 @@
+package appimage
+
+import (
+    "bytes"
+    "debug/elf"
+    "encoding/binary"
+    "testing"
+)
+
+// elfImage describes the runtime of a test AppImage.
+type elfImage struct {
+    class      elf.Class
+    order      binary.ByteOrder
+    machine    elf.Machine
+    magic      string
+    updateInfo string
+}
+
+// build returns the runtime as the AppImage tooling lays it out: the ELF
+// header, the section contents, then the section header table, which the
+// payload follows.
+func (e elfImage) build() []byte {
+    shstrtab := []byte("\x00.upd_info\x00.shstrtab\x00")
+    updInfo := make([]byte, 1024)
+    copy(updInfo, e.updateInfo)
+
+    headerSize := 64
+    if e.class == elf.ELFCLASS32 {
+        headerSize = 52
+    }
+    shstrtabOff := uint64(headerSize)
+    updInfoOff := shstrtabOff + uint64(len(shstrtab))
+    shoff := updInfoOff + uint64(len(updInfo))
+
+    var ident [elf.EI_NIDENT]byte
+    copy(ident[:], elf.ELFMAG)
+    ident[elf.EI_CLASS] = byte(e.class)
+    ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+    if e.order == binary.BigEndian {
+        ident[elf.EI_DATA] = byte(elf.ELFDATA2MSB)
+    }
+    ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+    copy(ident[8:], e.magic)
+
+    type section struct {
+        name, typ uint32
+        off, size uint64
+    }
+    sections := []section{
+        {},
+        {1, uint32(elf.SHT_PROGBITS), updInfoOff, uint64(len(updInfo))},
+        {11, uint32(elf.SHT_STRTAB), shstrtabOff, uint64(len(shstrtab))},
+    }
+
+    var b bytes.Buffer
+    if e.class == elf.ELFCLASS32 {
+        binary.Write(&b, e.order, elf.Header32{
+            Ident: ident, Type: uint16(elf.ET_EXEC), Machine: uint16(e.machine), Version: uint32(elf.EV_CURRENT),
+            Shoff: uint32(shoff), Ehsize: 52, Shentsize: 40, Shnum: uint16(len(sections)), Shstrndx: 2,
+        })
+    } else {
+        binary.Write(&b, e.order, elf.Header64{
+            Ident: ident, Type: uint16(elf.ET_EXEC), Machine: uint16(e.machine), Version: uint32(elf.EV_CURRENT),
+            Shoff: shoff, Ehsize: 64, Shentsize: 64, Shnum: uint16(len(sections)), Shstrndx: 2,
+        })
+    }
+    b.Write(shstrtab)
+    b.Write(updInfo)
+    for _, s := range sections {
+        if e.class == elf.ELFCLASS32 {
+            binary.Write(&b, e.order, elf.Section32{Name: s.name, Type: s.typ, Off: uint32(s.off), Size: uint32(s.size)})
+        } else {
+            binary.Write(&b, e.order, elf.Section64{Name: s.name, Type: s.typ, Off: s.off, Size: s.size})
+        }
+    }
+    return b.Bytes()
+}
+
+func TestDetectType(t *testing.T) {
+    tests := []struct {
+        magic string
+        want  int
+    }{
+        {"AI\x01", Type1},
+        {"AI\x02", Type2},
+        {"AI\x03", TypeUnknown},
+        {"\x00\x00\x00", TypeUnknown},
+        {"ai\x02", TypeUnknown},
+    }
+    for _, tt := range tests {
+        ident := make([]byte, elf.EI_NIDENT)
+        copy(ident, elf.ELFMAG)
+        copy(ident[8:], tt.magic)
+        if got := detectType(ident); got != tt.want {
+            t.Errorf("detectType(%q) = %d, want %d", tt.magic, got, tt.want)
+        }
+    }
+}
+
+func TestNew(t *testing.T) {
+    const updateInfo = "gh-releases-zsync|owner|app|latest|App-*x86_64.AppImage.zsync"
+    squashfs := append([]byte("hsqs"), make([]byte, 92)...)
+
+    tests := []struct {
+        name    string
+        runtime elfImage
+        payload []byte
+        typ     int
+        arch    string
+    }{
+        {"type 2, 64-bit", elfImage{elf.ELFCLASS64, binary.LittleEndian, elf.EM_X86_64, "AI\x02", updateInfo}, squashfs, Type2, "x86_64"},
+        {"type 2, 32-bit", elfImage{elf.ELFCLASS32, binary.LittleEndian, elf.EM_386, "AI\x02", updateInfo}, squashfs, Type2, "i686"},
+        {"type 2, 64-bit big-endian", elfImage{elf.ELFCLASS64, binary.BigEndian, elf.EM_PPC64, "AI\x02", updateInfo}, squashfs, Type2, "EM_PPC64"},
+        {"type 2, 32-bit arm", elfImage{elf.ELFCLASS32, binary.LittleEndian, elf.EM_ARM, "AI\x02", ""}, squashfs, Type2, "armhf"},
+        {"squashfs without magic", elfImage{elf.ELFCLASS64, binary.LittleEndian, elf.EM_AARCH64, "", updateInfo}, squashfs, Type2, "aarch64"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            runtime := tt.runtime.build()
+            app, err := New(bytes.NewReader(append(runtime, tt.payload...)))
+            if err != nil {
+                t.Fatal(err)
+            }
+            if app.Type != tt.typ || app.Arch != tt.arch {
+                t.Errorf("type %d, arch %s; want %d, %s", app.Type, app.Arch, tt.typ, tt.arch)
+            }
+            if app.Offset != int64(len(runtime)) {
+                t.Errorf("offset %d, want %d", app.Offset, len(runtime))
+            }
+            if app.UpdateInfo != tt.runtime.updateInfo {
+                t.Errorf("update information %q, want %q", app.UpdateInfo, tt.runtime.updateInfo)
+            }
+            magic := make([]byte, 4)
+            if _, err := app.Payload().ReadAt(magic, 0); err != nil || string(magic) != "hsqs" {
+                t.Errorf("payload starts with %q: %v", magic, err)
+            }
+        })
+    }
+}
+
+func TestNewType1(t *testing.T) {
+    runtime := elfImage{elf.ELFCLASS64, binary.LittleEndian, elf.EM_X86_64, "AI\x01", ""}.build()
+    image := make([]byte, type1UpdateInfoOffset+type1UpdateInfoSize)
+    copy(image, runtime)
+    copy(image[type1UpdateInfoOffset:], "zsync|https://example.org/app.zsync   ")
+
+    app, err := New(bytes.NewReader(image))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if app.Type != Type1 || app.Offset != 0 {
+        t.Errorf("type %d, offset %d; want type 1 at offset 0", app.Type, app.Offset)
+    }
+    if app.UpdateInfo != "zsync|https://example.org/app.zsync" {
+        t.Errorf("update information %q", app.UpdateInfo)
+    }
+}
+
+func TestNewErrors(t *testing.T) {
+    valid := elfImage{elf.ELFCLASS64, binary.LittleEndian, elf.EM_X86_64, "AI\x02", ""}.build()
+    noSquashfs := elfImage{elf.ELFCLASS64, binary.LittleEndian, elf.EM_X86_64, "", ""}.build()
+    badClass := bytes.Clone(valid)
+    badClass[elf.EI_CLASS] = 7
+
+    tests := map[string][]byte{
+        "empty":                   nil,
+        "shorter than ELF ident":  []byte("\x7fELF\x02\x01"),
+        "shell script":            []byte("#!/bin/sh\necho this is not an AppImage\n"),
+        "PNG image":               append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, 64)...),
+        "truncated ELF header":    valid[:40],
+        "truncated section table": valid[:len(valid)-10],
+        "unknown ELF class":       badClass,
+        "unknown type":            append(noSquashfs, []byte("not squashfs")...),
+        "no payload":              noSquashfs,
+    }
+    for name, data := range tests {
+        t.Run(name, func(t *testing.T) {
+            if app, err := New(bytes.NewReader(data)); err == nil {
+                t.Errorf("New() = %+v, want an error", app)
+            }
+        })
+    }
+}
@@ -0,0 +1,140 @@
+package appimage
+
+import (
+    "errors"
+    "fmt"
+    "io"
+    "io/fs"
+    "os"
+    "path/filepath"
+
+    "appinstaller/pkg/iso9660"
+    "appinstaller/pkg/squashfs"
+)
+
+type FS interface {
+    fs.ReadDirFS
+    fs.StatFS
+    Lstat(name string) (fs.FileInfo, error)
+    ReadLink(name string) (string, error)
+}
+
+func (a *AppImage) FS() (FS, error) {
+    switch a.Type {
+    case Type1:
+        reader, err := iso9660.NewReader(a.Payload())
+        if err != nil {
+            return nil, fmt.Errorf("error reading ISO 9660 payload: %w", err)
+        }
+        return reader, nil
+    case Type2:
+        reader, err := squashfs.NewReader(a.Payload())
+        if err != nil {
+            return nil, fmt.Errorf("error reading squashfs payload: %w", err)
+        }
+        return reader, nil
+    }
+    return nil, fmt.Errorf("type %d AppImage payload is not supported", a.Type)
+}
+
+var (
+    integrationPatterns = []string{
+        "*.desktop",
+        ".DirIcon",
+        "*.png",
+        "*.svg",
+        "*.xpm",
+        "usr/share/metainfo/*.xml",
+        "usr/share/appdata/*.xml",
+    }
+    integrationDirs = []string{
+        "usr/share/icons",
+        "usr/share/pixmaps",
+    }
+)
+
+// ExtractIntegration materializes only the files needed to integrate the
+// AppImage into the desktop: top-level desktop entries and icons, .DirIcon,
+// icon directories and AppStream metadata. Symlinks are resolved inside the
+// image and written out as regular files.
+func ExtractIntegration(fsys FS, dest string) error {
+    if err := os.MkdirAll(dest, 0755); err != nil {
+        return fmt.Errorf("error creating extraction directory: %w", err)
+    }
+
+    for _, pattern := range integrationPatterns {
+        matches, err := fs.Glob(fsys, pattern)
+        if err != nil {
+            return err
+        }
+        for _, name := range matches {
+            if err := materialize(fsys, name, dest); err != nil {
+                return err
+            }
+        }
+    }
+
+    for _, dir := range integrationDirs {
+        err := fs.WalkDir(fsys, dir, func(name string, entry fs.DirEntry, err error) error {
+            if err != nil {
+                if errors.Is(err, fs.ErrNotExist) {
+                    return nil
+                }
+                return err
+            }
+            if entry.IsDir() {
+                return nil
+            }
+            return materialize(fsys, name, dest)
+        })
+        if err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+func materialize(fsys FS, name, dest string) error {
+    info, err := fsys.Stat(name)
+    if errors.Is(err, fs.ErrNotExist) {
+        return nil
+    }
+    if err != nil {
+        return err
+    }
+
+    if !info.Mode().IsRegular() {
+        return nil
+    }
+
+    target := filepath.Join(dest, filepath.FromSlash(name))
+    if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+        return fmt.Errorf("error creating directory for %s: %w", name, err)
+    }
+
+    if err := extractFile(fsys, name, target, info.Mode().Perm()); err != nil {
+        return fmt.Errorf("error extracting %s: %w", name, err)
+    }
+    return nil
+}
+
+func extractFile(fsys FS, name, target string, perm fs.FileMode) error {
+    src, err := fsys.Open(name)
+    if err != nil {
+        return err
+    }
+    defer src.Close()
+
+    os.Remove(target)
+    dst, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+    if err != nil {
+        return err
+    }
+
+    if _, err := io.Copy(dst, src); err != nil {
+        dst.Close()
+        return err
+    }
+    return dst.Close()
+}
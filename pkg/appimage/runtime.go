@@ -0,0 +1,170 @@
+package appimage
+
+import (
+    "errors"
+    "fmt"
+    "io"
+    "io/fs"
+    "os"
+    "os/exec"
+    "os/user"
+    "path/filepath"
+    "strconv"
+    "syscall"
+
+    "appinstaller/pkg/fileutil"
+)
+
+// ExtractWithRuntime runs the AppImage's own --appimage-extract. The image is
+// untrusted code, so when running as root it is executed as the invoking sudo
+// user (or nobody) with a clean environment inside a private directory. That
+// directory lives in the system temporary directory rather than next to dest,
+// whose parents the unprivileged user may not be able to enter; the extracted
+// tree is moved into dest afterwards.
+func (a *AppImage) ExtractWithRuntime(dest string) error {
+    credential, err := unprivilegedCredential()
+    if err != nil {
+        return err
+    }
+
+    dest, err = filepath.Abs(dest)
+    if err != nil {
+        return err
+    }
+
+    workDir, err := os.MkdirTemp("", "appinstaller-exec-")
+    if err != nil {
+        return fmt.Errorf("error creating private directory: %w", err)
+    }
+    defer os.RemoveAll(workDir)
+
+    appPath := filepath.Join(workDir, filepath.Base(a.Path))
+    if err := fileutil.Copy(a.Path, appPath); err != nil {
+        return fmt.Errorf("error copying AppImage: %w", err)
+    }
+    if err := os.Chmod(appPath, 0755); err != nil {
+        return err
+    }
+
+    if credential != nil {
+        for _, path := range []string{workDir, appPath} {
+            if err := os.Chown(path, int(credential.Uid), int(credential.Gid)); err != nil {
+                return fmt.Errorf("error preparing private directory: %w", err)
+            }
+        }
+    }
+
+    cmd := exec.Command(appPath, "--appimage-extract")
+    cmd.Dir = workDir
+    cmd.Stdout = os.Stdout
+    cmd.Stderr = os.Stderr
+    cmd.Env = []string{
+        "PATH=/usr/local/bin:/usr/bin:/bin",
+        "HOME=" + workDir,
+        "TMPDIR=" + workDir,
+        "LANG=C.UTF-8",
+    }
+    if credential != nil {
+        cmd.SysProcAttr = &syscall.SysProcAttr{Credential: credential}
+    }
+
+    if err := cmd.Run(); err != nil {
+        return fmt.Errorf("native extraction failed: %w", err)
+    }
+
+    os.RemoveAll(dest)
+    if err := moveTree(filepath.Join(workDir, "squashfs-root"), dest); err != nil {
+        return fmt.Errorf("error moving extracted files: %w", err)
+    }
+    return nil
+}
+
+// moveTree renames src to dst, copying the tree when they are on different
+// file systems. The copy does not follow symlinks, which are recreated as
+// they are.
+func moveTree(src, dst string) error {
+    err := os.Rename(src, dst)
+    if !errors.Is(err, syscall.EXDEV) {
+        return err
+    }
+
+    return filepath.WalkDir(src, func(path string, entry fs.DirEntry, err error) error {
+        if err != nil {
+            return err
+        }
+        rel, err := filepath.Rel(src, path)
+        if err != nil {
+            return err
+        }
+        target := filepath.Join(dst, rel)
+
+        switch {
+        case entry.IsDir():
+            return os.Mkdir(target, 0755)
+        case entry.Type()&fs.ModeSymlink != 0:
+            link, err := os.Readlink(path)
+            if err != nil {
+                return err
+            }
+            return os.Symlink(link, target)
+        case entry.Type().IsRegular():
+            return copyRegular(path, target)
+        }
+        return nil
+    })
+}
+
+func copyRegular(src, dst string) error {
+    source, err := os.OpenFile(src, os.O_RDONLY|syscall.O_NOFOLLOW, 0)
+    if err != nil {
+        return err
+    }
+    defer source.Close()
+
+    info, err := source.Stat()
+    if err != nil {
+        return err
+    }
+    if !info.Mode().IsRegular() {
+        return fmt.Errorf("%s is not a regular file", src)
+    }
+
+    destination, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, info.Mode().Perm())
+    if err != nil {
+        return err
+    }
+    if _, err := io.Copy(destination, source); err != nil {
+        destination.Close()
+        return err
+    }
+    return destination.Close()
+}
+
+// unprivilegedCredential returns the identity to execute AppImages as, or nil
+// when the process is not running as root and has nothing to drop.
+func unprivilegedCredential() (*syscall.Credential, error) {
+    if os.Geteuid() != 0 {
+        return nil, nil
+    }
+
+    uid, uidErr := strconv.ParseUint(os.Getenv("SUDO_UID"), 10, 32)
+    gid, gidErr := strconv.ParseUint(os.Getenv("SUDO_GID"), 10, 32)
+    if uidErr == nil && gidErr == nil && uid != 0 {
+        return &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid), Groups: []uint32{}}, nil
+    }
+
+    nobody, err := user.Lookup("nobody")
+    if err != nil {
+        return nil, fmt.Errorf("no unprivileged user to execute the AppImage as: %w", err)
+    }
+
+    uid, err = strconv.ParseUint(nobody.Uid, 10, 32)
+    if err != nil {
+        return nil, fmt.Errorf("invalid uid for nobody: %w", err)
+    }
+    gid, err = strconv.ParseUint(nobody.Gid, 10, 32)
+    if err != nil {
+        return nil, fmt.Errorf("invalid gid for nobody: %w", err)
+    }
+    return &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid), Groups: []uint32{}}, nil
+}
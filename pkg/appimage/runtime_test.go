@@ -0,0 +1,85 @@
+package appimage
+
+import (
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+// TestExtractWithRuntimeUnprivileged runs a stand-in runtime as an
+// unprivileged user while dest lies below a directory only root can enter,
+// as the install work directory does.
+func TestExtractWithRuntimeUnprivileged(t *testing.T) {
+    if os.Geteuid() != 0 {
+        t.Skip("dropping privileges needs root")
+    }
+    t.Setenv("SUDO_UID", "65534")
+    t.Setenv("SUDO_GID", "65534")
+
+    private := t.TempDir()
+    if err := os.Chmod(private, 0700); err != nil {
+        t.Fatal(err)
+    }
+    runtime := filepath.Join(private, "app.AppImage")
+    script := "#!/bin/sh\n" +
+        "[ \"$1\" = --appimage-extract ] || exit 2\n" +
+        "[ \"$(id -u)\" = 65534 ] || exit 3\n" +
+        "mkdir -p squashfs-root/usr/share && echo '[Desktop Entry]' > squashfs-root/app.desktop\n" +
+        "ln -s ../../app.desktop squashfs-root/usr/share/link.desktop\n"
+    if err := os.WriteFile(runtime, []byte(script), 0755); err != nil {
+        t.Fatal(err)
+    }
+
+    dest := filepath.Join(private, "work", "squashfs-root")
+    if err := os.Mkdir(filepath.Dir(dest), 0700); err != nil {
+        t.Fatal(err)
+    }
+    app := &AppImage{Path: runtime}
+    if err := app.ExtractWithRuntime(dest); err != nil {
+        t.Fatal(err)
+    }
+
+    data, err := os.ReadFile(filepath.Join(dest, "app.desktop"))
+    if err != nil || string(data) != "[Desktop Entry]\n" {
+        t.Errorf("app.desktop = %q, %v", data, err)
+    }
+    if link, err := os.Readlink(filepath.Join(dest, "usr/share/link.desktop")); err != nil || link != "../../app.desktop" {
+        t.Errorf("link.desktop -> %q, %v", link, err)
+    }
+}
+
+func TestMoveTree(t *testing.T) {
+    src := filepath.Join(t.TempDir(), "src")
+    if err := os.MkdirAll(filepath.Join(src, "dir"), 0755); err != nil {
+        t.Fatal(err)
+    }
+    if err := os.WriteFile(filepath.Join(src, "dir", "file"), []byte("data"), 0640); err != nil {
+        t.Fatal(err)
+    }
+    if err := os.Symlink("/etc/passwd", filepath.Join(src, "link")); err != nil {
+        t.Fatal(err)
+    }
+
+    // copyRegular is what moveTree falls back to across file systems.
+    dst := filepath.Join(t.TempDir(), "dst")
+    if err := os.Mkdir(dst, 0755); err != nil {
+        t.Fatal(err)
+    }
+    if err := copyRegular(filepath.Join(src, "dir", "file"), filepath.Join(dst, "file")); err != nil {
+        t.Fatal(err)
+    }
+    if info, err := os.Stat(filepath.Join(dst, "file")); err != nil || info.Mode().Perm() != 0640 {
+        t.Errorf("copied file: %v, %v", info, err)
+    }
+    if err := copyRegular(filepath.Join(src, "link"), filepath.Join(dst, "link")); err == nil {
+        t.Error("copyRegular followed a symlink")
+    }
+
+    moved := filepath.Join(filepath.Dir(src), "moved")
+    if err := moveTree(src, moved); err != nil {
+        t.Fatal(err)
+    }
+    if data, err := os.ReadFile(filepath.Join(moved, "dir", "file")); err != nil || string(data) != "data" {
+        t.Errorf("moved file = %q, %v", data, err)
+    }
+}
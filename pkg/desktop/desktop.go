@@ -1,7 +1,6 @@
 package desktop
 
 import (
-    "bufio"
     "fmt"
     "os"
     "regexp"
@@ -9,17 +8,46 @@ import (
     "path/filepath"
 )
 
+const rootCategory = "root"
+
+const (
+    lineBlank = iota
+    lineComment
+    lineGroup
+    lineEntry
+    lineInvalid
+)
+
+var categoryRegex = regexp.MustCompile(`^[[:space:]]*\[(.+)\][[:space:]]*$`)
+
+// line keeps the original text so that unchanged lines are written back
+// byte for byte.
+type line struct {
+    kind  int
+    raw   string
+    key   string
+    value string
+}
+
+type group struct {
+    name   string
+    header *line
+    lines  []*line
+}
+
 type DesktopFile struct {
-    categories      map[string]map[string]string
-    activeCategory string
-    sourcePath     string
+    groups          []*group
+    activeCategory  string
+    sourcePath      string
+    trailingNewline bool
 }
 
 func New() *DesktopFile {
     return &DesktopFile{
-        categories:      make(map[string]map[string]string),
-        activeCategory: "root",
-        sourcePath:     "self-generated",
+        groups:          []*group{{name: rootCategory}},
+        activeCategory:  rootCategory,
+        sourcePath:      "self-generated",
+        trailingNewline: true,
     }
 }
 
@@ -29,27 +57,55 @@ func (d *DesktopFile) Category(name string) *DesktopFile {
 }
 
 func (d *DesktopFile) Get(name string) (string, error) {
-    category, exists := d.categories[d.activeCategory]
-    if !exists {
-        return "", fmt.Errorf("category %s not found", d.activeCategory)
+    category := d.activeCategory
+    d.activeCategory = rootCategory
+
+    if !d.HasCategory(category) {
+        return "", fmt.Errorf("category %s not found", category)
+    }
+
+    entry := d.findEntry(category, name)
+    if entry == nil {
+        return "", fmt.Errorf("parameter %s not found in category %s", name, category)
     }
-    
-    value, exists := category[name]
-    if !exists {
-        return "", fmt.Errorf("parameter %s not found in category %s", name, d.activeCategory)
+    return entry.value, nil
+}
+
+// GetLocalized returns the value of a localized key such as Name or Comment
+// for locale, falling back through lang_COUNTRY@MODIFIER, lang_COUNTRY,
+// lang@MODIFIER and lang to the unlocalized value.
+func (d *DesktopFile) GetLocalized(name, locale string) (string, error) {
+    category := d.activeCategory
+    for _, candidate := range localeCandidates(locale) {
+        if value, err := d.Category(category).Get(name + "[" + candidate + "]"); err == nil {
+            return value, nil
+        }
     }
-    
-    d.activeCategory = "root"
-    return value, nil
+    return d.Category(category).Get(name)
 }
 
 func (d *DesktopFile) Set(name, value string) error {
-    if _, exists := d.categories[d.activeCategory]; !exists {
-        d.categories[d.activeCategory] = make(map[string]string)
+    category := d.activeCategory
+    d.activeCategory = rootCategory
+
+    if entry := d.findEntry(category, name); entry != nil {
+        entry.value = value
+        entry.raw = name + "=" + value
+        return nil
     }
-    
-    d.categories[d.activeCategory][name] = value
-    d.activeCategory = "root"
+
+    g := d.lastGroup(category)
+    if g == nil {
+        g = d.addGroup(category)
+    }
+
+    // Insert before trailing blank lines so group separators stay in place.
+    pos := len(g.lines)
+    for pos > 0 && g.lines[pos-1].kind == lineBlank {
+        pos--
+    }
+    entry := &line{kind: lineEntry, raw: name + "=" + value, key: name, value: value}
+    g.lines = append(g.lines[:pos], append([]*line{entry}, g.lines[pos:]...)...)
     return nil
 }
 
@@ -62,15 +118,54 @@ func (d *DesktopFile) HasValues(category string, values []string) bool {
     return true
 }
 
+func (d *DesktopFile) HasCategory(name string) bool {
+    return d.lastGroup(name) != nil
+}
+
+func (d *DesktopFile) Categories() []string {
+    var names []string
+    seen := make(map[string]bool)
+    for _, g := range d.groups[1:] {
+        if !seen[g.name] {
+            seen[g.name] = true
+            names = append(names, g.name)
+        }
+    }
+    return names
+}
+
+func (d *DesktopFile) Keys(category string) []string {
+    var keys []string
+    seen := make(map[string]bool)
+    for _, g := range d.groups {
+        if g.name != category {
+            continue
+        }
+        for _, l := range g.lines {
+            if l.kind == lineEntry && !seen[l.key] {
+                seen[l.key] = true
+                keys = append(keys, l.key)
+            }
+        }
+    }
+    return keys
+}
+
 func (d *DesktopFile) FromFile(path string) error {
-    file, err := os.Open(path)
+    data, err := os.ReadFile(path)
     if err != nil {
         return fmt.Errorf("error opening file: %w", err)
     }
-    defer file.Close()
 
     d.sourcePath = path
-    return d.parseFile(bufio.NewScanner(file))
+    return d.parse(string(data))
+}
+
+// FromBytes parses desktop entry content that does not live in a file of
+// its own, such as one read from inside an AppImage.
+func (d *DesktopFile) FromBytes(data []byte, source string) error {
+    d.sourcePath = source
+    return d.parse(string(data))
 }
 
 func (d *DesktopFile) ToFile(path string) error {
@@ -80,71 +175,148 @@ func (d *DesktopFile) ToFile(path string) error {
     }
     defer file.Close()
 
-    for category, params := range d.categories {
-        if _, err := fmt.Fprintf(file, "[%s]\n", category); err != nil {
-            return fmt.Errorf("error writing category: %w", err)
+    if _, err := file.WriteString(d.String()); err != nil {
+        return fmt.Errorf("error writing desktop file: %w", err)
+    }
+    return nil
+}
+
+func (d *DesktopFile) String() string {
+    var lines []string
+    for _, g := range d.groups {
+        if g.header != nil {
+            lines = append(lines, g.header.raw)
         }
-        
-        for name, value := range params {
-            if _, err := fmt.Fprintf(file, "%s=%s\n", name, value); err != nil {
-                return fmt.Errorf("error writing parameter: %w", err)
-            }
+        for _, l := range g.lines {
+            lines = append(lines, l.raw)
         }
     }
-    return nil
+
+    content := strings.Join(lines, "\n")
+    if d.trailingNewline && len(lines) > 0 {
+        content += "\n"
+    }
+    return content
 }
 
 func (d *DesktopFile) GetSource() string {
     return d.sourcePath
 }
 
-func (d *DesktopFile) parseFile(scanner *bufio.Scanner) error {
-    category := "root"
-    categoryRegex := regexp.MustCompile(`^[[:space:]]*\[(.+)\][[:space:]]*$`)
-    parameterRegex := regexp.MustCompile(`^(.+)=(.+)$`)
+func (d *DesktopFile) parse(content string) error {
+    d.groups = []*group{{name: rootCategory}}
+    d.trailingNewline = strings.HasSuffix(content, "\n")
 
-    for scanner.Scan() {
-        line := strings.TrimSpace(scanner.Text())
-        if line == "" || strings.HasPrefix(line, "#") {
-            continue
-        }
+    rawLines := strings.Split(content, "\n")
+    if d.trailingNewline || content == "" {
+        rawLines = rawLines[:len(rawLines)-1]
+    }
+
+    current := d.groups[0]
+    for _, raw := range rawLines {
+        trimmed := strings.TrimSpace(raw)
+
+        switch {
+        case trimmed == "":
+            current.lines = append(current.lines, &line{kind: lineBlank, raw: raw})
+        case strings.HasPrefix(trimmed, "#"):
+            current.lines = append(current.lines, &line{kind: lineComment, raw: raw})
+        default:
+            if matches := categoryRegex.FindStringSubmatch(trimmed); matches != nil {
+                current = &group{name: matches[1], header: &line{kind: lineGroup, raw: raw}}
+                d.groups = append(d.groups, current)
+                continue
+            }
 
-        if matches := categoryRegex.FindStringSubmatch(line); matches != nil {
-            category = matches[1]
-            if _, exists := d.categories[category]; !exists {
-                d.categories[category] = make(map[string]string)
+            // Values may contain "=", so only the first one separates the key.
+            if key, value, found := strings.Cut(trimmed, "="); found && strings.TrimSpace(key) != "" {
+                current.lines = append(current.lines, &line{
+                    kind:  lineEntry,
+                    raw:   raw,
+                    key:   strings.TrimSpace(key),
+                    value: strings.TrimSpace(value),
+                })
+                continue
             }
+
+            current.lines = append(current.lines, &line{kind: lineInvalid, raw: raw})
+        }
+    }
+
+    return nil
+}
+
+func (d *DesktopFile) lastGroup(name string) *group {
+    for i := len(d.groups) - 1; i >= 0; i-- {
+        if d.groups[i].name == name && (d.groups[i].header != nil || name == rootCategory) {
+            return d.groups[i]
+        }
+    }
+    return nil
+}
+
+// findEntry returns the last occurrence of key in the category, matching the
+// "last value wins" behaviour of duplicate keys.
+func (d *DesktopFile) findEntry(category, key string) *line {
+    var found *line
+    for _, g := range d.groups {
+        if g.name != category {
             continue
         }
+        for _, l := range g.lines {
+            if l.kind == lineEntry && l.key == key {
+                found = l
+            }
+        }
+    }
+    return found
+}
 
-        if matches := parameterRegex.FindStringSubmatch(line); matches != nil {
-            name := strings.TrimSpace(matches[1])
-            value := strings.TrimSpace(matches[2])
-            d.categories[category][name] = value
+func (d *DesktopFile) removeLine(target *line) {
+    for _, g := range d.groups {
+        for i, l := range g.lines {
+            if l == target {
+                g.lines = append(g.lines[:i], g.lines[i+1:]...)
+                return
+            }
         }
     }
+}
 
-    if err := scanner.Err(); err != nil {
-        return fmt.Errorf("error reading file: %w", err)
+// removeKey drops every occurrence of key in the category.
+func (d *DesktopFile) removeKey(category, key string) {
+    for entry := d.findEntry(category, key); entry != nil; entry = d.findEntry(category, key) {
+        d.removeLine(entry)
     }
-    return nil
 }
 
-func (d *DesktopFile) CreateAutostart(autostartDir string) error {
-    if err := os.MkdirAll(autostartDir, 0755); err != nil {
-        return fmt.Errorf("error creating autostart directory: %w", err)
+func (d *DesktopFile) addGroup(name string) *group {
+    if name == rootCategory {
+        return d.groups[0]
     }
 
-    name, err := d.Category("Desktop Entry").Get("Name")
-    if err != nil {
-        return fmt.Errorf("error getting application name: %w", err)
+    last := d.groups[len(d.groups)-1]
+    if n := len(last.lines); (n > 0 && last.lines[n-1].kind != lineBlank) || (n == 0 && last.header != nil) {
+        last.lines = append(last.lines, &line{kind: lineBlank})
     }
 
-    autostartPath := filepath.Join(autostartDir, fmt.Sprintf("%s.desktop", strings.ToLower(strings.ReplaceAll(name, " ", "-"))))
-    
+    g := &group{name: name, header: &line{kind: lineGroup, raw: "[" + name + "]"}}
+    d.groups = append(d.groups, g)
+    return g
+}
+
+// CreateAutostart writes the entry to autostartDir as <id>.desktop and
+// returns its path.
+func (d *DesktopFile) CreateAutostart(autostartDir, id string) (string, error) {
+    if err := os.MkdirAll(autostartDir, 0755); err != nil {
+        return "", fmt.Errorf("error creating autostart directory: %w", err)
+    }
+
+    autostartPath := filepath.Join(autostartDir, id+".desktop")
+
     if err := d.ToFile(autostartPath); err != nil {
-        return fmt.Errorf("error creating autostart entry: %w", err)
+        return "", fmt.Errorf("error creating autostart entry: %w", err)
     }
 
-    return nil
-} 
\ No newline at end of file
+    return autostartPath, nil
+}
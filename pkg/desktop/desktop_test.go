@@ -0,0 +1,130 @@
+package desktop
+
+import (
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+func readFixture(t *testing.T, name string) (string, *DesktopFile) {
+    t.Helper()
+    path := filepath.Join("testdata", name)
+    data, err := os.ReadFile(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    d := New()
+    if err := d.FromFile(path); err != nil {
+        t.Fatalf("FromFile(%s): %v", name, err)
+    }
+    return string(data), d
+}
+
+func TestRoundTrip(t *testing.T) {
+    fixtures, err := filepath.Glob(filepath.Join("testdata", "*.desktop"))
+    if err != nil || len(fixtures) == 0 {
+        t.Fatalf("no fixtures: %v", err)
+    }
+
+    for _, fixture := range fixtures {
+        name := filepath.Base(fixture)
+        t.Run(name, func(t *testing.T) {
+            original, d := readFixture(t, name)
+            if got := d.String(); got != original {
+                t.Errorf("round trip changed the file:\n%s", lineDiff(original, got))
+            }
+        })
+    }
+}
+
+func TestSetChangesOnlyThatLine(t *testing.T) {
+    tests := []struct {
+        fixture  string
+        category string
+        key      string
+        value    string
+        // line is the index of the line expected to change.
+        line int
+    }{
+        {"org.kde.krita.desktop", "Desktop Entry", "Exec", "/opt/krita.AppImage %F", 13},
+        {"org.kde.krita.desktop", "Desktop Entry", "Name[es_419]", "Krita Studio", 5},
+        // The last of the duplicate keys is the effective one.
+        {"firefox.desktop", "Desktop Entry", "Categories", "Network;", 13},
+        {"firefox.desktop", "Desktop Entry", "Keywords", "web;", 15},
+        {"firefox.desktop", "Desktop Action new-private-window", "Exec", "/opt/firefox.AppImage --private-window %u", 25},
+        {"obsidian.desktop", "Desktop Entry", "Categories", "Office;Utility;", 10},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.fixture+"/"+tt.key, func(t *testing.T) {
+            original, d := readFixture(t, tt.fixture)
+            if err := d.Category(tt.category).Set(tt.key, tt.value); err != nil {
+                t.Fatal(err)
+            }
+
+            got := d.String()
+            before, after := strings.Split(original, "\n"), strings.Split(got, "\n")
+            if len(before) != len(after) {
+                t.Fatalf("line count changed from %d to %d:\n%s", len(before), len(after), lineDiff(original, got))
+            }
+            for i := range before {
+                want := before[i]
+                if i == tt.line {
+                    want = tt.key + "=" + tt.value
+                }
+                if after[i] != want {
+                    t.Errorf("line %d = %q, want %q", i, after[i], want)
+                }
+            }
+            if value, _ := d.Category(tt.category).Get(tt.key); value != tt.value {
+                t.Errorf("Get(%s) = %q, want %q", tt.key, value, tt.value)
+            }
+        })
+    }
+}
+
+func TestSetAddsKeyToItsGroup(t *testing.T) {
+    original, d := readFixture(t, "firefox.desktop")
+    if err := d.Category("Desktop Action new-private-window").Set("Icon", "firefox"); err != nil {
+        t.Fatal(err)
+    }
+
+    // The new key goes after the group's last entry, before the blank line
+    // separating it from the next group.
+    before := strings.Split(original, "\n")
+    want := strings.Join(append(append(append([]string{}, before[:26]...), "Icon=firefox"), before[26:]...), "\n")
+    if got := d.String(); got != want {
+        t.Errorf("unexpected result:\n%s", lineDiff(want, got))
+    }
+}
+
+func TestMissingTrailingNewlineIsKept(t *testing.T) {
+    _, d := readFixture(t, "obsidian.desktop")
+    if err := d.Category("Desktop Entry").Set("Exec", "obsidian %U"); err != nil {
+        t.Fatal(err)
+    }
+    if strings.HasSuffix(d.String(), "\n") {
+        t.Error("a trailing newline was added")
+    }
+}
+
+// lineDiff lists the lines that differ between want and got.
+func lineDiff(want, got string) string {
+    a, b := strings.Split(want, "\n"), strings.Split(got, "\n")
+    var out []string
+    for i := 0; i < len(a) || i < len(b); i++ {
+        var x, y string
+        if i < len(a) {
+            x = a[i]
+        }
+        if i < len(b) {
+            y = b[i]
+        }
+        if x != y || i >= len(a) || i >= len(b) {
+            out = append(out, fmt.Sprintf("  line %d: -%s\n           +%s", i, x, y))
+        }
+    }
+    return strings.Join(out, "\n")
+}
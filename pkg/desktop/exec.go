@@ -0,0 +1,112 @@
+package desktop
+
+import (
+    "fmt"
+    "strings"
+)
+
+// Characters that force an Exec argument to be quoted.
+const execReserved = " \t\n\"'\\><~|&;$*?#()`"
+
+// ParseExec splits an unescaped Exec value into its arguments following the
+// quoting rules of the desktop entry specification. Field codes such as %U
+// are returned as ordinary arguments.
+func ParseExec(value string) ([]string, error) {
+    var args []string
+    var current strings.Builder
+    inArg := false
+
+    for i := 0; i < len(value); i++ {
+        c := value[i]
+        switch {
+        case c == ' ' || c == '\t' || c == '\n':
+            if inArg {
+                args = append(args, current.String())
+                current.Reset()
+                inArg = false
+            }
+        case c == '"':
+            inArg = true
+            closed := false
+            for i++; i < len(value); i++ {
+                if value[i] == '"' {
+                    closed = true
+                    break
+                }
+                if value[i] == '\\' && i+1 < len(value) && strings.IndexByte("\"`$\\", value[i+1]) >= 0 {
+                    i++
+                }
+                current.WriteByte(value[i])
+            }
+            if !closed {
+                return nil, fmt.Errorf("unterminated quote in exec value: %s", value)
+            }
+        default:
+            inArg = true
+            current.WriteByte(c)
+        }
+    }
+
+    if inArg {
+        args = append(args, current.String())
+    }
+    return args, nil
+}
+
+// JoinExec is the inverse of ParseExec, quoting arguments only where needed.
+func JoinExec(args []string) string {
+    quoted := make([]string, len(args))
+    for i, arg := range args {
+        if arg != "" && !strings.ContainsAny(arg, execReserved) {
+            quoted[i] = arg
+            continue
+        }
+
+        var builder strings.Builder
+        builder.WriteByte('"')
+        for j := 0; j < len(arg); j++ {
+            if strings.IndexByte("\"`$\\", arg[j]) >= 0 {
+                builder.WriteByte('\\')
+            }
+            builder.WriteByte(arg[j])
+        }
+        builder.WriteByte('"')
+        quoted[i] = builder.String()
+    }
+    return strings.Join(quoted, " ")
+}
+
+// ExecProgram returns the program token of the Exec key in the active
+// category.
+func (d *DesktopFile) ExecProgram() (string, error) {
+    category := d.activeCategory
+    value, err := d.GetString("Exec")
+    if err != nil {
+        return "", err
+    }
+
+    args, err := ParseExec(value)
+    if err != nil {
+        return "", err
+    }
+    if len(args) == 0 {
+        return "", fmt.Errorf("empty exec value in category %s", category)
+    }
+    return args[0], nil
+}
+
+// SetExecProgram replaces the program token of the Exec key in the active
+// category, keeping its arguments and field codes. A missing or unparsable
+// Exec is replaced by the program alone.
+func (d *DesktopFile) SetExecProgram(program string) error {
+    category := d.activeCategory
+    args := []string{program}
+
+    if value, err := d.Category(category).GetString("Exec"); err == nil {
+        if parsed, err := ParseExec(value); err == nil && len(parsed) > 0 {
+            args = append(args, parsed[1:]...)
+        }
+    }
+
+    return d.Category(category).SetString("Exec", JoinExec(args))
+}
@@ -0,0 +1,51 @@
+package desktop
+
+import (
+    "os"
+    "strings"
+)
+
+// CurrentLocale returns the message locale of the environment, following the
+// usual LC_ALL, LC_MESSAGES, LANG precedence.
+func CurrentLocale() string {
+    for _, name := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+        if value := os.Getenv(name); value != "" {
+            return value
+        }
+    }
+    return ""
+}
+
+// ParseLocale splits a locale such as "de_DE.UTF-8@euro" into its language,
+// country and modifier, dropping the encoding.
+func ParseLocale(locale string) (lang, country, modifier string) {
+    if i := strings.IndexByte(locale, '@'); i >= 0 {
+        locale, modifier = locale[:i], locale[i+1:]
+    }
+    if i := strings.IndexByte(locale, '.'); i >= 0 {
+        locale = locale[:i]
+    }
+    if i := strings.IndexByte(locale, '_'); i >= 0 {
+        locale, country = locale[:i], locale[i+1:]
+    }
+    return locale, country, modifier
+}
+
+func localeCandidates(locale string) []string {
+    lang, country, modifier := ParseLocale(locale)
+    if lang == "" || lang == "C" || lang == "POSIX" {
+        return nil
+    }
+
+    var candidates []string
+    if country != "" && modifier != "" {
+        candidates = append(candidates, lang+"_"+country+"@"+modifier)
+    }
+    if country != "" {
+        candidates = append(candidates, lang+"_"+country)
+    }
+    if modifier != "" {
+        candidates = append(candidates, lang+"@"+modifier)
+    }
+    return append(candidates, lang)
+}
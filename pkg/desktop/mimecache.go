@@ -0,0 +1,101 @@
+package desktop
+
+import (
+    "fmt"
+    "io/fs"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "sort"
+    "strings"
+)
+
+// MimeCacheFile is the index of MIME type handlers desktops read instead of
+// every entry of an applications directory.
+const MimeCacheFile = "mimeinfo.cache"
+
+// UpdateMimeCache regenerates the mimeinfo.cache of an applications
+// directory from the MimeType keys of its entries, as update-desktop-database
+// does. When that fails, update-desktop-database itself is tried.
+func UpdateMimeCache(appsDir string) error {
+    err := writeMimeCache(appsDir)
+    if err == nil {
+        return nil
+    }
+
+    tool, lookErr := exec.LookPath("update-desktop-database")
+    if lookErr != nil {
+        return err
+    }
+    out, runErr := exec.Command(tool, "--quiet", appsDir).CombinedOutput()
+    if runErr != nil {
+        return fmt.Errorf("%v; update-desktop-database failed: %v: %s", err, runErr, strings.TrimSpace(string(out)))
+    }
+    return nil
+}
+
+func writeMimeCache(appsDir string) error {
+    handlers := make(map[string][]string)
+    err := filepath.WalkDir(appsDir, func(path string, d fs.DirEntry, err error) error {
+        if err != nil {
+            return err
+        }
+        if d.IsDir() || filepath.Ext(path) != ".desktop" {
+            return nil
+        }
+
+        // Entries in subdirectories are known by IDs with "-" for "/".
+        rel, _ := filepath.Rel(appsDir, path)
+        id := strings.ReplaceAll(filepath.ToSlash(rel), "/", "-")
+
+        entry := New()
+        if err := entry.FromFile(path); err != nil {
+            return nil
+        }
+        if hidden, _ := entry.Category("Desktop Entry").GetBool("Hidden"); hidden {
+            return nil
+        }
+        mimeTypes, _ := entry.Category("Desktop Entry").GetStrings("MimeType")
+        for _, mimeType := range mimeTypes {
+            mimeType = strings.TrimSpace(mimeType)
+            if mimeType != "" && !contains(handlers[mimeType], id) {
+                handlers[mimeType] = append(handlers[mimeType], id)
+            }
+        }
+        return nil
+    })
+    if err != nil {
+        return fmt.Errorf("error reading %s: %w", appsDir, err)
+    }
+
+    mimeTypes := make([]string, 0, len(handlers))
+    for mimeType := range handlers {
+        mimeTypes = append(mimeTypes, mimeType)
+    }
+    sort.Strings(mimeTypes)
+
+    var b strings.Builder
+    b.WriteString("[MIME Cache]\n")
+    for _, mimeType := range mimeTypes {
+        ids := handlers[mimeType]
+        sort.Strings(ids)
+        fmt.Fprintf(&b, "%s=%s;\n", mimeType, strings.Join(ids, ";"))
+    }
+
+    tmp, err := os.CreateTemp(appsDir, "."+MimeCacheFile+"-*")
+    if err != nil {
+        return fmt.Errorf("error writing %s: %w", MimeCacheFile, err)
+    }
+    defer os.Remove(tmp.Name())
+    if _, err := tmp.WriteString(b.String()); err != nil {
+        tmp.Close()
+        return fmt.Errorf("error writing %s: %w", MimeCacheFile, err)
+    }
+    if err := tmp.Close(); err != nil {
+        return fmt.Errorf("error writing %s: %w", MimeCacheFile, err)
+    }
+    if err := os.Chmod(tmp.Name(), 0644); err != nil {
+        return err
+    }
+    return os.Rename(tmp.Name(), filepath.Join(appsDir, MimeCacheFile))
+}
@@ -0,0 +1,567 @@
+package desktop
+
+import (
+    "fmt"
+    "regexp"
+    "strings"
+)
+
+const (
+    mainGroup    = "Desktop Entry"
+    actionPrefix = "Desktop Action "
+)
+
+type Severity int
+
+const (
+    SeverityWarning Severity = iota
+    SeverityError
+)
+
+func (s Severity) String() string {
+    if s == SeverityError {
+        return "error"
+    }
+    return "warning"
+}
+
+// Problem is a single violation of the desktop entry specification. Problems
+// with a fix can be corrected by Repair without changing what the entry means.
+type Problem struct {
+    Severity Severity
+    Line     int
+    Group    string
+    Key      string
+    Message  string
+    fix      func()
+}
+
+func (p Problem) Fixable() bool {
+    return p.fix != nil
+}
+
+func (p Problem) String() string {
+    var builder strings.Builder
+    if p.Line > 0 {
+        fmt.Fprintf(&builder, "line %d: ", p.Line)
+    }
+    builder.WriteString(p.Severity.String())
+    builder.WriteString(": ")
+    if p.Group != "" {
+        fmt.Fprintf(&builder, "[%s] ", p.Group)
+    }
+    if p.Key != "" {
+        fmt.Fprintf(&builder, "%s: ", p.Key)
+    }
+    builder.WriteString(p.Message)
+    return builder.String()
+}
+
+type Report struct {
+    Source   string
+    Problems []Problem
+}
+
+func (r *Report) Errors() []Problem {
+    return r.filter(SeverityError)
+}
+
+func (r *Report) Warnings() []Problem {
+    return r.filter(SeverityWarning)
+}
+
+func (r *Report) HasErrors() bool {
+    return len(r.Errors()) > 0
+}
+
+func (r *Report) filter(severity Severity) []Problem {
+    var result []Problem
+    for _, p := range r.Problems {
+        if p.Severity == severity {
+            result = append(result, p)
+        }
+    }
+    return result
+}
+
+func (r *Report) String() string {
+    lines := make([]string, len(r.Problems))
+    for i, p := range r.Problems {
+        lines[i] = r.Source + ": " + p.String()
+    }
+    return strings.Join(lines, "\n")
+}
+
+var (
+    keyRegex    = regexp.MustCompile(`^([A-Za-z0-9-]+)(?:\[([^\]]*)\])?$`)
+    localeRegex = regexp.MustCompile(`^[a-z]{2,3}(_([A-Z]{2}|[0-9]{3}))?(\.[A-Za-z0-9-]+)?(@[A-Za-z0-9]+)?$`)
+
+    validTypes    = []string{"Application", "Link", "Directory"}
+    validVersions = []string{"1.0", "1.1", "1.2", "1.3", "1.4", "1.5"}
+
+    knownKeys = []string{
+        "Type", "Version", "Name", "GenericName", "NoDisplay", "Comment", "Icon",
+        "Hidden", "OnlyShowIn", "NotShowIn", "DBusActivatable", "TryExec", "Exec",
+        "Path", "Terminal", "Actions", "MimeType", "Categories", "Implements",
+        "Keywords", "StartupNotify", "StartupWMClass", "URL",
+        "PrefersNonDefaultGPU", "SingleMainWindow",
+    }
+    actionKeys      = []string{"Name", "Icon", "Exec"}
+    localizableKeys = []string{"Name", "GenericName", "Comment", "Icon", "Keywords"}
+    booleanKeys     = []string{
+        "NoDisplay", "Hidden", "DBusActivatable", "Terminal", "StartupNotify",
+        "PrefersNonDefaultGPU", "SingleMainWindow",
+    }
+    listKeys = []string{
+        "OnlyShowIn", "NotShowIn", "Actions", "MimeType", "Categories",
+        "Implements", "Keywords",
+    }
+
+    mainCategories = []string{
+        "AudioVideo", "Audio", "Video", "Development", "Education", "Game",
+        "Graphics", "Network", "Office", "Science", "Settings", "System", "Utility",
+    }
+    additionalCategories = []string{
+        "Building", "Debugger", "IDE", "GUIDesigner", "Profiling", "RevisionControl",
+        "Translation", "Calendar", "ContactManagement", "Database", "Dictionary",
+        "Chart", "Email", "Finance", "FlowChart", "PDA", "ProjectManagement",
+        "Presentation", "Spreadsheet", "WordProcessor", "2DGraphics",
+        "VectorGraphics", "RasterGraphics", "3DGraphics", "Scanning", "OCR",
+        "Photography", "Publishing", "Viewer", "TextTools", "DesktopSettings",
+        "HardwareSettings", "Printing", "PackageManager", "Dialup",
+        "InstantMessaging", "Chat", "IRCClient", "Feed", "FileTransfer", "HamRadio",
+        "News", "P2P", "RemoteAccess", "Telephony", "TelephonyTools",
+        "VideoConference", "WebBrowser", "WebDevelopment", "Midi", "Mixer",
+        "Sequencer", "Tuner", "TV", "AudioVideoEditing", "Player", "Recorder",
+        "DiscBurning", "ActionGame", "AdventureGame", "ArcadeGame", "BoardGame",
+        "BlocksGame", "CardGame", "KidsGame", "LogicGame", "RolePlaying", "Shooter",
+        "Simulation", "SportsGame", "StrategyGame", "Art", "Construction", "Music",
+        "Languages", "ArtificialIntelligence", "Astronomy", "Biology", "Chemistry",
+        "ComputerScience", "DataVisualization", "Economy", "Electricity",
+        "Geography", "Geology", "Geoscience", "History", "Humanities",
+        "ImageProcessing", "Literature", "Maps", "Math", "NumericalAnalysis",
+        "MedicalSoftware", "Physics", "Robotics", "Spirituality", "Sports",
+        "ParallelComputing", "Amusement", "Archiving", "Compression", "Electronics",
+        "Emulator", "Engineering", "FileTools", "FileManager", "TerminalEmulator",
+        "Filesystem", "Monitor", "Security", "Accessibility", "Calculator", "Clock",
+        "TextEditor", "Documentation", "Adult", "Core", "KDE", "GNOME", "XFCE",
+        "DDE", "GTK", "Qt", "Motif", "Java", "ConsoleOnly", "Screensaver",
+        "TrayIcon", "Applet", "Shell",
+    }
+)
+
+type validator struct {
+    d       *DesktopFile
+    report  *Report
+    lineNos map[*line]int
+}
+
+// Validate checks the entry against the freedesktop desktop entry
+// specification.
+func (d *DesktopFile) Validate() *Report {
+    v := &validator{
+        d:       d,
+        report:  &Report{Source: d.sourcePath},
+        lineNos: make(map[*line]int),
+    }
+
+    n := 0
+    for _, g := range d.groups {
+        if g.header != nil {
+            n++
+            v.lineNos[g.header] = n
+        }
+        for _, l := range g.lines {
+            n++
+            v.lineNos[l] = n
+        }
+    }
+
+    v.checkStructure()
+    if !d.HasCategory(mainGroup) {
+        v.add(SeverityError, nil, "", "", "missing required group [Desktop Entry]", nil)
+        return v.report
+    }
+    v.checkMainGroup()
+    v.checkActions()
+    return v.report
+}
+
+// Repair applies the fix of every fixable problem and returns the problems
+// that were fixed.
+func (d *DesktopFile) Repair() []Problem {
+    var fixed []Problem
+    for _, p := range d.Validate().Problems {
+        if p.fix != nil {
+            p.fix()
+            fixed = append(fixed, p)
+        }
+    }
+    return fixed
+}
+
+func (v *validator) add(severity Severity, l *line, group, key, message string, fix func()) {
+    v.report.Problems = append(v.report.Problems, Problem{
+        Severity: severity,
+        Line:     v.lineNos[l],
+        Group:    group,
+        Key:      key,
+        Message:  message,
+        fix:      fix,
+    })
+}
+
+func (v *validator) checkStructure() {
+    d := v.d
+    seenGroups := make(map[string]bool)
+
+    for i, g := range d.groups {
+        if i == 1 && g.name != mainGroup {
+            v.add(SeverityError, g.header, g.name, "", "first group must be [Desktop Entry]", nil)
+        }
+        if g.header != nil {
+            if seenGroups[g.name] {
+                v.add(SeverityError, g.header, g.name, "", "duplicate group", nil)
+            }
+            seenGroups[g.name] = true
+        }
+
+        last := make(map[string]*line)
+        for _, l := range g.lines {
+            switch l.kind {
+            case lineInvalid:
+                target := l
+                v.add(SeverityError, l, "", "", fmt.Sprintf("invalid line %q", strings.TrimSpace(l.raw)), func() {
+                    d.removeLine(target)
+                })
+                continue
+            case lineEntry:
+            default:
+                continue
+            }
+
+            if g.header == nil {
+                target := l
+                v.add(SeverityError, l, "", l.key, "key outside of any group", func() {
+                    d.removeLine(target)
+                })
+                continue
+            }
+
+            if previous, ok := last[l.key]; ok {
+                target := previous
+                v.add(SeverityError, previous, g.name, l.key, "duplicate key", func() {
+                    d.removeLine(target)
+                })
+            }
+            last[l.key] = l
+
+            v.checkKeyName(g.name, l)
+        }
+    }
+}
+
+func (v *validator) checkKeyName(groupName string, l *line) {
+    d := v.d
+    target := l
+    remove := func() {
+        d.removeLine(target)
+    }
+
+    matches := keyRegex.FindStringSubmatch(l.key)
+    if matches == nil {
+        v.add(SeverityError, l, groupName, l.key, "invalid key name", remove)
+        return
+    }
+
+    // Locales this check does not know may still be valid for some
+    // desktop, so they are reported but never removed.
+    base, locale := matches[1], matches[2]
+    if strings.Contains(l.key, "[") && !localeRegex.MatchString(locale) {
+        v.add(SeverityWarning, l, groupName, l.key, fmt.Sprintf("unrecognised locale %q", locale), nil)
+        return
+    }
+    if strings.HasPrefix(base, "X-") {
+        return
+    }
+
+    known := knownKeys
+    if strings.HasPrefix(groupName, actionPrefix) {
+        known = actionKeys
+    } else if groupName != mainGroup {
+        return
+    }
+
+    if !contains(known, base) {
+        v.add(SeverityWarning, l, groupName, l.key, "unknown key", nil)
+        return
+    }
+    if locale != "" && !contains(localizableKeys, base) {
+        v.add(SeverityWarning, l, groupName, l.key, "key cannot be localized", nil)
+    }
+}
+
+func (v *validator) checkMainGroup() {
+    d := v.d
+
+    typ := d.findEntry(mainGroup, "Type")
+    switch {
+    case typ == nil:
+        v.add(SeverityError, nil, mainGroup, "Type", "missing required key", func() {
+            d.Category(mainGroup).Set("Type", "Application")
+        })
+    case !contains(validTypes, typ.value):
+        v.add(SeverityError, typ, mainGroup, "Type", fmt.Sprintf("unknown type %q", typ.value), nil)
+    }
+
+    if version := d.findEntry(mainGroup, "Version"); version != nil && !contains(validVersions, version.value) {
+        v.add(SeverityError, version, mainGroup, "Version", fmt.Sprintf("unknown version %q", version.value), func() {
+            d.removeKey(mainGroup, "Version")
+        })
+    }
+
+    if d.findEntry(mainGroup, "Name") == nil {
+        v.add(SeverityError, nil, mainGroup, "Name", "missing required key", nil)
+    }
+
+    for _, key := range booleanKeys {
+        v.checkBool(key)
+    }
+    for _, key := range listKeys {
+        v.checkList(key)
+    }
+    v.checkCategories()
+
+    if typ == nil || typ.value == "Application" {
+        dbus := d.findEntry(mainGroup, "DBusActivatable")
+        if dbus == nil || dbus.value != "true" {
+            v.checkExec(mainGroup, true)
+        }
+    }
+    if typ != nil && typ.value == "Link" && d.findEntry(mainGroup, "URL") == nil {
+        v.add(SeverityError, nil, mainGroup, "URL", "missing required key for type Link", nil)
+    }
+}
+
+func (v *validator) checkBool(key string) {
+    d := v.d
+    entry := d.findEntry(mainGroup, key)
+    if entry == nil || entry.value == "true" || entry.value == "false" {
+        return
+    }
+
+    var fix func()
+    switch strings.ToLower(entry.value) {
+    case "true", "1":
+        fix = func() { d.Category(mainGroup).SetBool(key, true) }
+    case "false", "0":
+        fix = func() { d.Category(mainGroup).SetBool(key, false) }
+    }
+    v.add(SeverityError, entry, mainGroup, key, fmt.Sprintf("invalid boolean %q", entry.value), fix)
+}
+
+func (v *validator) checkList(key string) {
+    d := v.d
+    entry := d.findEntry(mainGroup, key)
+    if entry == nil || entry.value == "" || strings.HasSuffix(entry.value, ";") {
+        return
+    }
+
+    v.add(SeverityWarning, entry, mainGroup, key, "list is not terminated by a semicolon", func() {
+        if values, err := d.Category(mainGroup).GetStrings(key); err == nil {
+            d.Category(mainGroup).SetStrings(key, values)
+        }
+    })
+}
+
+func (v *validator) checkCategories() {
+    d := v.d
+    entry := d.findEntry(mainGroup, "Categories")
+    if entry == nil {
+        return
+    }
+
+    categories, _ := d.Category(mainGroup).GetStrings("Categories")
+    hasMain := false
+    for _, category := range categories {
+        if contains(mainCategories, category) {
+            hasMain = true
+            continue
+        }
+        if contains(additionalCategories, category) || strings.HasPrefix(category, "X-") {
+            continue
+        }
+
+        unknown := category
+        v.add(SeverityError, entry, mainGroup, "Categories", fmt.Sprintf("unregistered category %q", category), func() {
+            values, err := d.Category(mainGroup).GetStrings("Categories")
+            if err != nil {
+                return
+            }
+            var kept []string
+            for _, value := range values {
+                if value != unknown {
+                    kept = append(kept, value)
+                }
+            }
+            if len(kept) == 0 {
+                d.removeKey(mainGroup, "Categories")
+                return
+            }
+            d.Category(mainGroup).SetStrings("Categories", kept)
+        })
+    }
+
+    if !hasMain {
+        v.add(SeverityWarning, entry, mainGroup, "Categories", "no main category", nil)
+    }
+}
+
+func (v *validator) checkExec(groupName string, required bool) {
+    entry := v.d.findEntry(groupName, "Exec")
+    if entry == nil {
+        if required {
+            v.add(SeverityError, nil, groupName, "Exec", "missing required key", nil)
+        }
+        return
+    }
+
+    args, err := ParseExec(unescape(entry.value))
+    if err != nil {
+        v.add(SeverityError, entry, groupName, "Exec", err.Error(), nil)
+        return
+    }
+    if len(args) == 0 {
+        v.add(SeverityError, entry, groupName, "Exec", "empty command", nil)
+        return
+    }
+
+    fileCodes := 0
+    for _, field := range execFieldCodes(unescape(entry.value)) {
+        switch {
+        case field.code == 0:
+            v.add(SeverityError, entry, groupName, "Exec", "% without a field code, write %% for a literal %", nil)
+            continue
+        case field.quoted && field.code != '%':
+            v.add(SeverityError, entry, groupName, "Exec", fmt.Sprintf("field code %%%c inside a quoted argument", field.code), nil)
+            continue
+        }
+        switch field.code {
+        case '%', 'i', 'c', 'k':
+        case 'f', 'F', 'u', 'U':
+            fileCodes++
+        case 'd', 'D', 'n', 'N', 'v', 'm':
+            v.add(SeverityWarning, entry, groupName, "Exec", fmt.Sprintf("deprecated field code %%%c", field.code), nil)
+        default:
+            v.add(SeverityError, entry, groupName, "Exec", fmt.Sprintf("invalid field code %%%c", field.code), nil)
+        }
+    }
+    if fileCodes > 1 {
+        v.add(SeverityError, entry, groupName, "Exec", "more than one of %f, %F, %u or %U", nil)
+    }
+}
+
+type fieldCode struct {
+    code   byte
+    quoted bool
+}
+
+// execFieldCodes returns the field codes in the arguments of an unescaped
+// Exec value, which ParseExec has already checked, and whether each is inside
+// a quoted argument. A % that ends an argument has code 0.
+func execFieldCodes(value string) []fieldCode {
+    var codes []fieldCode
+    quoted, inArg, program := false, false, true
+    for i := 0; i < len(value); i++ {
+        c := value[i]
+        switch {
+        case quoted && c == '\\' && i+1 < len(value):
+            i++
+            continue
+        case c == '"':
+            quoted = !quoted
+            inArg = true
+            continue
+        case !quoted && (c == ' ' || c == '\t' || c == '\n'):
+            if inArg {
+                program = false
+            }
+            inArg = false
+            continue
+        }
+        inArg = true
+        if c != '%' || program {
+            continue
+        }
+
+        field := fieldCode{quoted: quoted}
+        if i+1 < len(value) {
+            next := value[i+1]
+            ends := next == '"' && quoted || strings.IndexByte(" \t\n", next) >= 0 && !quoted
+            if !ends {
+                field.code = next
+                i++
+            }
+        }
+        codes = append(codes, field)
+    }
+    return codes
+}
+
+func (v *validator) checkActions() {
+    d := v.d
+    listed := make(map[string]bool)
+
+    if entry := d.findEntry(mainGroup, "Actions"); entry != nil {
+        actions, _ := d.Category(mainGroup).GetStrings("Actions")
+        for _, action := range actions {
+            listed[action] = true
+            if d.HasCategory(actionPrefix + action) {
+                continue
+            }
+
+            missing := action
+            v.add(SeverityError, entry, mainGroup, "Actions", fmt.Sprintf("action %q has no [%s%s] group", action, actionPrefix, action), func() {
+                values, err := d.Category(mainGroup).GetStrings("Actions")
+                if err != nil {
+                    return
+                }
+                var kept []string
+                for _, value := range values {
+                    if value != missing {
+                        kept = append(kept, value)
+                    }
+                }
+                if len(kept) == 0 {
+                    d.removeKey(mainGroup, "Actions")
+                    return
+                }
+                d.Category(mainGroup).SetStrings("Actions", kept)
+            })
+        }
+    }
+
+    dbus := d.findEntry(mainGroup, "DBusActivatable")
+    for _, g := range d.groups {
+        if g.header == nil || !strings.HasPrefix(g.name, actionPrefix) {
+            continue
+        }
+
+        if !listed[strings.TrimPrefix(g.name, actionPrefix)] {
+            v.add(SeverityWarning, g.header, g.name, "", "action is not listed in Actions", nil)
+        }
+        if d.findEntry(g.name, "Name") == nil {
+            v.add(SeverityError, g.header, g.name, "Name", "missing required key", nil)
+        }
+        v.checkExec(g.name, dbus == nil || dbus.value != "true")
+    }
+}
+
+func contains(values []string, value string) bool {
+    for _, v := range values {
+        if v == value {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,102 @@
+package desktop
+
+import (
+    "strings"
+    "testing"
+)
+
+const validEntry = "[Desktop Entry]\nType=Application\nName=App\nExec=app\n"
+
+func TestValidateLocales(t *testing.T) {
+    tests := []struct {
+        key  string
+        warn bool
+    }{
+        {"Name[de]", false},
+        {"Name[de_DE]", false},
+        {"Name[es_419]", false},
+        {"Name[sr@latin]", false},
+        {"Name[sr_RS@latin]", false},
+        {"Name[de_DE.UTF-8]", false},
+        {"Name[ast]", false},
+        {"Name[es_41]", true},
+        {"Name[de-DE]", true},
+        {"Name[DE]", true},
+        {"Name[]", true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.key, func(t *testing.T) {
+            d := New()
+            if err := d.FromBytes([]byte(validEntry+tt.key+"=Localized\n"), "test.desktop"); err != nil {
+                t.Fatal(err)
+            }
+
+            report := d.Validate()
+            if report.HasErrors() {
+                t.Errorf("Validate() reported errors:\n%s", report)
+            }
+            warned := false
+            for _, p := range report.Warnings() {
+                if p.Key == tt.key && strings.Contains(p.Message, "locale") {
+                    warned = true
+                }
+            }
+            if warned != tt.warn {
+                t.Errorf("warned about the locale = %v, want %v:\n%s", warned, tt.warn, report)
+            }
+
+            // Unrecognised locales are kept under repair.
+            d.Repair()
+            if !strings.Contains(d.String(), tt.key+"=Localized\n") {
+                t.Errorf("Repair() removed %s:\n%s", tt.key, d.String())
+            }
+        })
+    }
+}
+
+func TestValidateExec(t *testing.T) {
+    tests := []struct {
+        exec string
+        // problem is part of the expected error, or empty for none.
+        problem string
+    }{
+        {"app %U", ""},
+        {"app --name=%c %f", ""},
+        {"app 50%%", ""},
+        {`app "100%% sure" %u`, ""},
+        {`"my app" %F`, ""},
+        {"app%1 %f", ""},
+        {"app 50%", "% without a field code"},
+        {"app % %f", "% without a field code"},
+        {`app "50%"`, "% without a field code"},
+        {"app %x", "invalid field code %x"},
+        {`app "%f"`, "field code %f inside a quoted argument"},
+        {`app "--file=%u"`, "field code %u inside a quoted argument"},
+        {`app "a \"%f\""`, "field code %f inside a quoted argument"},
+        {"app %f %U", "more than one of"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.exec, func(t *testing.T) {
+            d := New()
+            data := "[Desktop Entry]\nType=Application\nName=App\nExec=" + tt.exec + "\n"
+            if err := d.FromBytes([]byte(data), "test.desktop"); err != nil {
+                t.Fatal(err)
+            }
+
+            report := d.Validate()
+            var errs []string
+            for _, p := range report.Errors() {
+                errs = append(errs, p.Message)
+            }
+            if tt.problem == "" {
+                if len(errs) != 0 {
+                    t.Errorf("Validate() reported %q", errs)
+                }
+                return
+            }
+            if len(errs) != 1 || !strings.Contains(errs[0], tt.problem) {
+                t.Errorf("Validate() reported %q, want %q", errs, tt.problem)
+            }
+        })
+    }
+}
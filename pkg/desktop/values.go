@@ -0,0 +1,160 @@
+package desktop
+
+import (
+    "fmt"
+    "strings"
+)
+
+// GetString returns the value of a string key with escape sequences
+// (\s, \n, \t, \r, \\) decoded.
+func (d *DesktopFile) GetString(name string) (string, error) {
+    value, err := d.Get(name)
+    if err != nil {
+        return "", err
+    }
+    return unescape(value), nil
+}
+
+func (d *DesktopFile) GetLocaleString(name, locale string) (string, error) {
+    value, err := d.GetLocalized(name, locale)
+    if err != nil {
+        return "", err
+    }
+    return unescape(value), nil
+}
+
+// GetStrings returns the elements of a semicolon separated list such as
+// Categories, MimeType, Keywords or Actions. "\;" is a literal semicolon.
+func (d *DesktopFile) GetStrings(name string) ([]string, error) {
+    value, err := d.Get(name)
+    if err != nil {
+        return nil, err
+    }
+    return splitList(value), nil
+}
+
+func (d *DesktopFile) GetLocaleStrings(name, locale string) ([]string, error) {
+    value, err := d.GetLocalized(name, locale)
+    if err != nil {
+        return nil, err
+    }
+    return splitList(value), nil
+}
+
+func (d *DesktopFile) GetBool(name string) (bool, error) {
+    category := d.activeCategory
+    value, err := d.Get(name)
+    if err != nil {
+        return false, err
+    }
+
+    switch value {
+    case "true":
+        return true, nil
+    case "false":
+        return false, nil
+    }
+    return false, fmt.Errorf("parameter %s in category %s is not a boolean: %q", name, category, value)
+}
+
+func (d *DesktopFile) SetString(name, value string) error {
+    return d.Set(name, escape(value, false))
+}
+
+func (d *DesktopFile) SetStrings(name string, values []string) error {
+    var builder strings.Builder
+    for _, value := range values {
+        builder.WriteString(escape(value, true))
+        builder.WriteString(";")
+    }
+    return d.Set(name, builder.String())
+}
+
+func (d *DesktopFile) SetBool(name string, value bool) error {
+    if value {
+        return d.Set(name, "true")
+    }
+    return d.Set(name, "false")
+}
+
+func escape(value string, list bool) string {
+    var builder strings.Builder
+    for i, r := range value {
+        switch {
+        case r == '\\':
+            builder.WriteString(`\\`)
+        case r == '\n':
+            builder.WriteString(`\n`)
+        case r == '\t':
+            builder.WriteString(`\t`)
+        case r == '\r':
+            builder.WriteString(`\r`)
+        case r == ' ' && i == 0:
+            builder.WriteString(`\s`)
+        case r == ';' && list:
+            builder.WriteString(`\;`)
+        default:
+            builder.WriteRune(r)
+        }
+    }
+    return builder.String()
+}
+
+func unescape(value string) string {
+    elements := decode(value, false)
+    return elements[0]
+}
+
+func splitList(value string) []string {
+    elements := decode(value, true)
+    if elements[len(elements)-1] == "" {
+        elements = elements[:len(elements)-1]
+    }
+    return elements
+}
+
+// decode expands escape sequences and, for lists, splits on unescaped
+// semicolons. Unknown escapes are kept verbatim.
+func decode(value string, list bool) []string {
+    var elements []string
+    var builder strings.Builder
+
+    for i := 0; i < len(value); i++ {
+        c := value[i]
+        if c == ';' && list {
+            elements = append(elements, builder.String())
+            builder.Reset()
+            continue
+        }
+
+        if c != '\\' || i+1 == len(value) {
+            builder.WriteByte(c)
+            continue
+        }
+
+        i++
+        switch value[i] {
+        case 's':
+            builder.WriteByte(' ')
+        case 'n':
+            builder.WriteByte('\n')
+        case 't':
+            builder.WriteByte('\t')
+        case 'r':
+            builder.WriteByte('\r')
+        case '\\':
+            builder.WriteByte('\\')
+        case ';':
+            if list {
+                builder.WriteByte(';')
+            } else {
+                builder.WriteString(`\;`)
+            }
+        default:
+            builder.WriteByte('\\')
+            builder.WriteByte(value[i])
+        }
+    }
+
+    return append(elements, builder.String())
+}
@@ -0,0 +1,77 @@
+package fileutil
+
+import (
+    "errors"
+    "fmt"
+    "io/fs"
+    "os"
+    "path/filepath"
+    "strings"
+)
+
+const maxSymlinkHops = 40
+
+// ErrOutsideRoot is returned for paths and symlinks that lead out of the
+// root they are resolved in.
+var ErrOutsideRoot = errors.New("path leads outside the root")
+
+// ResolveIn returns the real path of name inside root, following symlinks as
+// if root were the filesystem root: absolute targets start at root, so a link
+// to /usr/share/icons stays in an extracted AppImage. Relative targets that
+// climb out of root, like ../../etc/shadow, are refused with ErrOutsideRoot.
+// name is either relative to root or a path below it.
+func ResolveIn(root, name string) (string, error) {
+    root = filepath.Clean(root)
+    rel := name
+    if filepath.IsAbs(name) {
+        var err error
+        rel, err = filepath.Rel(root, filepath.Clean(name))
+        if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+            return "", fmt.Errorf("%s: %w", name, ErrOutsideRoot)
+        }
+    }
+
+    pending := strings.Split(filepath.ToSlash(rel), "/")
+    var resolved []string
+    hops := 0
+    for len(pending) > 0 {
+        part := pending[0]
+        pending = pending[1:]
+
+        switch part {
+        case "", ".":
+            continue
+        case "..":
+            if len(resolved) == 0 {
+                return "", fmt.Errorf("%s: %w", name, ErrOutsideRoot)
+            }
+            resolved = resolved[:len(resolved)-1]
+            continue
+        }
+
+        current := filepath.Join(root, filepath.Join(resolved...), part)
+        info, err := os.Lstat(current)
+        if err != nil {
+            return "", err
+        }
+        if info.Mode()&fs.ModeSymlink == 0 {
+            resolved = append(resolved, part)
+            continue
+        }
+
+        hops++
+        if hops > maxSymlinkHops {
+            return "", fmt.Errorf("%s: too many levels of symbolic links", name)
+        }
+        target, err := os.Readlink(current)
+        if err != nil {
+            return "", err
+        }
+        if filepath.IsAbs(target) {
+            resolved = nil
+        }
+        pending = append(strings.Split(filepath.ToSlash(target), "/"), pending...)
+    }
+
+    return filepath.Join(root, filepath.Join(resolved...)), nil
+}
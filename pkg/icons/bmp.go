@@ -0,0 +1,221 @@
+package icons
+
+import (
+    "encoding/binary"
+    "fmt"
+    "image"
+    "image/color"
+    "math/bits"
+)
+
+const (
+    biRGB            = 0
+    biBitfields      = 3
+    biAlphaBitfields = 6
+)
+
+// decodeBMP decodes a BMP file: a 14 byte file header followed by a DIB.
+func decodeBMP(data []byte) (image.Image, error) {
+    if len(data) < 14 {
+        return nil, fmt.Errorf("truncated BMP header")
+    }
+    pixelOffset := int(binary.LittleEndian.Uint32(data[10:]))
+    if pixelOffset < 14 || pixelOffset > len(data) {
+        return nil, fmt.Errorf("invalid BMP pixel offset %d", pixelOffset)
+    }
+    return decodeDIB(data[14:], pixelOffset-14, false)
+}
+
+// decodeDIB decodes an uncompressed device independent bitmap. Without
+// pixelOffset (-1) the pixels follow the palette. In icons the bitmap is
+// twice as high as the image, the bottom half being the transparency mask.
+func decodeDIB(data []byte, pixelOffset int, icon bool) (image.Image, error) {
+    if len(data) < 40 {
+        return nil, fmt.Errorf("truncated bitmap header")
+    }
+    headerSize := int(binary.LittleEndian.Uint32(data))
+    if headerSize < 40 || headerSize > len(data) {
+        return nil, fmt.Errorf("unsupported bitmap header of %d bytes", headerSize)
+    }
+
+    width := int(int32(binary.LittleEndian.Uint32(data[4:])))
+    height := int(int32(binary.LittleEndian.Uint32(data[8:])))
+    bitCount := int(binary.LittleEndian.Uint16(data[14:]))
+    compression := binary.LittleEndian.Uint32(data[16:])
+    colorsUsed := int(binary.LittleEndian.Uint32(data[32:]))
+
+    topDown := height < 0
+    if topDown {
+        height = -height
+    }
+    if icon {
+        height /= 2
+    }
+    if width <= 0 || height <= 0 || width > 4096 || height > 4096 {
+        return nil, fmt.Errorf("invalid bitmap size %dx%d", width, height)
+    }
+
+    offset := headerSize
+    var masks [4]uint32
+    switch compression {
+    case biRGB:
+        if bitCount == 16 {
+            masks = [4]uint32{0x7c00, 0x03e0, 0x001f, 0}
+        } else {
+            masks = [4]uint32{0xff0000, 0xff00, 0xff, 0}
+        }
+    case biBitfields, biAlphaBitfields:
+        n := 3
+        if compression == biAlphaBitfields {
+            n = 4
+        }
+        if headerSize >= 56 {
+            // V4 and V5 headers carry the masks, including alpha.
+            for i := 0; i < 4; i++ {
+                masks[i] = binary.LittleEndian.Uint32(data[40+4*i:])
+            }
+        } else {
+            if len(data) < offset+4*n {
+                return nil, fmt.Errorf("truncated bitmap masks")
+            }
+            for i := 0; i < n; i++ {
+                masks[i] = binary.LittleEndian.Uint32(data[offset+4*i:])
+            }
+            offset += 4 * n
+        }
+    default:
+        return nil, fmt.Errorf("compressed bitmaps are not supported")
+    }
+
+    var palette []color.NRGBA
+    switch bitCount {
+    case 1, 4, 8:
+        n := colorsUsed
+        if n == 0 || n > 1<<bitCount {
+            n = 1 << bitCount
+        }
+        if len(data) < offset+4*n {
+            return nil, fmt.Errorf("truncated bitmap palette")
+        }
+        for i := 0; i < n; i++ {
+            p := data[offset+4*i:]
+            palette = append(palette, color.NRGBA{R: p[2], G: p[1], B: p[0], A: 0xff})
+        }
+        offset += 4 * n
+    case 16, 24, 32:
+    default:
+        return nil, fmt.Errorf("unsupported bitmap depth %d", bitCount)
+    }
+
+    if pixelOffset >= 0 {
+        offset = pixelOffset
+    }
+    stride := (width*bitCount + 31) / 32 * 4
+    if len(data) < offset+stride*height {
+        return nil, fmt.Errorf("truncated bitmap pixels")
+    }
+
+    img := image.NewNRGBA(image.Rect(0, 0, width, height))
+    hasAlpha := false
+    for y := 0; y < height; y++ {
+        row := data[offset+stride*y:]
+        dy := height - 1 - y
+        if topDown {
+            dy = y
+        }
+        for x := 0; x < width; x++ {
+            var c color.NRGBA
+            switch bitCount {
+            case 1, 4, 8:
+                bit := x * bitCount
+                index := int(row[bit/8]>>(8-bitCount-bit%8)) & (1<<bitCount - 1)
+                if index < len(palette) {
+                    c = palette[index]
+                }
+            case 16:
+                c = maskedColor(uint32(binary.LittleEndian.Uint16(row[2*x:])), masks)
+            case 24:
+                c = color.NRGBA{R: row[3*x+2], G: row[3*x+1], B: row[3*x], A: 0xff}
+            case 32:
+                c = maskedColor(binary.LittleEndian.Uint32(row[4*x:]), masks)
+                if compression == biRGB {
+                    c.A = row[4*x+3]
+                }
+            }
+            if c.A != 0xff {
+                hasAlpha = true
+            }
+            img.SetNRGBA(x, dy, c)
+        }
+    }
+
+    // 32 bit bitmaps often leave the alpha byte unused, all zero.
+    if bitCount == 32 && !anyOpaque(img) {
+        setOpaque(img)
+        hasAlpha = false
+    }
+
+    if icon && !(bitCount == 32 && hasAlpha) {
+        applyMask(img, data[offset+stride*height:], topDown)
+    }
+    return img, nil
+}
+
+// maskedColor extracts the channels selected by the red, green, blue and
+// alpha masks. Without an alpha mask the colour is opaque.
+func maskedColor(v uint32, masks [4]uint32) color.NRGBA {
+    channel := func(mask uint32) uint8 {
+        if mask == 0 {
+            return 0
+        }
+        shift := bits.TrailingZeros32(mask)
+        max := mask >> shift
+        return uint8(uint64(v&mask>>shift) * 255 / uint64(max))
+    }
+
+    c := color.NRGBA{R: channel(masks[0]), G: channel(masks[1]), B: channel(masks[2]), A: 0xff}
+    if masks[3] != 0 {
+        c.A = channel(masks[3])
+    }
+    return c
+}
+
+// applyMask makes the pixels set in an icon's 1 bit AND mask transparent.
+func applyMask(img *image.NRGBA, mask []byte, topDown bool) {
+    width, height := img.Rect.Dx(), img.Rect.Dy()
+    stride := (width + 31) / 32 * 4
+    if len(mask) < stride*height {
+        return
+    }
+    for y := 0; y < height; y++ {
+        row := mask[stride*y:]
+        dy := height - 1 - y
+        if topDown {
+            dy = y
+        }
+        for x := 0; x < width; x++ {
+            if row[x/8]&(0x80>>(x%8)) != 0 {
+                img.SetNRGBA(x, dy, color.NRGBA{})
+            } else {
+                c := img.NRGBAAt(x, dy)
+                c.A = 0xff
+                img.SetNRGBA(x, dy, c)
+            }
+        }
+    }
+}
+
+func anyOpaque(img *image.NRGBA) bool {
+    for i := 3; i < len(img.Pix); i += 4 {
+        if img.Pix[i] != 0 {
+            return true
+        }
+    }
+    return false
+}
+
+func setOpaque(img *image.NRGBA) {
+    for i := 3; i < len(img.Pix); i += 4 {
+        img.Pix[i] = 0xff
+    }
+}
@@ -0,0 +1,389 @@
+package icons
+
+import (
+    "bytes"
+    "encoding/binary"
+    "image"
+    "image/color"
+    "image/png"
+    "testing"
+)
+
+var (
+    red         = color.NRGBA{0xff, 0, 0, 0xff}
+    green       = color.NRGBA{0, 0xff, 0, 0xff}
+    blue        = color.NRGBA{0, 0, 0xff, 0xff}
+    white       = color.NRGBA{0xff, 0xff, 0xff, 0xff}
+    black       = color.NRGBA{0, 0, 0, 0xff}
+    transparent = color.NRGBA{}
+)
+
+// dib builds a device independent bitmap with a header of headerSize bytes.
+// rows are given bottom-up as stored, each padded to 4 bytes here; extra
+// follows the header, for masks or a palette.
+func dib(headerSize, width, height, bitCount int, compression uint32, extra []byte, rows ...[]byte) []byte {
+    le := binary.LittleEndian
+    header := make([]byte, headerSize)
+    le.PutUint32(header[0:], uint32(headerSize))
+    le.PutUint32(header[4:], uint32(int32(width)))
+    le.PutUint32(header[8:], uint32(int32(height)))
+    le.PutUint16(header[12:], 1)
+    le.PutUint16(header[14:], uint16(bitCount))
+    le.PutUint32(header[16:], compression)
+
+    b := append(header, extra...)
+    for _, row := range rows {
+        b = append(b, row...)
+        for len(row)%4 != 0 {
+            row = append(row, 0)
+            b = append(b, 0)
+        }
+    }
+    return b
+}
+
+// bmpFile prefixes a DIB with the BMP file header; pixelOffset counts from
+// the start of the DIB.
+func bmpFile(d []byte, pixelOffset int) []byte {
+    le := binary.LittleEndian
+    header := make([]byte, 14)
+    copy(header, "BM")
+    le.PutUint32(header[2:], uint32(14+len(d)))
+    le.PutUint32(header[10:], uint32(14+pixelOffset))
+    return append(header, d...)
+}
+
+// withHeader overwrites the header field at offset, such as the number of
+// palette colours or the masks of a V4 header.
+func withHeader(d []byte, offset int, values ...uint32) []byte {
+    copy(d[offset:], uint32s(values...))
+    return d
+}
+
+func uint32s(values ...uint32) []byte {
+    b := make([]byte, 4*len(values))
+    for i, v := range values {
+        binary.LittleEndian.PutUint32(b[4*i:], v)
+    }
+    return b
+}
+
+func uint16s(values ...uint16) []byte {
+    b := make([]byte, 2*len(values))
+    for i, v := range values {
+        binary.LittleEndian.PutUint16(b[2*i:], v)
+    }
+    return b
+}
+
+// icoFile builds an ICO file of the given frames; sizes are what the
+// directory claims.
+func icoFile(frames ...icoFrame) []byte {
+    le := binary.LittleEndian
+    b := make([]byte, 6+16*len(frames))
+    le.PutUint16(b[2:], 1)
+    le.PutUint16(b[4:], uint16(len(frames)))
+    for i, f := range frames {
+        e := b[6+16*i:]
+        e[0], e[1] = byte(f.size), byte(f.size)
+        le.PutUint16(e[6:], uint16(f.bitCount))
+        le.PutUint32(e[8:], uint32(len(f.data)))
+        le.PutUint32(e[12:], uint32(len(b)))
+        b = append(b, f.data...)
+    }
+    return b
+}
+
+type icoFrame struct {
+    size, bitCount int
+    data           []byte
+}
+
+// checkImage compares img to want, given as rows from the top.
+func checkImage(t *testing.T, img image.Image, want [][]color.NRGBA) {
+    t.Helper()
+    if b := img.Bounds(); b.Dx() != len(want[0]) || b.Dy() != len(want) {
+        t.Fatalf("image is %dx%d, want %dx%d", b.Dx(), b.Dy(), len(want[0]), len(want))
+    }
+    for y, row := range want {
+        for x, c := range row {
+            if got := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA); got != c {
+                t.Errorf("pixel (%d,%d) = %v, want %v", x, y, got, c)
+            }
+        }
+    }
+}
+
+func TestDecodeBMP(t *testing.T) {
+    tests := []struct {
+        name string
+        data []byte
+        want [][]color.NRGBA
+    }{
+        {
+            "24 bit",
+            bmpFile(dib(40, 2, 2, 24, biRGB, nil,
+                []byte{0xff, 0, 0, 0xff, 0xff, 0xff},
+                []byte{0, 0, 0xff, 0, 0xff, 0}), 40),
+            [][]color.NRGBA{{red, green}, {blue, white}},
+        },
+        {
+            "top-down",
+            bmpFile(dib(40, 1, -2, 24, biRGB, nil,
+                []byte{0, 0, 0xff},
+                []byte{0xff, 0, 0}), 40),
+            [][]color.NRGBA{{red}, {blue}},
+        },
+        {
+            "8 bit palette",
+            bmpFile(withHeader(dib(40, 3, 1, 8, biRGB, uint32s(0x00ff0000, 0x000000ff),
+                []byte{1, 0, 1}), 32, 2), 48),
+            [][]color.NRGBA{{blue, red, blue}},
+        },
+        {
+            "16 bit RGB565 bitfields",
+            bmpFile(dib(40, 4, 1, 16, biBitfields, uint32s(0xf800, 0x07e0, 0x001f),
+                uint16s(0xf800, 0x07e0, 0x001f, 0x8410)), 52),
+            [][]color.NRGBA{{red, green, blue, {131, 129, 131, 0xff}}},
+        },
+        {
+            "16 bit default 555",
+            bmpFile(dib(40, 2, 1, 16, biRGB, nil,
+                uint16s(0x7c00, 0x001f)), 40),
+            [][]color.NRGBA{{red, blue}},
+        },
+        {
+            "32 bit alpha bitfields",
+            bmpFile(dib(40, 2, 1, 32, biAlphaBitfields, uint32s(0xff, 0xff00, 0xff0000, 0xff000000),
+                uint32s(0x800000ff, 0x00ff0000)), 56),
+            [][]color.NRGBA{{{0xff, 0, 0, 0x80}, {0, 0, 0xff, 0}}},
+        },
+        {
+            // V4 headers carry all four masks in the header.
+            "V4 header with alpha mask",
+            bmpFile(withHeader(dib(108, 1, 1, 32, biBitfields, nil,
+                uint32s(0x40ff0000)), 40, 0xff0000, 0xff00, 0xff, 0xff000000), 108),
+            [][]color.NRGBA{{{0xff, 0, 0, 0x40}}},
+        },
+        {
+            // Channels wider than 24 bits overflow 32 bit arithmetic.
+            "wide masks",
+            bmpFile(dib(40, 2, 1, 32, biBitfields, uint32s(0xffffff80, 0x60, 0x1f),
+                uint32s(0xffffff80, 0x80000060)), 52),
+            [][]color.NRGBA{{red, {0x7f, 0xff, 0, 0xff}}},
+        },
+        {
+            // The unused alpha byte is zero everywhere.
+            "32 bit without alpha",
+            bmpFile(dib(40, 2, 1, 32, biRGB, nil,
+                uint32s(0x00ff0000, 0x000000ff)), 40),
+            [][]color.NRGBA{{red, blue}},
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if format := DetectFormat(tt.data); format != FormatBMP {
+                t.Fatalf("DetectFormat() = %q", format)
+            }
+            img, err := Decode(tt.data)
+            if err != nil {
+                t.Fatal(err)
+            }
+            checkImage(t, img, tt.want)
+        })
+    }
+}
+
+func TestMaskedColor(t *testing.T) {
+    tests := []struct {
+        v     uint32
+        masks [4]uint32
+        want  color.NRGBA
+    }{
+        {0x00ff8000, [4]uint32{0xff0000, 0xff00, 0xff, 0}, color.NRGBA{0xff, 0x80, 0, 0xff}},
+        {0x3ff00000, [4]uint32{0x3ff00000, 0xffc00, 0x3ff, 0}, color.NRGBA{0xff, 0, 0, 0xff}},
+        {0xffffffff, [4]uint32{0xffffffff, 0, 0, 0}, color.NRGBA{0xff, 0, 0, 0xff}},
+        {0x80000000, [4]uint32{0xffffffff, 0, 0, 0}, color.NRGBA{0x7f, 0, 0, 0xff}},
+        {0x01fffffe, [4]uint32{0x01fffffe, 0, 0, 0xfe000000}, color.NRGBA{0xff, 0, 0, 0}},
+    }
+    for _, tt := range tests {
+        if got := maskedColor(tt.v, tt.masks); got != tt.want {
+            t.Errorf("maskedColor(%#x, %#x) = %v, want %v", tt.v, tt.masks, got, tt.want)
+        }
+    }
+}
+
+func TestDecodeBMPErrors(t *testing.T) {
+    valid := dib(40, 2, 2, 24, biRGB, nil, make([]byte, 6), make([]byte, 6))
+    tests := map[string][]byte{
+        "truncated file header":  []byte("BM\x00\x00"),
+        "pixel offset too large": bmpFile(valid, 1000),
+        "truncated pixels":       bmpFile(valid[:50], 40),
+        "RLE":                    bmpFile(dib(40, 2, 2, 8, 1, nil, make([]byte, 2), make([]byte, 2)), 40),
+        "unsupported depth":      bmpFile(dib(40, 2, 2, 2, biRGB, nil, make([]byte, 1), make([]byte, 1)), 40),
+        "zero width":             bmpFile(dib(40, 0, 2, 24, biRGB, nil), 40),
+        "huge":                   bmpFile(dib(40, 100000, 1, 24, biRGB, nil), 40),
+        "truncated masks":        bmpFile(dib(40, 1, 1, 32, biBitfields, uint32s(0xff)), 44),
+    }
+    for name, data := range tests {
+        t.Run(name, func(t *testing.T) {
+            if _, err := decodeBMP(data); err == nil {
+                t.Error("decodeBMP succeeded")
+            }
+        })
+    }
+}
+
+// andMask builds the rows of a 1 bit icon mask, bottom-up, from strings of
+// '#' (transparent) and '.' (opaque).
+func andMask(rows ...string) [][]byte {
+    var mask [][]byte
+    for _, s := range rows {
+        row := make([]byte, 4)
+        for x, c := range s {
+            if c == '#' {
+                row[x/8] |= 0x80 >> (x % 8)
+            }
+        }
+        mask = append(mask, row)
+    }
+    return mask
+}
+
+func TestDecodeICO(t *testing.T) {
+    // A 2x2 24 bit frame: the DIB height covers the AND mask too.
+    colors := [][]byte{
+        {0xff, 0, 0, 0xff, 0xff, 0xff},
+        {0, 0, 0xff, 0, 0xff, 0},
+    }
+    masked := dib(40, 2, 4, 24, biRGB, nil, append(colors, andMask("#.", ".#")...)...)
+
+    // A 3x3 32 bit frame with alpha, whose mask is ignored.
+    alpha := dib(40, 3, 6, 32, biRGB, nil, append([][]byte{
+        uint32s(0x80ff0000, 0xffff0000, 0x00ff0000),
+        uint32s(0x80ff0000, 0xffff0000, 0x00ff0000),
+        uint32s(0x80ff0000, 0xffff0000, 0x00ff0000),
+    }, andMask("###", "###", "###")...)...)
+
+    var pngFrame bytes.Buffer
+    pngImage := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+    for i := range pngImage.Pix {
+        pngImage.Pix[i] = 0xff
+    }
+    if err := png.Encode(&pngFrame, pngImage); err != nil {
+        t.Fatal(err)
+    }
+    whites := [][]color.NRGBA{
+        {white, white, white, white}, {white, white, white, white},
+        {white, white, white, white}, {white, white, white, white},
+    }
+
+    halfRed := color.NRGBA{0xff, 0, 0, 0x80}
+    clearRed := color.NRGBA{0xff, 0, 0, 0}
+    maskedWant := [][]color.NRGBA{{red, transparent}, {transparent, white}}
+    alphaWant := [][]color.NRGBA{
+        {halfRed, red, clearRed}, {halfRed, red, clearRed}, {halfRed, red, clearRed},
+    }
+    tests := []struct {
+        name string
+        data []byte
+        want [][]color.NRGBA
+    }{
+        {"AND mask", icoFile(icoFrame{2, 24, masked}), maskedWant},
+        {"alpha channel", icoFile(icoFrame{3, 32, alpha}), alphaWant},
+        {"largest frame", icoFile(icoFrame{2, 24, masked}, icoFrame{3, 32, alpha}), alphaWant},
+        // PNG frames are measured by their own header; the directory says 1.
+        {"PNG frame", icoFile(icoFrame{3, 32, alpha}, icoFrame{1, 32, pngFrame.Bytes()}), whites},
+        {"unusable frame skipped", icoFile(icoFrame{2, 24, masked}, icoFrame{16, 32, nil}), maskedWant},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if format := DetectFormat(tt.data); format != FormatICO {
+                t.Fatalf("DetectFormat() = %q", format)
+            }
+            img, err := Decode(tt.data)
+            if err != nil {
+                t.Fatal(err)
+            }
+            checkImage(t, img, tt.want)
+        })
+    }
+
+    if _, err := decodeICO(icoFile(icoFrame{16, 32, nil})); err == nil {
+        t.Error("decodeICO of a file without usable frames succeeded")
+    }
+    if _, err := decodeICO([]byte{0, 0, 1, 0, 5, 0}); err == nil {
+        t.Error("decodeICO of a truncated directory succeeded")
+    }
+}
+
+func TestDecodeXPM(t *testing.T) {
+    src := `/* XPM */
+static char * icon_xpm[] = {
+/* columns rows colors chars-per-pixel */
+"4 3 6 2",
+"  c None",
+".. c #FF0000",
+"## g4 white m black",
+"++ c #00f",
+"@@ m white c green",
+"-- s background c #ffff0000ffff",
+"  ..##++",
+"@@--  ..",
+/* a comment between rows */
+"++++####"};
+`
+    magenta := color.NRGBA{0xff, 0, 0xff, 0xff}
+    want := [][]color.NRGBA{
+        {transparent, red, white, blue},
+        {green, magenta, transparent, red},
+        {blue, blue, white, white},
+    }
+
+    data := []byte(src)
+    if format := DetectFormat(data); format != FormatXPM {
+        t.Fatalf("DetectFormat() = %q", format)
+    }
+    img, err := Decode(data)
+    if err != nil {
+        t.Fatal(err)
+    }
+    checkImage(t, img, want)
+}
+
+func TestParseXPMColor(t *testing.T) {
+    tests := map[string]color.NRGBA{
+        "None":            transparent,
+        "#fff":            white,
+        "#808080":         {0x80, 0x80, 0x80, 0xff},
+        "#800080008000":   {0x7f, 0x7f, 0x7f, 0xff},
+        "Orange":          {0xff, 0xa5, 0, 0xff},
+        "light goldenrod": black,
+        "#12":             black,
+        "#xyzxyz":         black,
+    }
+    for spec, want := range tests {
+        if got := parseXPMColor(spec); got != want {
+            t.Errorf("parseXPMColor(%q) = %v, want %v", spec, got, want)
+        }
+    }
+}
+
+func TestDecodeXPMErrors(t *testing.T) {
+    tests := map[string]string{
+        "no values":      "/* XPM */",
+        "short header":   `/* XPM */ {"1 1 1"};`,
+        "invalid header": `/* XPM */ {"1 x 1 1", "a c red", "a"};`,
+        "too large":      `/* XPM */ {"5000 1 1 1", "a c red", "a"};`,
+        "missing rows":   `/* XPM */ {"1 2 1 1", "a c red", "a"};`,
+        "short row":      `/* XPM */ {"3 1 1 1", "a c red", "aa"};`,
+        "short colour":   `/* XPM */ {"1 1 1 2", "a", "aa"};`,
+    }
+    for name, src := range tests {
+        t.Run(name, func(t *testing.T) {
+            if _, err := decodeXPM([]byte(src)); err == nil {
+                t.Error("decodeXPM succeeded")
+            }
+        })
+    }
+}
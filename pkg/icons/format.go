@@ -0,0 +1,76 @@
+package icons
+
+import (
+    "bytes"
+    "fmt"
+    "image"
+    "image/png"
+)
+
+type Format string
+
+const (
+    FormatUnknown Format = ""
+    FormatPNG     Format = "png"
+    FormatICO     Format = "ico"
+    FormatBMP     Format = "bmp"
+    FormatXPM     Format = "xpm"
+    FormatSVG     Format = "svg"
+    FormatSVGZ    Format = "svgz"
+)
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// DetectFormat tells the format of an icon from its content, since names
+// like .DirIcon carry no extension and extensions are not always right.
+func DetectFormat(data []byte) Format {
+    switch {
+    case bytes.HasPrefix(data, pngSignature):
+        return FormatPNG
+    case len(data) >= 6 && bytes.HasPrefix(data, []byte{0, 0, 1, 0}) && (data[4] != 0 || data[5] != 0):
+        return FormatICO
+    case bytes.HasPrefix(data, []byte("BM")) && len(data) >= 26:
+        return FormatBMP
+    case bytes.HasPrefix(data, []byte{0x1f, 0x8b}):
+        return FormatSVGZ
+    }
+
+    head := data
+    if len(head) > 1024 {
+        head = head[:1024]
+    }
+    head = bytes.TrimLeft(bytes.TrimPrefix(head, []byte("\xef\xbb\xbf")), " \t\r\n")
+    switch {
+    case bytes.HasPrefix(head, []byte("/* XPM */")):
+        return FormatXPM
+    case bytes.Contains(head, []byte("<svg")):
+        return FormatSVG
+    case bytes.HasPrefix(head, []byte("<?xml")) || bytes.HasPrefix(head, []byte("<!DOCTYPE svg")):
+        return FormatSVG
+    }
+    return FormatUnknown
+}
+
+// IsVector reports whether icons of the format are passed through as they
+// are instead of being decoded.
+func (f Format) IsVector() bool {
+    return f == FormatSVG || f == FormatSVGZ
+}
+
+// Decode decodes a raster icon. Of an ICO file the largest frame is used.
+func Decode(data []byte) (image.Image, error) {
+    switch format := DetectFormat(data); format {
+    case FormatPNG:
+        return png.Decode(bytes.NewReader(data))
+    case FormatICO:
+        return decodeICO(data)
+    case FormatBMP:
+        return decodeBMP(data)
+    case FormatXPM:
+        return decodeXPM(data)
+    case FormatUnknown:
+        return nil, fmt.Errorf("unknown icon format")
+    default:
+        return nil, fmt.Errorf("%s icons are not decoded", format)
+    }
+}
@@ -0,0 +1,72 @@
+package icons
+
+import (
+    "bytes"
+    "encoding/binary"
+    "fmt"
+    "image"
+    "image/png"
+)
+
+type icoEntry struct {
+    width, height int
+    bitCount      int
+    offset, size  int
+}
+
+// decodeICO decodes the largest frame of an ICO file. Frames are either PNG
+// or a BMP without file header whose height covers the colour data and the
+// 1 bit transparency mask below it.
+func decodeICO(data []byte) (image.Image, error) {
+    if len(data) < 6 {
+        return nil, fmt.Errorf("truncated ICO header")
+    }
+    count := int(binary.LittleEndian.Uint16(data[4:]))
+    if len(data) < 6+16*count {
+        return nil, fmt.Errorf("truncated ICO directory")
+    }
+
+    var best *icoEntry
+    for i := 0; i < count; i++ {
+        raw := data[6+16*i:]
+        e := &icoEntry{
+            width:    int(raw[0]),
+            height:   int(raw[1]),
+            bitCount: int(binary.LittleEndian.Uint16(raw[6:])),
+            size:     int(binary.LittleEndian.Uint32(raw[8:])),
+            offset:   int(binary.LittleEndian.Uint32(raw[12:])),
+        }
+        // A dimension of 0 means 256.
+        if e.width == 0 {
+            e.width = 256
+        }
+        if e.height == 0 {
+            e.height = 256
+        }
+        if e.offset < 0 || e.size <= 0 || e.offset+e.size > len(data) {
+            continue
+        }
+
+        // PNG frames may be larger than the directory can say.
+        frame := data[e.offset : e.offset+e.size]
+        if bytes.HasPrefix(frame, pngSignature) {
+            if config, err := png.DecodeConfig(bytes.NewReader(frame)); err == nil {
+                e.width, e.height = config.Width, config.Height
+            }
+        }
+
+        if best == nil || e.width*e.height > best.width*best.height ||
+            (e.width*e.height == best.width*best.height && e.bitCount > best.bitCount) {
+            best = e
+        }
+    }
+    if best == nil {
+        return nil, fmt.Errorf("ICO file has no usable frame")
+    }
+
+    frame := data[best.offset : best.offset+best.size]
+    if bytes.HasPrefix(frame, pngSignature) {
+        return png.Decode(bytes.NewReader(frame))
+    }
+    return decodeDIB(frame, -1, true)
+}
@@ -0,0 +1,245 @@
+package icons
+
+import (
+    "bytes"
+    "fmt"
+    "image"
+    "image/png"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "regexp"
+    "sort"
+    "strings"
+
+    "appinstaller/pkg/fileutil"
+)
+
+const Theme = "hicolor"
+
+var (
+    sizeDirRegex = regexp.MustCompile(`^[0-9]+x[0-9]+(@[0-9]+)?$`)
+
+    themeExtensions = []string{".png", ".svg", ".svgz", ".xpm"}
+)
+
+// Namespaced is the name an application's icon is installed under, so it
+// cannot collide with icons of other packages.
+func Namespaced(appID string) string {
+    return "appinstaller-" + appID
+}
+
+// ThemeIcon is one size of an icon in an icon theme, e.g. 48x48/apps/foo.png.
+type ThemeIcon struct {
+    // SizeDir is the size directory: "48x48", "48x48@2", "scalable" or
+    // "symbolic".
+    SizeDir string
+    Path    string
+    Ext     string
+    // Symbolic is set for the -symbolic variant of the icon.
+    Symbolic bool
+}
+
+// Name returns the installed name of the icon, symbolic variants keep their
+// suffix.
+func (i ThemeIcon) Name(name string) string {
+    if i.Symbolic {
+        name += "-symbolic"
+    }
+    return name + i.Ext
+}
+
+// FindThemed returns every size of the icon called name in the hicolor theme
+// of an extracted AppImage. Symlinked icons are resolved inside extractDir;
+// those pointing out of it are left out.
+func FindThemed(extractDir, name string) []ThemeIcon {
+    if name == "" {
+        return nil
+    }
+
+    themeDir := filepath.Join(extractDir, "usr/share/icons", Theme)
+    sizeDirs, err := os.ReadDir(themeDir)
+    if err != nil {
+        return nil
+    }
+
+    var found []ThemeIcon
+    for _, sizeDir := range sizeDirs {
+        size := sizeDir.Name()
+        if !sizeDirRegex.MatchString(size) && size != "scalable" && size != "symbolic" {
+            continue
+        }
+
+        for _, ext := range themeExtensions {
+            for _, symbolic := range []bool{false, true} {
+                base := name
+                if symbolic {
+                    base += "-symbolic"
+                }
+                path, err := fileutil.ResolveIn(extractDir, filepath.Join(themeDir, size, "apps", base+ext))
+                if err != nil {
+                    continue
+                }
+                if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+                    found = append(found, ThemeIcon{SizeDir: size, Path: path, Ext: ext, Symbolic: symbolic})
+                }
+            }
+        }
+    }
+
+    sort.Slice(found, func(i, j int) bool {
+        if found[i].SizeDir != found[j].SizeDir {
+            return found[i].SizeDir < found[j].SizeDir
+        }
+        return found[i].Name("") < found[j].Name("")
+    })
+    return found
+}
+
+// Install copies the icons into a theme directory under name. It returns the
+// paths written, relative to themeDir.
+func Install(found []ThemeIcon, themeDir, name string) ([]string, error) {
+    var installed []string
+    for _, icon := range found {
+        rel := filepath.Join(icon.SizeDir, "apps", icon.Name(name))
+        dest := filepath.Join(themeDir, rel)
+        if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+            return installed, fmt.Errorf("error creating icon directory: %w", err)
+        }
+        if err := fileutil.Copy(icon.Path, dest); err != nil {
+            return installed, err
+        }
+        if err := os.Chmod(dest, 0644); err != nil {
+            return installed, err
+        }
+        installed = append(installed, rel)
+    }
+    return installed, nil
+}
+
+// StandardSizes are the fixed size directories of the hicolor theme that
+// icons are generated for.
+var StandardSizes = []int{16, 22, 24, 32, 48, 64, 96, 128, 256, 512}
+
+func sizeDir(size int) string {
+    return fmt.Sprintf("%dx%d", size, size)
+}
+
+// Generate writes PNGs of img at every standard size up to its own into a
+// theme directory, except the size directories in have. Sources smaller than
+// the smallest size are enlarged to it.
+func Generate(img image.Image, themeDir, name string, have map[string]bool) ([]string, error) {
+    largest := max(img.Bounds().Dx(), img.Bounds().Dy())
+
+    var installed []string
+    for i, size := range StandardSizes {
+        if size > largest && i > 0 {
+            break
+        }
+        rel := filepath.Join(sizeDir(size), "apps", name+".png")
+        if have[sizeDir(size)] {
+            continue
+        }
+
+        dest := filepath.Join(themeDir, rel)
+        if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+            return installed, fmt.Errorf("error creating icon directory: %w", err)
+        }
+        if err := writePNG(dest, Resize(img, size)); err != nil {
+            return installed, err
+        }
+        installed = append(installed, rel)
+    }
+    return installed, nil
+}
+
+func writePNG(path string, img image.Image) error {
+    var buf bytes.Buffer
+    if err := png.Encode(&buf, img); err != nil {
+        return fmt.Errorf("error encoding %s: %w", path, err)
+    }
+    return os.WriteFile(path, buf.Bytes(), 0644)
+}
+
+// InstallFile installs an icon that is not part of a theme. SVGs go into the
+// scalable directory untouched; raster formats are decoded and generated at
+// the standard sizes.
+func InstallFile(source, themeDir, name string) ([]string, error) {
+    data, err := os.ReadFile(source)
+    if err != nil {
+        return nil, err
+    }
+
+    format := DetectFormat(data)
+    if format.IsVector() {
+        found := []ThemeIcon{{SizeDir: "scalable", Path: source, Ext: "." + string(format)}}
+        return Install(found, themeDir, name)
+    }
+
+    img, err := Decode(data)
+    if err != nil {
+        return nil, fmt.Errorf("error decoding icon %s: %w", filepath.Base(source), err)
+    }
+    return Generate(img, themeDir, name, nil)
+}
+
+// FillSizes generates the standard sizes a theme's icons lack from the
+// largest raster icon among them.
+func FillSizes(found []ThemeIcon, themeDir, name string) ([]string, error) {
+    have := make(map[string]bool)
+    var best image.Image
+    bestSize := 0
+    for _, icon := range found {
+        if icon.Symbolic {
+            continue
+        }
+        have[icon.SizeDir] = true
+
+        var w, h int
+        if _, err := fmt.Sscanf(icon.SizeDir, "%dx%d", &w, &h); err != nil || w*h <= bestSize || icon.Ext == ".svg" || icon.Ext == ".svgz" {
+            continue
+        }
+        data, err := os.ReadFile(icon.Path)
+        if err != nil {
+            continue
+        }
+        if img, err := Decode(data); err == nil {
+            best, bestSize = img, w*h
+        }
+    }
+
+    if best == nil {
+        return nil, nil
+    }
+    return Generate(best, themeDir, name, have)
+}
+
+// UpdateCache refreshes the icon cache of a theme directory. The
+// directory's modification time is bumped first: GTK ignores a cache older
+// than its directory and rescans it instead. An existing cache is then
+// rebuilt with gtk-update-icon-cache when that is available.
+func UpdateCache(themeDir string) error {
+    if _, err := os.Stat(themeDir); err != nil {
+        return nil
+    }
+
+    if err := fileutil.Touch(themeDir); err != nil {
+        return err
+    }
+
+    _, cacheErr := os.Stat(filepath.Join(themeDir, "icon-theme.cache"))
+    _, indexErr := os.Stat(filepath.Join(themeDir, "index.theme"))
+    if cacheErr != nil && indexErr != nil {
+        return nil
+    }
+
+    tool, err := exec.LookPath("gtk-update-icon-cache")
+    if err != nil {
+        return nil
+    }
+    out, err := exec.Command(tool, "--quiet", "--force", "--ignore-theme-index", themeDir).CombinedOutput()
+    if err != nil {
+        return fmt.Errorf("gtk-update-icon-cache failed: %v: %s", err, strings.TrimSpace(string(out)))
+    }
+    return nil
+}
@@ -0,0 +1,124 @@
+package icons
+
+import (
+    "image"
+    "image/color"
+    "image/draw"
+    "math"
+)
+
+// Resize scales img to fit a size x size square, keeping its aspect ratio
+// and centring it on a transparent background.
+func Resize(img image.Image, size int) *image.NRGBA {
+    b := img.Bounds()
+    w, h := size, size
+    if b.Dx() > b.Dy() {
+        h = max(1, int(math.Round(float64(size)*float64(b.Dy())/float64(b.Dx()))))
+    } else if b.Dy() > b.Dx() {
+        w = max(1, int(math.Round(float64(size)*float64(b.Dx())/float64(b.Dy()))))
+    }
+
+    scaled := resample(img, w, h)
+    if w == size && h == size {
+        return scaled
+    }
+
+    out := image.NewNRGBA(image.Rect(0, 0, size, size))
+    offset := image.Pt((size-w)/2, (size-h)/2)
+    draw.Draw(out, scaled.Bounds().Add(offset), scaled, image.Point{}, draw.Src)
+    return out
+}
+
+// resample scales with a triangle filter whose support grows with the
+// reduction, which averages all source pixels when shrinking and
+// interpolates bilinearly when enlarging. Colours are filtered premultiplied
+// so transparent pixels do not bleed into the edges.
+func resample(img image.Image, w, h int) *image.NRGBA {
+    b := img.Bounds()
+    sw, sh := b.Dx(), b.Dy()
+
+    src := make([]float64, 4*sw*sh)
+    for y := 0; y < sh; y++ {
+        for x := 0; x < sw; x++ {
+            c := color.RGBA64Model.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.RGBA64)
+            i := 4 * (y*sw + x)
+            src[i], src[i+1], src[i+2], src[i+3] = float64(c.R), float64(c.G), float64(c.B), float64(c.A)
+        }
+    }
+
+    // Horizontal pass into a w x sh buffer, then vertical into w x h.
+    tmp := make([]float64, 4*w*sh)
+    for x, taps := range filterTaps(sw, w) {
+        for y := 0; y < sh; y++ {
+            var acc [4]float64
+            for _, t := range taps {
+                i := 4 * (y*sw + t.index)
+                for c := 0; c < 4; c++ {
+                    acc[c] += src[i+c] * t.weight
+                }
+            }
+            copy(tmp[4*(y*w+x):], acc[:])
+        }
+    }
+
+    out := image.NewNRGBA(image.Rect(0, 0, w, h))
+    for y, taps := range filterTaps(sh, h) {
+        for x := 0; x < w; x++ {
+            var acc [4]float64
+            for _, t := range taps {
+                i := 4 * (t.index*w + x)
+                for c := 0; c < 4; c++ {
+                    acc[c] += tmp[i+c] * t.weight
+                }
+            }
+
+            a := clamp16(acc[3])
+            if a == 0 {
+                continue
+            }
+            o := out.PixOffset(x, y)
+            out.Pix[o] = uint8(uint32(clamp16(acc[0]*0xffff/a)) >> 8)
+            out.Pix[o+1] = uint8(uint32(clamp16(acc[1]*0xffff/a)) >> 8)
+            out.Pix[o+2] = uint8(uint32(clamp16(acc[2]*0xffff/a)) >> 8)
+            out.Pix[o+3] = uint8(uint32(a) >> 8)
+        }
+    }
+    return out
+}
+
+type tap struct {
+    index  int
+    weight float64
+}
+
+// filterTaps returns, for every destination pixel, the source pixels and
+// weights contributing to it.
+func filterTaps(srcSize, dstSize int) [][]tap {
+    scale := float64(srcSize) / float64(dstSize)
+    support := math.Max(scale, 1)
+
+    all := make([][]tap, dstSize)
+    for d := range all {
+        center := (float64(d)+0.5)*scale - 0.5
+        var taps []tap
+        var total float64
+        for s := int(math.Floor(center - support)); s <= int(math.Ceil(center+support)); s++ {
+            weight := 1 - math.Abs(float64(s)-center)/support
+            if weight <= 0 {
+                continue
+            }
+            index := min(max(s, 0), srcSize-1)
+            taps = append(taps, tap{index: index, weight: weight})
+            total += weight
+        }
+        for i := range taps {
+            taps[i].weight /= total
+        }
+        all[d] = taps
+    }
+    return all
+}
+
+func clamp16(v float64) float64 {
+    return math.Max(0, math.Min(0xffff, math.Round(v)))
+}
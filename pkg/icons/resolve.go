@@ -0,0 +1,241 @@
+package icons
+
+import (
+    "bytes"
+    "fmt"
+    "image/png"
+    "io/fs"
+    "os"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+
+    "appinstaller/pkg/fileutil"
+)
+
+// iconFileExtensions are the files considered icons when searching an
+// extracted AppImage.
+var iconFileExtensions = []string{".png", ".svg", ".svgz", ".xpm", ".ico", ".bmp"}
+
+// Candidate is a file that may be an application's icon, with the score
+// Resolve gave it and why.
+type Candidate struct {
+    Path string
+    // Rel is the path relative to the extracted AppImage.
+    Rel     string
+    Score   int
+    Reasons []string
+    // Size is the larger dimension in pixels, 0 for scalable or unknown.
+    Size     int
+    Scalable bool
+    // ThemeName is the icon's name when it sits in the AppImage's hicolor
+    // theme, so the other sizes of it can be installed too.
+    ThemeName string
+}
+
+func (c *Candidate) add(score int, reason string) {
+    c.Score += score
+    c.Reasons = append(c.Reasons, reason)
+}
+
+func (c Candidate) String() string {
+    return fmt.Sprintf("%s (score %d: %s)", c.Rel, c.Score, strings.Join(c.Reasons, ", "))
+}
+
+// Resolve ranks the icons of an extracted AppImage, best first, following the
+// icon theme lookup: files named like the icon, in theme directories of the
+// applications context, preferring scalable and then large icons. The icon
+// key of the desktop entry and .DirIcon are the strongest signals; hints are
+// further names the icon may have, such as that of the desktop file. Files
+// outside the usual icon directories are only considered when named like the
+// icon, so bundled libraries' logos are not picked up.
+func Resolve(extractDir, iconKey string, hints []string) ([]Candidate, error) {
+    name := strings.TrimSuffix(filepath.Base(iconKey), iconExt(iconKey))
+    keyPath := ""
+    if strings.Contains(iconKey, "/") || iconExt(iconKey) != "" {
+        keyPath, _ = fileutil.ResolveIn(extractDir, strings.TrimPrefix(iconKey, "/"))
+    }
+
+    // Extraction may turn the .DirIcon symlink into a copy, so the file it
+    // stands for is recognised by its content.
+    var dirIcon []byte
+    if path, err := fileutil.ResolveIn(extractDir, ".DirIcon"); err == nil {
+        dirIcon, _ = os.ReadFile(path)
+    }
+
+    var candidates []Candidate
+    err := filepath.WalkDir(extractDir, func(path string, d fs.DirEntry, err error) error {
+        if err != nil || !d.Type().IsRegular() {
+            return nil
+        }
+        rel, _ := filepath.Rel(extractDir, path)
+        if rel != ".DirIcon" && iconExt(path) == "" {
+            return nil
+        }
+
+        c := Candidate{Path: path, Rel: rel}
+        base := strings.TrimSuffix(filepath.Base(path), iconExt(path))
+        signal := false
+        switch {
+        case path == keyPath:
+            c.add(150, "path from Icon key")
+            signal = true
+        case name != "" && base == name:
+            c.add(100, "matches Icon key")
+            signal = true
+        case name != "" && strings.EqualFold(base, name):
+            c.add(60, "matches Icon key ignoring case")
+            signal = true
+        default:
+            for _, hint := range hints {
+                if hint != "" && strings.EqualFold(base, hint) {
+                    c.add(50, "named after "+hint)
+                    signal = true
+                    break
+                }
+            }
+        }
+        if len(dirIcon) > 0 && sameContent(path, dirIcon) {
+            c.add(90, ".DirIcon")
+            signal = true
+        }
+        if strings.HasSuffix(base, "-symbolic") {
+            c.add(-50, "symbolic")
+        }
+
+        location := scoreLocation(&c, rel, base)
+        if !signal && location <= 0 {
+            return nil
+        }
+        scoreSize(&c)
+        candidates = append(candidates, c)
+        return nil
+    })
+    if err != nil {
+        return nil, err
+    }
+    if len(candidates) == 0 {
+        return nil, fmt.Errorf("no icon found in the AppImage")
+    }
+
+    sort.SliceStable(candidates, func(i, j int) bool {
+        a, b := candidates[i], candidates[j]
+        if a.Score != b.Score {
+            return a.Score > b.Score
+        }
+        if a.Size != b.Size {
+            return a.Size > b.Size
+        }
+        if len(a.Rel) != len(b.Rel) {
+            return len(a.Rel) < len(b.Rel)
+        }
+        return a.Rel < b.Rel
+    })
+    return candidates, nil
+}
+
+// scoreLocation rates the directory of a candidate as the icon theme
+// specification searches them: the hicolor theme, other themes, pixmaps,
+// then the top of the AppDir. Icons of other contexts, like toolbar actions,
+// count against it.
+func scoreLocation(c *Candidate, rel, base string) int {
+    parts := strings.Split(filepath.ToSlash(rel), "/")
+    score := 0
+    switch {
+    case len(parts) == 1:
+        score = 20
+        c.add(score, "top of AppDir")
+    case strings.HasPrefix(rel, filepath.Join("usr", "share", "pixmaps")+string(filepath.Separator)):
+        score = 25
+        c.add(score, "pixmaps")
+    case strings.HasPrefix(rel, filepath.Join("usr", "share", "icons")+string(filepath.Separator)):
+        dirs := parts[3 : len(parts)-1]
+        if len(dirs) == 0 {
+            score = 25
+            c.add(score, "icons directory")
+            break
+        }
+
+        theme := dirs[0]
+        context := ""
+        for _, dir := range dirs[1:] {
+            switch {
+            case sizeDirRegex.MatchString(dir):
+                fmt.Sscanf(dir, "%dx", &c.Size)
+            case dir == "scalable":
+                c.Scalable = true
+            case dir == "apps" || dir == "applications":
+                context = "apps"
+            case dir != "symbolic":
+                context = dir
+            }
+        }
+
+        switch {
+        case context == "":
+            score = -30
+            c.add(score, "no icon context")
+        case context != "apps":
+            score = -30
+            c.add(score, "icon context "+strconv.Quote(context))
+        case theme == Theme:
+            score = 40
+            c.add(score, "hicolor theme")
+            c.ThemeName = strings.TrimSuffix(base, "-symbolic")
+        default:
+            score = 30
+            c.add(score, theme+" theme")
+        }
+    }
+    return score
+}
+
+// scoreSize prefers scalable icons, then the largest, up to 256 pixels.
+func scoreSize(c *Candidate) {
+    data, err := os.ReadFile(c.Path)
+    if err != nil {
+        return
+    }
+    format := DetectFormat(data)
+    switch {
+    case c.Scalable || format.IsVector():
+        c.Scalable, c.Size = true, 0
+        c.add(25, "scalable")
+        return
+    case format == FormatUnknown:
+        c.add(-20, "unknown format")
+        return
+    case format == FormatPNG:
+        if config, err := png.DecodeConfig(bytes.NewReader(data)); err == nil {
+            c.Size = max(config.Width, config.Height)
+        }
+    case c.Size == 0:
+        if img, err := Decode(data); err == nil {
+            c.Size = max(img.Bounds().Dx(), img.Bounds().Dy())
+        }
+    }
+    if c.Size > 0 {
+        c.add(min(c.Size, 256)*20/256, fmt.Sprintf("%dpx", c.Size))
+    }
+}
+
+func sameContent(path string, data []byte) bool {
+    info, err := os.Stat(path)
+    if err != nil || info.Size() != int64(len(data)) {
+        return false
+    }
+    content, err := os.ReadFile(path)
+    return err == nil && bytes.Equal(content, data)
+}
+
+// iconExt returns the icon extension of path, or "" if it has none.
+func iconExt(path string) string {
+    ext := strings.ToLower(filepath.Ext(path))
+    for _, known := range iconFileExtensions {
+        if ext == known {
+            return filepath.Ext(path)
+        }
+    }
+    return ""
+}
@@ -0,0 +1,150 @@
+package icons
+
+import (
+    "fmt"
+    "image"
+    "image/color"
+    "strconv"
+    "strings"
+)
+
+// Colour names used by XPM icons in the wild; anything else falls back to
+// black.
+var xpmColorNames = map[string]color.NRGBA{
+    "black":   {0, 0, 0, 0xff},
+    "white":   {0xff, 0xff, 0xff, 0xff},
+    "red":     {0xff, 0, 0, 0xff},
+    "green":   {0, 0xff, 0, 0xff},
+    "blue":    {0, 0, 0xff, 0xff},
+    "yellow":  {0xff, 0xff, 0, 0xff},
+    "cyan":    {0, 0xff, 0xff, 0xff},
+    "magenta": {0xff, 0, 0xff, 0xff},
+    "gray":    {0xbe, 0xbe, 0xbe, 0xff},
+    "grey":    {0xbe, 0xbe, 0xbe, 0xff},
+    "orange":  {0xff, 0xa5, 0, 0xff},
+}
+
+// decodeXPM decodes an XPM3 image, the C source form with one string for
+// the header, one per colour and one per row of pixels.
+func decodeXPM(data []byte) (image.Image, error) {
+    values := xpmStrings(string(data))
+    if len(values) == 0 {
+        return nil, fmt.Errorf("XPM has no values")
+    }
+
+    var width, height, ncolors, cpp int
+    header := strings.Fields(values[0])
+    if len(header) < 4 {
+        return nil, fmt.Errorf("invalid XPM header %q", values[0])
+    }
+    for i, p := range []*int{&width, &height, &ncolors, &cpp} {
+        n, err := strconv.Atoi(header[i])
+        if err != nil || n <= 0 {
+            return nil, fmt.Errorf("invalid XPM header %q", values[0])
+        }
+        *p = n
+    }
+    if width > 4096 || height > 4096 || cpp > 8 {
+        return nil, fmt.Errorf("unsupported XPM size %dx%d", width, height)
+    }
+    if len(values) < 1+ncolors+height {
+        return nil, fmt.Errorf("truncated XPM")
+    }
+
+    colors := make(map[string]color.NRGBA, ncolors)
+    for _, line := range values[1 : 1+ncolors] {
+        if len(line) < cpp {
+            return nil, fmt.Errorf("invalid XPM colour %q", line)
+        }
+        colors[line[:cpp]] = xpmColor(line[cpp:])
+    }
+
+    img := image.NewNRGBA(image.Rect(0, 0, width, height))
+    for y, row := range values[1+ncolors : 1+ncolors+height] {
+        if len(row) < width*cpp {
+            return nil, fmt.Errorf("truncated XPM row %d", y)
+        }
+        for x := 0; x < width; x++ {
+            img.SetNRGBA(x, y, colors[row[x*cpp:(x+1)*cpp]])
+        }
+    }
+    return img, nil
+}
+
+// xpmStrings returns the string literals of the source, skipping comments.
+func xpmStrings(src string) []string {
+    var values []string
+    for i := 0; i < len(src); i++ {
+        switch {
+        case strings.HasPrefix(src[i:], "/*"):
+            end := strings.Index(src[i+2:], "*/")
+            if end < 0 {
+                return values
+            }
+            i += end + 3
+        case src[i] == '"':
+            end := strings.IndexByte(src[i+1:], '"')
+            if end < 0 {
+                return values
+            }
+            values = append(values, src[i+1:i+1+end])
+            i += end + 1
+        }
+    }
+    return values
+}
+
+// xpmColor picks the colour of a colour definition, which lists values for
+// several visuals: "c" for colour, then greyscale and mono as fallbacks.
+func xpmColor(def string) color.NRGBA {
+    visuals := make(map[string]string)
+    var key string
+    var value []string
+    flush := func() {
+        if key != "" {
+            visuals[key] = strings.Join(value, " ")
+        }
+    }
+    for _, field := range strings.Fields(def) {
+        switch field {
+        case "c", "m", "g", "g4", "s":
+            flush()
+            key, value = field, nil
+        default:
+            value = append(value, field)
+        }
+    }
+    flush()
+
+    for _, visual := range []string{"c", "g", "g4", "m"} {
+        if spec, ok := visuals[visual]; ok {
+            return parseXPMColor(spec)
+        }
+    }
+    return color.NRGBA{}
+}
+
+func parseXPMColor(spec string) color.NRGBA {
+    if strings.EqualFold(spec, "none") || strings.EqualFold(spec, "transparent") {
+        return color.NRGBA{}
+    }
+
+    if hex, ok := strings.CutPrefix(spec, "#"); ok && len(hex) > 0 && len(hex)%3 == 0 {
+        digits := len(hex) / 3
+        var channels [3]uint8
+        for i := range channels {
+            v, err := strconv.ParseUint(hex[i*digits:(i+1)*digits], 16, 64)
+            if err != nil {
+                return color.NRGBA{A: 0xff}
+            }
+            max := uint64(1)<<(4*digits) - 1
+            channels[i] = uint8(v * 255 / max)
+        }
+        return color.NRGBA{R: channels[0], G: channels[1], B: channels[2], A: 0xff}
+    }
+
+    if c, ok := xpmColorNames[strings.ToLower(strings.ReplaceAll(spec, " ", ""))]; ok {
+        return c
+    }
+    return color.NRGBA{A: 0xff}
+}
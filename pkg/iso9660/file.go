@@ -0,0 +1,112 @@
+package iso9660
+
+import (
+    "fmt"
+    "io"
+    "io/fs"
+    "time"
+)
+
+type fileInfo struct {
+    name   string
+    record *record
+}
+
+func (fi *fileInfo) Name() string {
+    return fi.name
+}
+
+func (fi *fileInfo) Size() int64 {
+    switch {
+    case fi.record.symlink:
+        return int64(len(fi.record.target))
+    case fi.record.isDir():
+        return 0
+    }
+    return int64(fi.record.size)
+}
+
+func (fi *fileInfo) Mode() fs.FileMode {
+    return fi.record.fileMode()
+}
+
+func (fi *fileInfo) ModTime() time.Time {
+    return fi.record.modTime
+}
+
+func (fi *fileInfo) IsDir() bool {
+    return fi.record.isDir()
+}
+
+func (fi *fileInfo) Sys() any {
+    return nil
+}
+
+type dir struct {
+    info    *fileInfo
+    entries []*record
+    pos     int
+}
+
+func (d *dir) Stat() (fs.FileInfo, error) {
+    return d.info, nil
+}
+
+func (d *dir) Read([]byte) (int, error) {
+    return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: fmt.Errorf("is a directory")}
+}
+
+func (d *dir) Close() error {
+    return nil
+}
+
+func (d *dir) ReadDir(n int) ([]fs.DirEntry, error) {
+    remaining := len(d.entries) - d.pos
+    if n > 0 && remaining == 0 {
+        return nil, io.EOF
+    }
+    if n > 0 && n < remaining {
+        remaining = n
+    }
+
+    result := make([]fs.DirEntry, remaining)
+    for i := range result {
+        child := d.entries[d.pos+i]
+        result[i] = fs.FileInfoToDirEntry(&fileInfo{name: child.name, record: child})
+    }
+    d.pos += remaining
+    return result, nil
+}
+
+type file struct {
+    *io.SectionReader
+    info *fileInfo
+}
+
+func (f *file) Stat() (fs.FileInfo, error) {
+    return f.info, nil
+}
+
+func (f *file) Close() error {
+    return nil
+}
+
+// Read reports a file that ends before its recorded size, as in a truncated
+// image, instead of returning it short.
+func (f *file) Read(p []byte) (int, error) {
+    n, err := f.SectionReader.Read(p)
+    if err == io.EOF {
+        if pos, _ := f.Seek(0, io.SeekCurrent); pos < f.Size() {
+            err = io.ErrUnexpectedEOF
+        }
+    }
+    return n, err
+}
+
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+    n, err := f.SectionReader.ReadAt(p, off)
+    if err == io.EOF && off+int64(n) < f.Size() {
+        err = io.ErrUnexpectedEOF
+    }
+    return n, err
+}
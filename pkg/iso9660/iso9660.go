@@ -0,0 +1,391 @@
+package iso9660
+
+import (
+    "encoding/binary"
+    "fmt"
+    "io"
+    "io/fs"
+    "path"
+    "strings"
+    "time"
+)
+
+const (
+    sectorSize       = 2048
+    descriptorStart  = 16
+    maxDescriptors   = 64
+    maxSymlinkHops   = 40
+    maxDirectorySize = 64 << 20
+
+    descriptorPrimary    = 1
+    descriptorTerminator = 255
+
+    flagDirectory  = 1 << 1
+    flagMultiExtent = 1 << 7
+)
+
+type record struct {
+    name    string
+    extent  uint32
+    size    uint32
+    flags   byte
+    modTime time.Time
+
+    mode      fs.FileMode
+    hasMode   bool
+    target    string
+    symlink   bool
+    relocated bool
+    childLink uint32
+}
+
+func (rec *record) isDir() bool {
+    return rec.flags&flagDirectory != 0 && !rec.symlink
+}
+
+func (rec *record) fileMode() fs.FileMode {
+    if rec.hasMode {
+        return rec.mode
+    }
+    if rec.isDir() {
+        return fs.ModeDir | 0555
+    }
+    return 0444
+}
+
+type Reader struct {
+    r         io.ReaderAt
+    blockSize int64
+    root      *record
+    suspSkip  int
+    rockRidge bool
+}
+
+func NewReader(r io.ReaderAt) (*Reader, error) {
+    descriptor := make([]byte, sectorSize)
+    for i := int64(0); i < maxDescriptors; i++ {
+        if _, err := r.ReadAt(descriptor, (descriptorStart+i)*sectorSize); err != nil {
+            return nil, fmt.Errorf("error reading volume descriptor: %w", err)
+        }
+
+        if string(descriptor[1:6]) != "CD001" {
+            return nil, fmt.Errorf("not an ISO 9660 filesystem")
+        }
+
+        switch descriptor[0] {
+        case descriptorPrimary:
+            return newReader(r, descriptor)
+        case descriptorTerminator:
+            return nil, fmt.Errorf("ISO 9660 primary volume descriptor not found")
+        }
+    }
+    return nil, fmt.Errorf("ISO 9660 primary volume descriptor not found")
+}
+
+func newReader(r io.ReaderAt, descriptor []byte) (*Reader, error) {
+    reader := &Reader{
+        r:         r,
+        blockSize: int64(binary.LittleEndian.Uint16(descriptor[128:130])),
+    }
+    if reader.blockSize == 0 {
+        reader.blockSize = sectorSize
+    }
+
+    root, _, err := parseRecord(descriptor[156:190])
+    if err != nil {
+        return nil, fmt.Errorf("error reading root directory record: %w", err)
+    }
+
+    // The SUSP "SP" entry in the root's "." record announces Rock Ridge and
+    // how many bytes to skip at the start of every system use area.
+    self := make([]byte, 255)
+    if _, err := r.ReadAt(self, int64(root.extent)*reader.blockSize); err != nil {
+        return nil, fmt.Errorf("error reading root directory: %w", err)
+    }
+    if _, systemUse, err := parseRecord(self); err == nil && len(systemUse) >= 7 &&
+        string(systemUse[0:2]) == "SP" && systemUse[4] == 0xBE && systemUse[5] == 0xEF {
+        reader.rockRidge = true
+        reader.suspSkip = int(systemUse[6])
+        if err := reader.applySystemUse(root, systemUse[7:]); err != nil {
+            return nil, err
+        }
+    }
+
+    root.name = "."
+    root.symlink = false
+    reader.root = root
+    return reader, nil
+}
+
+func parseRecord(data []byte) (*record, []byte, error) {
+    if len(data) < 34 || int(data[0]) > len(data) || data[0] < 34 {
+        return nil, nil, fmt.Errorf("invalid directory record")
+    }
+
+    length := int(data[0])
+    nameLength := int(data[32])
+    if 33+nameLength > length {
+        return nil, nil, fmt.Errorf("invalid directory record name length")
+    }
+
+    rec := &record{
+        extent:  binary.LittleEndian.Uint32(data[2:6]),
+        size:    binary.LittleEndian.Uint32(data[10:14]),
+        flags:   data[25],
+        modTime: parseTime(data[18:25]),
+        name:    isoName(data[33 : 33+nameLength]),
+    }
+
+    systemUse := 33 + nameLength
+    if nameLength%2 == 0 {
+        systemUse++
+    }
+    if systemUse > length {
+        systemUse = length
+    }
+    return rec, data[systemUse:length], nil
+}
+
+func isoName(raw []byte) string {
+    if len(raw) == 1 && raw[0] <= 1 {
+        return string(raw)
+    }
+
+    name := string(raw)
+    if i := strings.LastIndexByte(name, ';'); i >= 0 {
+        name = name[:i]
+    }
+    return strings.TrimSuffix(name, ".")
+}
+
+func parseTime(data []byte) time.Time {
+    if data[0] == 0 && data[1] == 0 && data[2] == 0 {
+        return time.Time{}
+    }
+
+    zone := time.FixedZone("", int(int8(data[6]))*15*60)
+    return time.Date(1900+int(data[0]), time.Month(data[1]), int(data[2]),
+        int(data[3]), int(data[4]), int(data[5]), 0, zone)
+}
+
+func (r *Reader) readDir(dir *record) ([]*record, error) {
+    if dir.size > maxDirectorySize {
+        return nil, fmt.Errorf("directory too large (%d bytes)", dir.size)
+    }
+
+    // Read whole sectors: like the kernel, accept a last record that starts
+    // within the recorded size but ends past it.
+    size := int(dir.size)
+    data := make([]byte, (size+sectorSize-1)/sectorSize*sectorSize)
+    if _, err := r.r.ReadAt(data, int64(dir.extent)*r.blockSize); err != nil && err != io.EOF {
+        return nil, fmt.Errorf("error reading directory: %w", err)
+    }
+
+    var records []*record
+    for pos := 0; pos < size; {
+        length := int(data[pos])
+        if length == 0 {
+            // Records never span sectors; the rest of this one is padding.
+            pos = (pos/sectorSize + 1) * sectorSize
+            continue
+        }
+
+        if pos+length > len(data) {
+            return nil, fmt.Errorf("directory record overruns directory")
+        }
+
+        raw := data[pos : pos+length]
+        pos += length
+
+        rec, systemUse, err := parseRecord(raw)
+        if err != nil {
+            return nil, err
+        }
+        if rec.name == "\x00" || rec.name == "\x01" {
+            continue
+        }
+
+        if r.rockRidge && len(systemUse) >= r.suspSkip {
+            if err := r.applySystemUse(rec, systemUse[r.suspSkip:]); err != nil {
+                return nil, err
+            }
+        }
+
+        if rec.relocated || rec.flags&flagMultiExtent != 0 {
+            continue
+        }
+
+        if rec.childLink != 0 {
+            if err := r.resolveChildLink(rec); err != nil {
+                return nil, err
+            }
+        }
+
+        records = append(records, rec)
+    }
+
+    return records, nil
+}
+
+func (r *Reader) resolveChildLink(rec *record) error {
+    self := make([]byte, 255)
+    if _, err := r.r.ReadAt(self, int64(rec.childLink)*r.blockSize); err != nil {
+        return fmt.Errorf("error reading relocated directory: %w", err)
+    }
+
+    target, _, err := parseRecord(self)
+    if err != nil {
+        return fmt.Errorf("error reading relocated directory: %w", err)
+    }
+
+    rec.extent = target.extent
+    rec.size = target.size
+    rec.flags |= flagDirectory
+    // The placeholder's PX entry may describe it as a file.
+    rec.mode = rec.mode&^fs.ModeType | fs.ModeDir
+    return nil
+}
+
+func (r *Reader) Open(name string) (fs.File, error) {
+    rec, err := r.lookup("open", name, true)
+    if err != nil {
+        return nil, err
+    }
+
+    info := &fileInfo{name: path.Base(name), record: rec}
+    if rec.isDir() {
+        entries, err := r.readDir(rec)
+        if err != nil {
+            return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+        }
+        return &dir{info: info, entries: entries}, nil
+    }
+
+    section := io.NewSectionReader(r.r, int64(rec.extent)*r.blockSize, int64(rec.size))
+    return &file{SectionReader: section, info: info}, nil
+}
+
+func (r *Reader) Stat(name string) (fs.FileInfo, error) {
+    rec, err := r.lookup("stat", name, true)
+    if err != nil {
+        return nil, err
+    }
+    return &fileInfo{name: path.Base(name), record: rec}, nil
+}
+
+func (r *Reader) Lstat(name string) (fs.FileInfo, error) {
+    rec, err := r.lookup("lstat", name, false)
+    if err != nil {
+        return nil, err
+    }
+    return &fileInfo{name: path.Base(name), record: rec}, nil
+}
+
+func (r *Reader) ReadLink(name string) (string, error) {
+    rec, err := r.lookup("readlink", name, false)
+    if err != nil {
+        return "", err
+    }
+
+    if !rec.symlink {
+        return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+    }
+    return rec.target, nil
+}
+
+func (r *Reader) ReadDir(name string) ([]fs.DirEntry, error) {
+    rec, err := r.lookup("readdir", name, true)
+    if err != nil {
+        return nil, err
+    }
+
+    if !rec.isDir() {
+        return nil, &fs.PathError{Op: "readdir", Path: name, Err: fmt.Errorf("not a directory")}
+    }
+
+    records, err := r.readDir(rec)
+    if err != nil {
+        return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+    }
+
+    entries := make([]fs.DirEntry, len(records))
+    for i, child := range records {
+        entries[i] = fs.FileInfoToDirEntry(&fileInfo{name: child.name, record: child})
+    }
+    return entries, nil
+}
+
+// lookup resolves name from the root directory. Symlinks are followed relative
+// to the image root, so a target can never point outside the filesystem.
+func (r *Reader) lookup(op, name string, follow bool) (*record, error) {
+    if !fs.ValidPath(name) {
+        return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+    }
+
+    pending := splitPath(name)
+    var resolved []string
+    hops := 0
+    current := r.root
+
+    for len(pending) > 0 {
+        component := pending[0]
+        pending = pending[1:]
+
+        if !current.isDir() {
+            return nil, &fs.PathError{Op: op, Path: name, Err: fmt.Errorf("not a directory")}
+        }
+
+        records, err := r.readDir(current)
+        if err != nil {
+            return nil, &fs.PathError{Op: op, Path: name, Err: err}
+        }
+
+        var next *record
+        for _, rec := range records {
+            if rec.name == component {
+                next = rec
+                break
+            }
+        }
+        if next == nil {
+            return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+        }
+
+        if next.symlink && (follow || len(pending) > 0) {
+            hops++
+            if hops > maxSymlinkHops {
+                return nil, &fs.PathError{Op: op, Path: name, Err: fmt.Errorf("too many levels of symbolic links")}
+            }
+
+            target := next.target
+            if !strings.HasPrefix(target, "/") {
+                target = path.Join(append(resolved, target)...)
+            }
+            pending = append(splitPath(path.Clean("/"+target)), pending...)
+            resolved = nil
+            current = r.root
+            continue
+        }
+
+        resolved = append(resolved, component)
+        current = next
+    }
+
+    return current, nil
+}
+
+func splitPath(name string) []string {
+    var parts []string
+    for _, part := range strings.Split(name, "/") {
+        switch part {
+        case "", ".":
+        case "..":
+            if len(parts) > 0 {
+                parts = parts[:len(parts)-1]
+            }
+        default:
+            parts = append(parts, part)
+        }
+    }
+    return parts
+}
@@ -0,0 +1,341 @@
+package iso9660
+
+import (
+    "bytes"
+    "encoding/binary"
+    "errors"
+    "io/fs"
+    "strings"
+    "testing"
+)
+
+// Sectors of the test image.
+const (
+    rootSector = 18 + iota
+    dirSector
+    movedSector
+    continuationSector
+    dataSector
+)
+
+func bothEndian32(v uint32) []byte {
+    b := make([]byte, 8)
+    binary.LittleEndian.PutUint32(b, v)
+    binary.BigEndian.PutUint32(b[4:], v)
+    return b
+}
+
+// susp returns a System Use Sharing Protocol entry.
+func susp(signature string, data ...byte) []byte {
+    return append([]byte{signature[0], signature[1], byte(4 + len(data)), 1}, data...)
+}
+
+func px(mode uint32) []byte {
+    data := bothEndian32(mode)
+    for i := 0; i < 3; i++ {
+        data = append(data, bothEndian32(1)...)
+    }
+    return susp("PX", data...)
+}
+
+func nm(flags byte, name string) []byte {
+    return susp("NM", append([]byte{flags}, name...)...)
+}
+
+// sl returns an "SL" entry; components are given as flags followed by the
+// content, e.g. "\x00usr".
+func sl(flags byte, components ...string) []byte {
+    data := []byte{flags}
+    for _, c := range components {
+        data = append(data, c[0], byte(len(c)-1))
+        data = append(data, c[1:]...)
+    }
+    return susp("SL", data...)
+}
+
+func ce(sector, offset, size uint32) []byte {
+    data := append(bothEndian32(sector), bothEndian32(offset)...)
+    return susp("CE", append(data, bothEndian32(size)...)...)
+}
+
+func dirRecord(name string, extent, size uint32, flags byte, systemUse ...[]byte) []byte {
+    rec := make([]byte, 33, 255)
+    binary.LittleEndian.PutUint32(rec[2:], extent)
+    binary.BigEndian.PutUint32(rec[6:], extent)
+    binary.LittleEndian.PutUint32(rec[10:], size)
+    binary.BigEndian.PutUint32(rec[14:], size)
+    copy(rec[18:25], []byte{124, 5, 17, 12, 30, 0, 0})
+    rec[25] = flags
+    rec[28], rec[31] = 1, 1
+    rec[32] = byte(len(name))
+    rec = append(rec, name...)
+    if len(name)%2 == 0 {
+        rec = append(rec, 0)
+    }
+    for _, su := range systemUse {
+        rec = append(rec, su...)
+    }
+    rec[0] = byte(len(rec))
+    return rec
+}
+
+// testFiles are the contents of the regular files, one per data sector.
+var testFiles = []string{
+    "Hello, ISO 9660!\n",
+    "#!/bin/sh\n",
+    "no Rock Ridge entries\n",
+    "nested\n",
+    "relocated\n",
+    "long name\n",
+}
+
+var longName = strings.Repeat("a-long-file-name-", 15) + "end.txt"
+
+// buildImage returns a Rock Ridge image as mkisofs -R lays it out:
+//
+//  hello.txt, AppRun, PLAIN.TXT (no Rock Ridge entries)
+//  dir/nested.txt                 mode 0600
+//  dir/up -> ../hello.txt
+//  dir/escape -> ../../../../hello.txt
+//  link -> hello.txt
+//  absolute -> /dir/nested.txt
+//  loop -> loop
+//  <long name>                    name continued in a CE area
+//  longlink -> ./<long name>      target continued in a CE area
+//  deep/deep.txt                  deep is relocated to the root as "moved"
+func buildImage() []byte {
+    image := make([]byte, (dataSector+len(testFiles))*sectorSize)
+    sector := func(n int) []byte { return image[n*sectorSize : (n+1)*sectorSize] }
+
+    pvd := sector(16)
+    pvd[0], pvd[6] = descriptorPrimary, 1
+    copy(pvd[1:], "CD001")
+    binary.LittleEndian.PutUint16(pvd[128:], sectorSize)
+    binary.BigEndian.PutUint16(pvd[130:], sectorSize)
+    copy(pvd[156:], dirRecord("\x00", rootSector, sectorSize, flagDirectory))
+    terminator := sector(17)
+    terminator[0], terminator[6] = descriptorTerminator, 1
+    copy(terminator[1:], "CD001")
+
+    for i, content := range testFiles {
+        copy(sector(dataSector+i), content)
+    }
+    fileRecord := func(isoName string, i int, systemUse ...[]byte) []byte {
+        return dirRecord(isoName, uint32(dataSector+i), uint32(len(testFiles[i])), 0, systemUse...)
+    }
+
+    // The continuation areas hold the rest of the long name, and the rest
+    // of the link target, whose second component is split across entries.
+    nameRest := nm(0, longName[100:])
+    linkRest := append(sl(0, "\x00"+longName[100:]), susp("ST")...)
+    copy(sector(continuationSector), nameRest)
+    copy(sector(continuationSector)[200:], linkRest)
+
+    const dir, link = 040755, 0120777
+    root := [][]byte{
+        dirRecord("\x00", rootSector, sectorSize, flagDirectory, susp("SP", 0xBE, 0xEF, 0), px(dir)),
+        dirRecord("\x01", rootSector, sectorSize, flagDirectory, px(dir)),
+        fileRecord("HELLO.TXT;1", 0, px(0100644), nm(0, "hello.txt")),
+        fileRecord("APPRUN;1", 1, px(0100755), nm(0, "AppRun")),
+        fileRecord("PLAIN.TXT;1", 2),
+        dirRecord("DIR", dirSector, sectorSize, flagDirectory, px(dir), nm(0, "dir")),
+        dirRecord("LINK;1", 0, 0, 0, px(link), nm(0, "link"), sl(0, "\x00hello.txt")),
+        dirRecord("ABSOLUTE;1", 0, 0, 0, px(link), nm(0, "absolute"), sl(0, "\x08", "\x00dir", "\x00nested.txt")),
+        dirRecord("LOOP;1", 0, 0, 0, px(link), nm(0, "loop"), sl(0, "\x00loop")),
+        fileRecord("LONG_NAM.TXT;1", 5, px(0100644), nm(nameContinue, longName[:100]),
+            ce(continuationSector, 0, uint32(len(nameRest)))),
+        dirRecord("LONGLINK;1", 0, 0, 0, px(link), nm(0, "longlink"),
+            sl(linkContinue, "\x02", "\x01"+longName[:100]), ce(continuationSector, 200, uint32(len(linkRest)))),
+        // A directory deeper than eight levels moved to the root, and the
+        // placeholder left in its place.
+        dirRecord("DEEP;1", 0, 0, 0, px(0100755), nm(0, "deep"), susp("CL", bothEndian32(movedSector)...)),
+        dirRecord("MOVED", movedSector, sectorSize, flagDirectory, px(dir), nm(0, "moved"), susp("RE")),
+    }
+    subdir := [][]byte{
+        dirRecord("\x00", dirSector, sectorSize, flagDirectory, px(dir)),
+        dirRecord("\x01", rootSector, sectorSize, flagDirectory, px(dir)),
+        fileRecord("NESTED.TXT;1", 3, px(0100600), nm(0, "nested.txt")),
+        dirRecord("UP;1", 0, 0, 0, px(link), nm(0, "up"), sl(0, "\x04", "\x00hello.txt")),
+        dirRecord("ESCAPE;1", 0, 0, 0, px(link), nm(0, "escape"), sl(0, "\x04", "\x04", "\x04", "\x04", "\x00hello.txt")),
+    }
+    moved := [][]byte{
+        dirRecord("\x00", movedSector, sectorSize, flagDirectory, px(dir)),
+        dirRecord("\x01", rootSector, sectorSize, flagDirectory, px(dir), susp("PL", bothEndian32(rootSector)...)),
+        fileRecord("DEEP.TXT;1", 4, px(0100644), nm(0, "deep.txt")),
+    }
+
+    copy(sector(rootSector), bytes.Join(root, nil))
+    copy(sector(dirSector), bytes.Join(subdir, nil))
+    copy(sector(movedSector), bytes.Join(moved, nil))
+    return image
+}
+
+func openImage(t *testing.T, image []byte) *Reader {
+    t.Helper()
+    r, err := NewReader(bytes.NewReader(image))
+    if err != nil {
+        t.Fatal(err)
+    }
+    return r
+}
+
+func TestRockRidge(t *testing.T) {
+    r := openImage(t, buildImage())
+    if !r.rockRidge {
+        t.Fatal("Rock Ridge not detected")
+    }
+
+    entries, err := r.ReadDir(".")
+    if err != nil {
+        t.Fatal(err)
+    }
+    var names []string
+    for _, e := range entries {
+        names = append(names, e.Name())
+    }
+    want := []string{"hello.txt", "AppRun", "PLAIN.TXT", "dir", "link", "absolute", "loop", longName, "longlink", "deep"}
+    if strings.Join(names, "|") != strings.Join(want, "|") {
+        t.Errorf("root entries:\n got %q\nwant %q", names, want)
+    }
+
+    tests := []struct {
+        name    string
+        content string
+        mode    fs.FileMode
+    }{
+        {"hello.txt", "Hello, ISO 9660!\n", 0644},
+        {"AppRun", "#!/bin/sh\n", 0755},
+        {"PLAIN.TXT", "no Rock Ridge entries\n", 0444},
+        {"dir/nested.txt", "nested\n", 0600},
+        {longName, "long name\n", 0644},
+        {"deep/deep.txt", "relocated\n", 0644},
+    }
+    for _, tt := range tests {
+        data, err := fs.ReadFile(r, tt.name)
+        if err != nil || string(data) != tt.content {
+            t.Errorf("ReadFile(%.20s) = %q, %v; want %q", tt.name, data, err, tt.content)
+        }
+        if info, err := r.Stat(tt.name); err != nil || info.Mode() != tt.mode {
+            t.Errorf("Stat(%.20s) = %v, %v; want mode %v", tt.name, info, err, tt.mode)
+        }
+    }
+
+    info, err := r.Lstat("deep")
+    if err != nil || !info.IsDir() || info.Mode().Type() != fs.ModeDir {
+        t.Errorf("Lstat(deep) = %v, %v; want the relocated directory", info, err)
+    }
+    if _, err := r.Stat("moved"); !errors.Is(err, fs.ErrNotExist) {
+        t.Errorf("Stat(moved) = %v, want it hidden", err)
+    }
+    if err := walk(r); err != nil {
+        t.Error(err)
+    }
+}
+
+func TestRockRidgeSymlinks(t *testing.T) {
+    r := openImage(t, buildImage())
+
+    tests := []struct {
+        name    string
+        target  string
+        content string
+    }{
+        {"link", "hello.txt", "Hello, ISO 9660!\n"},
+        {"absolute", "/dir/nested.txt", "nested\n"},
+        {"dir/up", "../hello.txt", "Hello, ISO 9660!\n"},
+        // Resolved inside the image, never on the host.
+        {"dir/escape", "../../../../hello.txt", "Hello, ISO 9660!\n"},
+        {"longlink", "./" + longName, "long name\n"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if target, err := r.ReadLink(tt.name); err != nil || target != tt.target {
+                t.Errorf("ReadLink() = %q, %v; want %q", target, err, tt.target)
+            }
+            if info, err := r.Lstat(tt.name); err != nil || info.Mode()&fs.ModeSymlink == 0 {
+                t.Errorf("Lstat() = %v, %v; want a symlink", info, err)
+            }
+            if data, err := fs.ReadFile(r, tt.name); err != nil || string(data) != tt.content {
+                t.Errorf("ReadFile() = %q, %v; want %q", data, err, tt.content)
+            }
+        })
+    }
+
+    if _, err := r.Stat("loop"); err == nil {
+        t.Error("Stat of a symlink loop succeeded")
+    }
+    for _, name := range []string{"../hello.txt", "/hello.txt"} {
+        if _, err := r.Open(name); !errors.Is(err, fs.ErrInvalid) {
+            t.Errorf("Open(%s) = %v, want fs.ErrInvalid", name, err)
+        }
+    }
+}
+
+func TestCorruptImages(t *testing.T) {
+    valid := buildImage()
+    corrupt := func(offset int, data ...byte) []byte {
+        image := bytes.Clone(valid)
+        copy(image[offset:], data)
+        return image
+    }
+    ceEntry := func(image []byte) int {
+        return rootSector*sectorSize + bytes.Index(image[rootSector*sectorSize:], []byte("CE\x1c\x01"))
+    }
+
+    // A continuation area that continues in itself.
+    selfContinued := corrupt(continuationSector*sectorSize, ce(continuationSector, 0, 28)...)
+    copy(selfContinued[ceEntry(selfContinued):], ce(continuationSector, 0, 28))
+
+    // The first record after "." and ".." in dir.
+    nested := dirSector*sectorSize + 2*len(dirRecord("\x00", 0, 0, 0, px(0)))
+
+    tests := []struct {
+        name  string
+        image []byte
+        // open is set when NewReader fails.
+        open bool
+    }{
+        {"empty", nil, true},
+        {"not ISO 9660", corrupt(16*sectorSize+1, 'X'), true},
+        {"no primary descriptor", corrupt(16*sectorSize, descriptorTerminator), true},
+        {"truncated before the root", valid[:rootSector*sectorSize], true},
+        {"truncated file data", valid[:len(valid)-sectorSize+5], false},
+        {"continuation area outside the image", corrupt(ceEntry(valid)+4, 0xff, 0xff, 0, 0), false},
+        {"oversized continuation area", corrupt(ceEntry(valid)+20, 0xff, 0xff, 0, 0), false},
+        {"continuation loop", selfContinued, false},
+        {"record too short", corrupt(nested, 20), false},
+        {"name overruns record", corrupt(nested+32, 200), false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            r, err := NewReader(bytes.NewReader(tt.image))
+            if tt.open {
+                if err == nil {
+                    t.Error("NewReader succeeded")
+                }
+                return
+            }
+            if err != nil {
+                t.Fatalf("NewReader: %v", err)
+            }
+            if err := walk(r); err == nil {
+                t.Error("reading the image succeeded")
+            }
+        })
+    }
+}
+
+// walk opens and reads everything in the image and returns the first error.
+func walk(r *Reader) error {
+    var first error
+    fs.WalkDir(r, ".", func(name string, d fs.DirEntry, err error) error {
+        if err == nil && d.Type().IsRegular() {
+            _, err = fs.ReadFile(r, name)
+        }
+        if err != nil && first == nil {
+            first = err
+        }
+        return nil
+    })
+    return first
+}
@@ -0,0 +1,167 @@
+package iso9660
+
+import (
+    "encoding/binary"
+    "fmt"
+    "io/fs"
+    "strings"
+)
+
+const (
+    maxContinuations = 16
+
+    nameContinue = 1 << 0
+    nameCurrent  = 1 << 1
+    nameParent   = 1 << 2
+
+    linkContinue = 1 << 0
+    linkCurrent  = 1 << 1
+    linkParent   = 1 << 2
+    linkRoot     = 1 << 3
+
+    posixTypeMask = 0170000
+    posixDir      = 0040000
+    posixSymlink  = 0120000
+)
+
+// applySystemUse reads the SUSP entries of a directory record, following
+// "CE" continuation areas, and applies the Rock Ridge ones to rec.
+func (r *Reader) applySystemUse(rec *record, area []byte) error {
+    var name strings.Builder
+    var target strings.Builder
+    hasName := false
+    linkSeparator := false
+
+    for continuations := 0; area != nil; continuations++ {
+        if continuations > maxContinuations {
+            return fmt.Errorf("too many SUSP continuation areas")
+        }
+
+        var next []byte
+        for len(area) >= 4 {
+            length := int(area[2])
+            if length < 4 || length > len(area) {
+                break
+            }
+            entry := area[:length]
+            area = area[length:]
+
+            switch string(entry[0:2]) {
+            case "NM":
+                if len(entry) < 5 {
+                    continue
+                }
+                switch {
+                case entry[4]&nameCurrent != 0:
+                    name.WriteString(".")
+                case entry[4]&nameParent != 0:
+                    name.WriteString("..")
+                default:
+                    name.Write(entry[5:])
+                }
+                hasName = true
+            case "PX":
+                if len(entry) < 8 {
+                    continue
+                }
+                rec.mode = posixMode(binary.LittleEndian.Uint32(entry[4:8]))
+                rec.hasMode = true
+                if rec.mode&fs.ModeSymlink != 0 {
+                    rec.symlink = true
+                }
+            case "SL":
+                if len(entry) < 5 {
+                    continue
+                }
+                rec.symlink = true
+                linkSeparator = appendLinkComponents(&target, entry[5:], linkSeparator)
+            case "CL":
+                if len(entry) >= 8 {
+                    rec.childLink = binary.LittleEndian.Uint32(entry[4:8])
+                }
+            case "RE":
+                rec.relocated = true
+            case "CE":
+                if len(entry) < 28 {
+                    continue
+                }
+                block := binary.LittleEndian.Uint32(entry[4:8])
+                offset := binary.LittleEndian.Uint32(entry[12:16])
+                size := binary.LittleEndian.Uint32(entry[20:24])
+                if size > sectorSize {
+                    return fmt.Errorf("invalid SUSP continuation area size %d", size)
+                }
+                next = make([]byte, size)
+                if _, err := r.r.ReadAt(next, int64(block)*r.blockSize+int64(offset)); err != nil {
+                    return fmt.Errorf("error reading SUSP continuation area: %w", err)
+                }
+            case "ST":
+                area = nil
+            }
+        }
+        area = next
+    }
+
+    if hasName {
+        rec.name = name.String()
+    }
+    if rec.symlink {
+        rec.target = target.String()
+    }
+    return nil
+}
+
+// appendLinkComponents decodes the component records of an "SL" entry. It
+// returns whether the next component needs a "/" separator, since a
+// component may continue in the following entry.
+func appendLinkComponents(target *strings.Builder, data []byte, separator bool) bool {
+    for len(data) >= 2 {
+        flags := data[0]
+        length := int(data[1])
+        if 2+length > len(data) {
+            break
+        }
+        content := data[2 : 2+length]
+        data = data[2+length:]
+
+        if separator {
+            target.WriteString("/")
+        }
+
+        switch {
+        case flags&linkRoot != 0:
+            target.WriteString("/")
+            separator = false
+            continue
+        case flags&linkCurrent != 0:
+            target.WriteString(".")
+        case flags&linkParent != 0:
+            target.WriteString("..")
+        default:
+            target.Write(content)
+        }
+        separator = flags&linkContinue == 0
+    }
+    return separator
+}
+
+func posixMode(mode uint32) fs.FileMode {
+    result := fs.FileMode(mode & 0777)
+    if mode&04000 != 0 {
+        result |= fs.ModeSetuid
+    }
+    if mode&02000 != 0 {
+        result |= fs.ModeSetgid
+    }
+    if mode&01000 != 0 {
+        result |= fs.ModeSticky
+    }
+
+    switch mode & posixTypeMask {
+    case posixDir:
+        result |= fs.ModeDir
+    case posixSymlink:
+        result |= fs.ModeSymlink
+    }
+    return result
+}
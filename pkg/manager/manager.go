@@ -1,19 +1,25 @@
 package manager
 
 import (
+    "errors"
     "fmt"
-    "log"
+    "io/fs"
     "os"
     "os/exec"
+    "os/user"
     "path/filepath"
     "strings"
 
+    "appinstaller/pkg/appimage"
     "appinstaller/pkg/desktop"
+    "appinstaller/pkg/fileutil"
+    "appinstaller/pkg/registry"
     "appinstaller/pkg/types"
 )
 
 type Manager struct {
-    config types.Config
+    config   types.Config
+    registry *registry.Registry
 }
 
 func New(config types.Config) *Manager {
@@ -32,7 +38,7 @@ func (m *Manager) IsGeneratedDesktop(deskFile *desktop.DesktopFile) (bool, error
         return isValid, err
     }
 
-    execPath, err := deskFile.Category("Desktop Entry").Get("Exec")
+    execPath, err := deskFile.Category("Desktop Entry").ExecProgram()
     if err != nil {
         return false, err
     }
@@ -49,18 +55,12 @@ func (m *Manager) IsValidDesktop(deskFile *desktop.DesktopFile) (bool, error) {
         return false, fmt.Errorf("missing basic values")
     }
 
-    cmd, err := deskFile.Category("Desktop Entry").Get("Exec")
+    path, err := deskFile.Category("Desktop Entry").ExecProgram()
     if err != nil {
-        return false, err
-    }
-
-    words := strings.Split(cmd, " ")
-    if len(words) == 0 {
-        return false, fmt.Errorf("missing executable path")
+        return false, fmt.Errorf("missing executable path: %w", err)
     }
 
-    path := words[0]
-    if _, err := os.Stat(path); err != nil {
+    if _, err := os.Stat(m.config.Path(path)); err != nil {
         if _, err := exec.LookPath(path); err != nil {
             return false, fmt.Errorf("executable not found: %s", path)
         }
@@ -69,23 +69,51 @@ func (m *Manager) IsValidDesktop(deskFile *desktop.DesktopFile) (bool, error) {
     return true, nil
 }
 
-func (m *Manager) List() []*desktop.DesktopFile {
-    var appList []*desktop.DesktopFile
+// Registry loads the installation registry. The first time it is used,
+// installs made before the registry existed are adopted into it.
+func (m *Manager) Registry() (*registry.Registry, error) {
+    if m.registry != nil {
+        return m.registry, nil
+    }
 
-    entries, err := os.ReadDir(m.config.GnomeDesktopDir)
+    reg, err := registry.Open(m.config.Path(m.config.RegistryPath))
     if err != nil {
-        log.Fatal(err)
+        return nil, err
+    }
+
+    if !reg.Exists() {
+        found := m.findUnregistered()
+        for _, entry := range found {
+            reg.Put(entry)
+        }
+        // Without the permission to write the registry, e.g. when a user
+        // lists system-wide installs, the adopted entries are used as is.
+        if len(found) > 0 {
+            if err := reg.Save(); err != nil && !errors.Is(err, fs.ErrPermission) {
+                return nil, err
+            }
+        }
+    }
+
+    m.registry = reg
+    return reg, nil
+}
+
+// findUnregistered finds installs by the pre-registry heuristic: desktop
+// entries whose Exec points into ExecDir.
+func (m *Manager) findUnregistered() []*registry.Entry {
+    var found []*registry.Entry
+
+    entries, err := os.ReadDir(m.config.Path(m.config.GnomeDesktopDir))
+    if err != nil {
+        return nil
     }
 
     for _, e := range entries {
         deskFile := desktop.New()
         deskFilePath := filepath.Join(m.config.GnomeDesktopDir, e.Name())
-        
-        if err := deskFile.FromFile(deskFilePath); err != nil {
-            continue
-        }
 
-        if _, err := m.IsValidDesktop(deskFile); err != nil {
+        if err := deskFile.FromFile(m.config.Path(deskFilePath)); err != nil {
             continue
         }
 
@@ -93,47 +121,246 @@ func (m *Manager) List() []*desktop.DesktopFile {
             continue
         }
 
-        appList = append(appList, deskFile)
+        name, _ := deskFile.Category("Desktop Entry").Get("Name")
+        version, _ := deskFile.Category("Desktop Entry").Get("X-AppImage-Version")
+        execPath, _ := deskFile.Category("Desktop Entry").ExecProgram()
+        sum, _ := fileutil.SHA256(m.config.Path(execPath))
+
+        entry := &registry.Entry{
+            ID:         strings.TrimSuffix(e.Name(), ".desktop"),
+            Name:       name,
+            Version:    version,
+            SourcePath: execPath,
+            SHA256:     sum,
+            Files: registry.Files{
+                AppImage: execPath,
+                Desktop:  deskFilePath,
+            },
+            Migrated: true,
+        }
+
+        if info, err := e.Info(); err == nil {
+            entry.InstalledAt = info.ModTime()
+        }
+        if app, err := appimage.Open(m.config.Path(execPath)); err == nil {
+            entry.UpdateInfo = app.UpdateInfo
+            app.Close()
+        }
+        if icon, err := deskFile.Category("Desktop Entry").Get("Icon"); err == nil && strings.HasPrefix(icon, m.config.ImgPath) {
+            entry.Files.Icons = []string{icon}
+        }
+        // Autostart entries used to be named after the display name.
+        autostartPath := filepath.Join(m.config.AutostartDir, strings.ToLower(strings.ReplaceAll(name, " ", "-"))+".desktop")
+        if _, err := os.Stat(m.config.Path(autostartPath)); err == nil {
+            entry.Files.Autostart = autostartPath
+        }
+
+        found = append(found, entry)
     }
 
-    return appList
+    return found
 }
 
-func (m *Manager) Delete(appName string) error {
-    entries, err := os.ReadDir(m.config.GnomeDesktopDir)
+// Register records entry. When it replaces an earlier install of the same
+// application, files of that install which are no longer used are removed.
+func (m *Manager) Register(entry *registry.Entry) error {
+    reg, err := m.Registry()
     if err != nil {
-        log.Fatal(err)
+        return err
     }
 
-    for _, e := range entries {
-        deskFile := desktop.New()
-        deskFilePath := filepath.Join(m.config.GnomeDesktopDir, e.Name())
-        
-        if err := deskFile.FromFile(deskFilePath); err != nil {
-            continue
+    if previous, ok := reg.Get(entry.ID); ok && previous != entry {
+        current := make(map[string]bool)
+        for _, path := range entry.Files.All() {
+            current[path] = true
         }
+        for _, path := range previous.Files.All() {
+            if !current[path] && len(reg.Owners(path, entry.ID)) == 0 {
+                os.Remove(m.config.Path(path))
+            }
+        }
+    }
 
-        if isGenerated, _ := m.IsGeneratedDesktop(deskFile); !isGenerated {
-            continue
+    reg.Put(entry)
+    return reg.Save()
+}
+
+func (m *Manager) List() ([]*registry.Entry, error) {
+    reg, err := m.Registry()
+    if err != nil {
+        return nil, err
+    }
+    return reg.Entries(), nil
+}
+
+// DisplayName returns the name of the application for the current locale.
+func (m *Manager) DisplayName(entry *registry.Entry) string {
+    deskFile := desktop.New()
+    if err := deskFile.FromFile(m.config.Path(entry.Files.Desktop)); err != nil {
+        return entry.Name
+    }
+
+    name, err := deskFile.Category("Desktop Entry").GetLocaleString("Name", desktop.CurrentLocale())
+    if err != nil {
+        return entry.Name
+    }
+    return name
+}
+
+// Find looks an application up by ID. As a convenience a name or localized
+// name is accepted too, as long as it is unambiguous.
+func (m *Manager) Find(appID string) (*registry.Entry, error) {
+    reg, err := m.Registry()
+    if err != nil {
+        return nil, err
+    }
+
+    if entry, ok := reg.Get(appID); ok {
+        return entry, nil
+    }
+
+    var matches []*registry.Entry
+    var ids []string
+    for _, entry := range reg.Entries() {
+        if entry.Name == appID || m.DisplayName(entry) == appID {
+            matches = append(matches, entry)
+            ids = append(ids, entry.ID)
         }
+    }
+
+    switch len(matches) {
+    case 0:
+        return nil, fmt.Errorf("application not found")
+    case 1:
+        return matches[0], nil
+    }
+    return nil, fmt.Errorf("%s matches several applications, use one of the IDs: %s", appID, strings.Join(ids, ", "))
+}
+
+func (m *Manager) IsAutostart(entry *registry.Entry) bool {
+    if entry.Files.Autostart == "" {
+        return false
+    }
+    _, err := os.Stat(m.config.Path(entry.Files.Autostart))
+    return err == nil
+}
+
+func (m *Manager) SetAutostart(entry *registry.Entry, enabled bool) error {
+    if !enabled {
+        if err := os.Remove(m.config.Path(entry.Files.Autostart)); err != nil && !os.IsNotExist(err) {
+            return err
+        }
+        entry.Files.Autostart = ""
+        return m.Register(entry)
+    }
+
+    deskFile := desktop.New()
+    if err := deskFile.FromFile(m.config.Path(entry.Files.Desktop)); err != nil {
+        return err
+    }
+    autostartPath, err := deskFile.CreateAutostart(m.config.Path(m.config.AutostartDir), entry.ID)
+    if err != nil {
+        return err
+    }
+
+    entry.Files.Autostart = filepath.Join(m.config.AutostartDir, filepath.Base(autostartPath))
+    return m.Register(entry)
+}
+
+// Removal summarizes what Delete did.
+type Removal struct {
+    Entry   *registry.Entry
+    Scope   string
+    Removed []string
+    Missing []string
+    // Shared maps files left in place to the applications still using them.
+    Shared map[string][]string
+}
 
-        name, err := deskFile.Category("Desktop Entry").Get("Name")
-        if err != nil || name != appName {
+// Delete uninstalls an application and every file recorded for it, except
+// files another installed application also uses. With purge, the invoking
+// user's configuration directories of the application, see ConfigDirs, are
+// removed as well.
+func (m *Manager) Delete(appID string, purge bool) (*Removal, error) {
+    entry, err := m.Find(appID)
+    if err != nil {
+        return nil, err
+    }
+
+    removal := &Removal{Entry: entry, Scope: m.config.Scope, Shared: make(map[string][]string)}
+    for _, path := range entry.Files.All() {
+        if owners := m.registry.Owners(path, entry.ID); len(owners) > 0 {
+            removal.Shared[path] = owners
             continue
         }
 
-        execPath, _ := deskFile.Category("Desktop Entry").Get("Exec")
-        
-        if err := os.Remove(execPath); err != nil {
-            return err
+        err := removePath(m.config.Path(path))
+        switch {
+        case err == nil:
+            removal.Removed = append(removal.Removed, path)
+        case os.IsNotExist(err):
+            removal.Missing = append(removal.Missing, path)
+        default:
+            return removal, err
         }
+    }
 
-        if err := os.Remove(deskFilePath); err != nil {
-            return err
+    if purge {
+        dirs, _ := m.ConfigDirs(entry)
+        for _, dir := range dirs {
+            if err := os.RemoveAll(dir); err != nil {
+                return removal, fmt.Errorf("error removing configuration: %w", err)
+            }
+            removal.Removed = append(removal.Removed, dir)
         }
+    }
 
-        return nil
+    m.registry.Remove(entry.ID)
+    return removal, m.registry.Save()
+}
+
+// removePath removes a recorded file, or a recorded directory with its
+// contents.
+func removePath(path string) error {
+    info, err := os.Lstat(path)
+    if err != nil {
+        return err
+    }
+    if info.IsDir() {
+        return os.RemoveAll(path)
+    }
+    return os.Remove(path)
+}
+
+// ConfigDirs returns the ~/.config directories of the invoking user that
+// belong to the application: the one named after its ID and those recorded in
+// the registry, split into those that exist and those that were checked but
+// not found. Directories merely named like the application are left alone,
+// they may belong to a packaged program of the same name.
+func (m *Manager) ConfigDirs(entry *registry.Entry) (found, missing []string) {
+    home, err := os.UserHomeDir()
+    if sudoUser := os.Getenv("SUDO_USER"); sudoUser != "" {
+        if u, lookupErr := user.Lookup(sudoUser); lookupErr == nil {
+            home, err = u.HomeDir, nil
+        }
+    }
+    if err != nil || home == "" {
+        return nil, nil
     }
 
-    return fmt.Errorf("application not found")
-} 
\ No newline at end of file
+    seen := make(map[string]bool)
+    for _, name := range append([]string{entry.ID}, entry.ConfigDirs...) {
+        if name == "" || strings.ContainsAny(name, "/") || name == "." || name == ".." || seen[name] {
+            continue
+        }
+        seen[name] = true
+
+        dir := m.config.Path(filepath.Join(home, ".config", name))
+        if info, err := os.Lstat(dir); err == nil && info.IsDir() {
+            found = append(found, dir)
+        } else {
+            missing = append(missing, dir)
+        }
+    }
+    return found, missing
+}
@@ -0,0 +1,267 @@
+package manager
+
+import (
+    "fmt"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "time"
+
+    "appinstaller/pkg/fileutil"
+    "appinstaller/pkg/registry"
+)
+
+const currentLink = "current"
+
+// AppDir is where the versions of an application are kept:
+// <ExecDir>/<id>/<version>/<id>.AppImage, plus a "current" symlink to the
+// active version directory.
+func (m *Manager) AppDir(id string) string {
+    return filepath.Join(m.config.ExecDir, id)
+}
+
+// CurrentPath is the stable path desktop entries execute.
+func (m *Manager) CurrentPath(id string) string {
+    return filepath.Join(m.AppDir(id), currentLink, id+".AppImage")
+}
+
+// AddVersion stores the AppImage at path as a new version of entry, makes it
+// current and prunes the oldest versions beyond keep. The versions of the
+// previous install are carried over; an AppImage installed before versions
+// were kept is adopted as one.
+func (m *Manager) AddVersion(entry *registry.Entry, path string, keep int) error {
+    reg, err := m.Registry()
+    if err != nil {
+        return err
+    }
+
+    var versions []registry.Version
+    if previous, ok := reg.Get(entry.ID); ok {
+        versions = append(versions, previous.Versions...)
+        if len(versions) == 0 {
+            if legacy, err := m.adoptLegacy(previous); err == nil {
+                versions = append(versions, *legacy)
+            }
+        }
+    }
+
+    version, err := m.storeVersion(entry.ID, entry.Version, entry.SHA256, path)
+    if err != nil {
+        return err
+    }
+    version.SourcePath = entry.SourcePath
+    version.UpdateInfo = entry.UpdateInfo
+
+    kept := versions[:0]
+    for _, v := range versions {
+        if v.Name != version.Name {
+            kept = append(kept, v)
+        }
+    }
+    versions = append(kept, *version)
+
+    if err := m.activate(entry.ID, version.Name); err != nil {
+        return err
+    }
+
+    entry.Current = version.Name
+    entry.Versions = m.prune(versions, version.Name, keep)
+    entry.Files.AppImage = m.CurrentPath(entry.ID)
+    entry.Files.Dir = m.AppDir(entry.ID)
+    return nil
+}
+
+// storeVersion copies the AppImage into its version directory. A version
+// with the same name but different content gets the start of its hash
+// appended.
+func (m *Manager) storeVersion(id, declared, sum, path string) (*registry.Version, error) {
+    name := versionName(declared, sum)
+
+    dir := m.config.Path(filepath.Join(m.AppDir(id), name))
+    if existing, err := fileutil.SHA256(filepath.Join(dir, id+".AppImage")); err == nil && existing != sum {
+        name += "-" + sum[:8]
+        dir = m.config.Path(filepath.Join(m.AppDir(id), name))
+    }
+    target := filepath.Join(dir, id+".AppImage")
+
+    if err := os.MkdirAll(dir, 0755); err != nil {
+        return nil, fmt.Errorf("error creating version directory: %w", err)
+    }
+
+    tmpPath := filepath.Join(dir, "."+id+".AppImage.new")
+    if err := fileutil.Copy(path, tmpPath); err != nil {
+        os.Remove(tmpPath)
+        return nil, err
+    }
+    if err := os.Chmod(tmpPath, 0755); err != nil {
+        os.Remove(tmpPath)
+        return nil, err
+    }
+    if err := os.Rename(tmpPath, target); err != nil {
+        os.Remove(tmpPath)
+        return nil, err
+    }
+
+    return &registry.Version{
+        Name:        name,
+        Version:     declared,
+        SHA256:      sum,
+        Path:        filepath.Join(m.AppDir(id), name, id+".AppImage"),
+        InstalledAt: time.Now().UTC(),
+    }, nil
+}
+
+// adoptLegacy moves an AppImage installed directly into ExecDir into the
+// version layout.
+func (m *Manager) adoptLegacy(previous *registry.Entry) (*registry.Version, error) {
+    path := previous.Files.AppImage
+    if path == "" || strings.HasPrefix(path, m.AppDir(previous.ID)+string(filepath.Separator)) {
+        return nil, fmt.Errorf("nothing to adopt")
+    }
+
+    info, err := os.Lstat(m.config.Path(path))
+    if err != nil || !info.Mode().IsRegular() {
+        return nil, fmt.Errorf("nothing to adopt")
+    }
+
+    sum := previous.SHA256
+    if sum == "" {
+        if sum, err = fileutil.SHA256(m.config.Path(path)); err != nil {
+            return nil, err
+        }
+    }
+
+    name := versionName(previous.Version, sum)
+    dir := filepath.Join(m.AppDir(previous.ID), name)
+    if err := os.MkdirAll(m.config.Path(dir), 0755); err != nil {
+        return nil, err
+    }
+    target := filepath.Join(dir, previous.ID+".AppImage")
+    if err := os.Rename(m.config.Path(path), m.config.Path(target)); err != nil {
+        return nil, err
+    }
+
+    return &registry.Version{
+        Name:        name,
+        Version:     previous.Version,
+        SHA256:      sum,
+        SourcePath:  previous.SourcePath,
+        Path:        target,
+        InstalledAt: previous.InstalledAt,
+        UpdateInfo:  previous.UpdateInfo,
+    }, nil
+}
+
+// activate points the current symlink at a version directory, replacing it
+// atomically.
+func (m *Manager) activate(id, name string) error {
+    link := m.config.Path(filepath.Join(m.AppDir(id), currentLink))
+    tmpLink := link + ".new"
+
+    os.Remove(tmpLink)
+    if err := os.Symlink(name, tmpLink); err != nil {
+        return fmt.Errorf("error activating version %s: %w", name, err)
+    }
+    if err := os.Rename(tmpLink, link); err != nil {
+        os.Remove(tmpLink)
+        return fmt.Errorf("error activating version %s: %w", name, err)
+    }
+    return nil
+}
+
+// prune removes the oldest versions so that at most keep remain, never the
+// current one.
+func (m *Manager) prune(versions []registry.Version, current string, keep int) []registry.Version {
+    if keep < 1 {
+        keep = 1
+    }
+
+    sort.SliceStable(versions, func(i, j int) bool {
+        return versions[i].InstalledAt.Before(versions[j].InstalledAt)
+    })
+
+    excess := len(versions) - keep
+    var kept []registry.Version
+    for _, v := range versions {
+        if excess > 0 && v.Name != current {
+            if err := os.RemoveAll(m.config.Path(filepath.Dir(v.Path))); err == nil {
+                fmt.Printf("Removed old version %s\n", v.Name)
+                excess--
+                continue
+            }
+        }
+        kept = append(kept, v)
+    }
+    return kept
+}
+
+// Rollback makes an earlier version current and restores what the registry
+// recorded about it. Without a name it goes back to the newest version
+// installed before the current one. The desktop integration is left to the
+// caller.
+func (m *Manager) Rollback(appID, name string) (*registry.Version, error) {
+    entry, err := m.Find(appID)
+    if err != nil {
+        return nil, err
+    }
+
+    var current *registry.Version
+    for i := range entry.Versions {
+        if entry.Versions[i].Name == entry.Current {
+            current = &entry.Versions[i]
+        }
+    }
+
+    var target *registry.Version
+    for i := range entry.Versions {
+        v := &entry.Versions[i]
+        switch {
+        case name != "":
+            if v.Name == name {
+                target = v
+            }
+        case current != nil && v.Name != current.Name && v.InstalledAt.Before(current.InstalledAt):
+            if target == nil || v.InstalledAt.After(target.InstalledAt) {
+                target = v
+            }
+        }
+    }
+
+    if target == nil {
+        if name != "" {
+            return nil, fmt.Errorf("%s has no version %s", entry.ID, name)
+        }
+        return nil, fmt.Errorf("%s has no earlier version to roll back to", entry.ID)
+    }
+    if _, err := os.Stat(m.config.Path(target.Path)); err != nil {
+        return nil, fmt.Errorf("version %s is missing: %w", target.Name, err)
+    }
+
+    if err := m.activate(entry.ID, target.Name); err != nil {
+        return nil, err
+    }
+
+    entry.Current = target.Name
+    entry.Version = target.Version
+    entry.SHA256 = target.SHA256
+    entry.SourcePath = target.SourcePath
+    entry.UpdateInfo = target.UpdateInfo
+    return target, m.Register(entry)
+}
+
+// versionName turns a declared version into a directory name, falling back
+// to the start of the AppImage's hash.
+func versionName(declared, sum string) string {
+    name := strings.Map(func(r rune) rune {
+        if r == '/' || r == '\\' || r <= ' ' {
+            return '-'
+        }
+        return r
+    }, strings.TrimSpace(declared))
+
+    if name == "" || name == "." || name == ".." || name == currentLink || strings.HasPrefix(name, ".") {
+        return "sha256-" + sum[:12]
+    }
+    return name
+}
@@ -0,0 +1,192 @@
+package registry
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io/fs"
+    "os"
+    "path/filepath"
+    "sort"
+    "time"
+)
+
+const formatVersion = 1
+
+type Files struct {
+    AppImage  string   `json:"appimage"`
+    Desktop   string   `json:"desktop"`
+    Icons     []string `json:"icons,omitempty"`
+    Autostart string   `json:"autostart,omitempty"`
+    // Dir holds the kept versions of the AppImage and the current symlink.
+    Dir string `json:"dir,omitempty"`
+}
+
+// All returns every recorded path.
+func (f Files) All() []string {
+    var paths []string
+    for _, path := range append(append([]string{f.AppImage, f.Desktop, f.Autostart}, f.Icons...), f.Dir) {
+        if path != "" {
+            paths = append(paths, path)
+        }
+    }
+    return paths
+}
+
+type Entry struct {
+    ID          string    `json:"id"`
+    Name        string    `json:"name"`
+    Version     string    `json:"version,omitempty"`
+    SourcePath  string    `json:"source_path"`
+    SHA256      string    `json:"sha256"`
+    Files       Files     `json:"files"`
+    InstalledAt time.Time `json:"installed_at"`
+    User        string    `json:"user,omitempty"`
+    UpdateInfo  string    `json:"update_info,omitempty"`
+    // ConfigDirs names the directories below ~/.config that belong to the
+    // application, the candidates for --purge besides its ID.
+    ConfigDirs  []string  `json:"config_dirs,omitempty"`
+    Current     string    `json:"current,omitempty"`
+    Versions    []Version `json:"versions,omitempty"`
+    // Migrated marks entries adopted from installs made before the registry
+    // existed; their source path and install time are best guesses.
+    Migrated bool `json:"migrated,omitempty"`
+}
+
+// Version is one kept copy of an application's AppImage.
+type Version struct {
+    Name        string    `json:"name"`
+    Version     string    `json:"version,omitempty"`
+    SHA256      string    `json:"sha256"`
+    SourcePath  string    `json:"source_path"`
+    Path        string    `json:"path"`
+    InstalledAt time.Time `json:"installed_at"`
+    UpdateInfo  string    `json:"update_info,omitempty"`
+}
+
+type file struct {
+    Version int      `json:"version"`
+    Apps    []*Entry `json:"apps"`
+}
+
+// Registry is the record of installed applications, stored as JSON.
+type Registry struct {
+    path    string
+    exists  bool
+    entries map[string]*Entry
+}
+
+// Open loads the registry at path. A missing file yields an empty registry
+// that is created on the first Save.
+func Open(path string) (*Registry, error) {
+    r := &Registry{path: path, entries: make(map[string]*Entry)}
+
+    data, err := os.ReadFile(path)
+    if errors.Is(err, fs.ErrNotExist) {
+        return r, nil
+    }
+    if err != nil {
+        return nil, fmt.Errorf("error reading registry: %w", err)
+    }
+
+    var content file
+    if err := json.Unmarshal(data, &content); err != nil {
+        return nil, fmt.Errorf("error parsing registry %s: %w", path, err)
+    }
+    if content.Version > formatVersion {
+        return nil, fmt.Errorf("registry %s has unsupported version %d", path, content.Version)
+    }
+
+    for _, entry := range content.Apps {
+        r.entries[entry.ID] = entry
+    }
+    r.exists = true
+    return r, nil
+}
+
+// Exists reports whether the registry was loaded from disk.
+func (r *Registry) Exists() bool {
+    return r.exists
+}
+
+func (r *Registry) Path() string {
+    return r.path
+}
+
+func (r *Registry) Get(id string) (*Entry, bool) {
+    entry, ok := r.entries[id]
+    return entry, ok
+}
+
+func (r *Registry) Put(entry *Entry) {
+    r.entries[entry.ID] = entry
+}
+
+func (r *Registry) Remove(id string) {
+    delete(r.entries, id)
+}
+
+// Owners returns the IDs of the entries that recorded path, except exclude.
+func (r *Registry) Owners(path, exclude string) []string {
+    var owners []string
+    for _, entry := range r.Entries() {
+        if entry.ID == exclude {
+            continue
+        }
+        for _, owned := range entry.Files.All() {
+            if owned == path {
+                owners = append(owners, entry.ID)
+                break
+            }
+        }
+    }
+    return owners
+}
+
+// Entries returns all entries sorted by ID.
+func (r *Registry) Entries() []*Entry {
+    entries := make([]*Entry, 0, len(r.entries))
+    for _, entry := range r.entries {
+        entries = append(entries, entry)
+    }
+    sort.Slice(entries, func(i, j int) bool {
+        return entries[i].ID < entries[j].ID
+    })
+    return entries
+}
+
+// Save writes the registry atomically.
+func (r *Registry) Save() error {
+    if err := os.MkdirAll(filepath.Dir(r.path), 0755); err != nil {
+        return fmt.Errorf("error creating registry directory: %w", err)
+    }
+
+    data, err := json.MarshalIndent(file{Version: formatVersion, Apps: r.Entries()}, "", "  ")
+    if err != nil {
+        return fmt.Errorf("error encoding registry: %w", err)
+    }
+
+    tmp, err := os.CreateTemp(filepath.Dir(r.path), ".registry-*")
+    if err != nil {
+        return fmt.Errorf("error writing registry: %w", err)
+    }
+    defer os.Remove(tmp.Name())
+
+    if _, err := tmp.Write(append(data, '\n')); err != nil {
+        tmp.Close()
+        return fmt.Errorf("error writing registry: %w", err)
+    }
+    if err := tmp.Chmod(0644); err != nil {
+        tmp.Close()
+        return fmt.Errorf("error writing registry: %w", err)
+    }
+    if err := tmp.Close(); err != nil {
+        return fmt.Errorf("error writing registry: %w", err)
+    }
+    if err := os.Rename(tmp.Name(), r.path); err != nil {
+        return fmt.Errorf("error writing registry: %w", err)
+    }
+
+    r.exists = true
+    return nil
+}
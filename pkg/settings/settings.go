@@ -0,0 +1,197 @@
+package settings
+
+import (
+    "fmt"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+
+    "github.com/BurntSushi/toml"
+
+    "appinstaller/pkg/types"
+    "appinstaller/pkg/xdg"
+)
+
+const SystemFile = "/etc/appinstaller/config.toml"
+
+// UserFile is the configuration file of the invoking user.
+func UserFile() string {
+    return filepath.Join(xdg.ConfigHome(), "appinstaller", "config.toml")
+}
+
+const DefaultSource = "default"
+
+// Setting is one adjustable value and where its effective value came from.
+type Setting struct {
+    Key    string
+    Env    string
+    Value  string
+    Source string
+
+    // Path settings of the system file only apply to system-wide installs.
+    path bool
+    set  func(c *types.Config, value any) error
+    get  func(c *types.Config) string
+}
+
+func newSettings() []*Setting {
+    return []*Setting{
+        pathSetting("exec_dir", func(c *types.Config) *string { return &c.ExecDir }),
+        pathSetting("desktop_dir", func(c *types.Config) *string { return &c.GnomeDesktopDir }),
+        pathSetting("icon_dir", func(c *types.Config) *string { return &c.ImgPath }),
+        pathSetting("icon_theme_dir", func(c *types.Config) *string { return &c.IconThemeDir }),
+        pathSetting("autostart_dir", func(c *types.Config) *string { return &c.AutostartDir }),
+        pathSetting("extract_dir", func(c *types.Config) *string { return &c.ExtractDir }),
+        boolSetting("autostart", func(c *types.Config) *bool { return &c.Autostart }),
+        {
+            Key: "keep_versions",
+            set: func(c *types.Config, value any) error {
+                n, err := toInt(value)
+                if err != nil || n < 1 {
+                    return fmt.Errorf("expected a number of at least 1")
+                }
+                c.KeepVersions = n
+                return nil
+            },
+            get: func(c *types.Config) string { return strconv.Itoa(c.KeepVersions) },
+        },
+        {
+            Key: "verify",
+            set: func(c *types.Config, value any) error {
+                policy, ok := value.(string)
+                if !ok || !validPolicy(policy) {
+                    return fmt.Errorf("expected %s", strings.Join(types.VerifyPolicies, ", "))
+                }
+                c.VerifyPolicy = policy
+                return nil
+            },
+            get: func(c *types.Config) string { return c.VerifyPolicy },
+        },
+        boolSetting("debug", func(c *types.Config) *bool { return &c.Debug }),
+    }
+}
+
+func pathSetting(key string, field func(c *types.Config) *string) *Setting {
+    return &Setting{
+        Key:  key,
+        path: true,
+        set: func(c *types.Config, value any) error {
+            path, ok := value.(string)
+            if !ok || !filepath.IsAbs(path) {
+                return fmt.Errorf("expected an absolute path")
+            }
+            *field(c) = path
+            return nil
+        },
+        get: func(c *types.Config) string { return *field(c) },
+    }
+}
+
+func boolSetting(key string, field func(c *types.Config) *bool) *Setting {
+    return &Setting{
+        Key: key,
+        set: func(c *types.Config, value any) error {
+            if s, ok := value.(string); ok {
+                b, err := strconv.ParseBool(s)
+                if err != nil {
+                    return fmt.Errorf("expected true or false")
+                }
+                value = b
+            }
+            b, ok := value.(bool)
+            if !ok {
+                return fmt.Errorf("expected true or false")
+            }
+            *field(c) = b
+            return nil
+        },
+        get: func(c *types.Config) string { return strconv.FormatBool(*field(c)) },
+    }
+}
+
+func toInt(value any) (int, error) {
+    switch v := value.(type) {
+    case int64:
+        return int(v), nil
+    case string:
+        return strconv.Atoi(v)
+    }
+    return 0, fmt.Errorf("not a number")
+}
+
+func validPolicy(policy string) bool {
+    for _, p := range types.VerifyPolicies {
+        if p == policy {
+            return true
+        }
+    }
+    return false
+}
+
+// Apply overrides the defaults in config with the system file, for user
+// installs the user file, and the APPINSTALLER_* environment variables, in
+// that order. It returns every setting with its effective value.
+func Apply(config *types.Config) ([]*Setting, error) {
+    settings := newSettings()
+    for _, s := range settings {
+        s.Env = "APPINSTALLER_" + strings.ToUpper(s.Key)
+        s.Source = DefaultSource
+    }
+
+    if err := applyFile(config, settings, config.Path(SystemFile), config.Scope == types.ScopeSystem); err != nil {
+        return nil, err
+    }
+    if config.Scope == types.ScopeUser {
+        if err := applyFile(config, settings, UserFile(), true); err != nil {
+            return nil, err
+        }
+    }
+
+    for _, s := range settings {
+        value, ok := os.LookupEnv(s.Env)
+        if !ok || value == "" {
+            continue
+        }
+        if err := s.set(config, value); err != nil {
+            return nil, fmt.Errorf("invalid %s: %w", s.Env, err)
+        }
+        s.Source = "env " + s.Env
+    }
+
+    for _, s := range settings {
+        s.Value = s.get(config)
+    }
+    return settings, nil
+}
+
+// applyFile reads one configuration file; a missing file is not an error.
+func applyFile(config *types.Config, settings []*Setting, path string, paths bool) error {
+    var values map[string]any
+    if _, err := toml.DecodeFile(path, &values); err != nil {
+        if os.IsNotExist(err) {
+            return nil
+        }
+        return fmt.Errorf("error reading %s: %w", path, err)
+    }
+
+    for key, value := range values {
+        var setting *Setting
+        for _, s := range settings {
+            if s.Key == key {
+                setting = s
+            }
+        }
+        if setting == nil {
+            return fmt.Errorf("%s: unknown setting %s", path, key)
+        }
+        if setting.path && !paths {
+            continue
+        }
+        if err := setting.set(config, value); err != nil {
+            return fmt.Errorf("%s: invalid %s: %w", path, key, err)
+        }
+        setting.Source = path
+    }
+    return nil
+}
@@ -0,0 +1,127 @@
+package squashfs
+
+import (
+    "bytes"
+    "compress/zlib"
+    "fmt"
+    "io"
+    "sync"
+
+    "github.com/klauspost/compress/zstd"
+    "github.com/pierrec/lz4/v4"
+    "github.com/ulikunitz/xz"
+    "github.com/ulikunitz/xz/lzma"
+)
+
+const (
+    CompressorGzip = 1
+    CompressorLzma = 2
+    CompressorLzo  = 3
+    CompressorXz   = 4
+    CompressorLz4  = 5
+    CompressorZstd = 6
+)
+
+var compressorNames = map[uint16]string{
+    CompressorGzip: "gzip",
+    CompressorLzma: "lzma",
+    CompressorLzo:  "lzo",
+    CompressorXz:   "xz",
+    CompressorLz4:  "lz4",
+    CompressorZstd: "zstd",
+}
+
+type decompressor func(src []byte, size int) ([]byte, error)
+
+func CompressorName(id uint16) string {
+    if name, ok := compressorNames[id]; ok {
+        return name
+    }
+    return fmt.Sprintf("unknown (%d)", id)
+}
+
+func newDecompressor(id uint16) (decompressor, error) {
+    switch id {
+    case CompressorGzip:
+        return decompressZlib, nil
+    case CompressorLzma:
+        return decompressLzma, nil
+    case CompressorLzo:
+        return decompressLzo, nil
+    case CompressorXz:
+        return decompressXz, nil
+    case CompressorLz4:
+        return decompressLz4, nil
+    case CompressorZstd:
+        return decompressZstd, nil
+    }
+    return nil, fmt.Errorf("unsupported compressor %d", id)
+}
+
+func decompressZlib(src []byte, size int) ([]byte, error) {
+    zr, err := zlib.NewReader(bytes.NewReader(src))
+    if err != nil {
+        return nil, err
+    }
+    defer zr.Close()
+
+    return readAtMost(zr, size)
+}
+
+func decompressLzma(src []byte, size int) ([]byte, error) {
+    lr, err := lzma.NewReader(bytes.NewReader(src))
+    if err != nil {
+        return nil, err
+    }
+    return readAtMost(lr, size)
+}
+
+func decompressXz(src []byte, size int) ([]byte, error) {
+    xr, err := xz.NewReader(bytes.NewReader(src))
+    if err != nil {
+        return nil, err
+    }
+    return readAtMost(xr, size)
+}
+
+func decompressLz4(src []byte, size int) ([]byte, error) {
+    dst := make([]byte, size)
+    n, err := lz4.UncompressBlock(src, dst)
+    if err != nil {
+        return nil, err
+    }
+    return dst[:n], nil
+}
+
+var zstdDecoder = sync.OnceValues(func() (*zstd.Decoder, error) {
+    return zstd.NewReader(nil, zstd.WithDecoderConcurrency(0))
+})
+
+func decompressZstd(src []byte, size int) ([]byte, error) {
+    decoder, err := zstdDecoder()
+    if err != nil {
+        return nil, err
+    }
+
+    dst, err := decoder.DecodeAll(src, make([]byte, 0, size))
+    if err != nil {
+        return nil, err
+    }
+
+    if len(dst) > size {
+        return nil, fmt.Errorf("decompressed block exceeds %d bytes", size)
+    }
+    return dst, nil
+}
+
+func readAtMost(r io.Reader, size int) ([]byte, error) {
+    buf := bytes.NewBuffer(make([]byte, 0, size))
+    if _, err := io.Copy(buf, io.LimitReader(r, int64(size)+1)); err != nil {
+        return nil, err
+    }
+
+    if buf.Len() > size {
+        return nil, fmt.Errorf("decompressed block exceeds %d bytes", size)
+    }
+    return buf.Bytes(), nil
+}
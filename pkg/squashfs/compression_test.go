@@ -0,0 +1,63 @@
+package squashfs
+
+import "testing"
+
+func TestCompressors(t *testing.T) {
+    for id, name := range compressorNames {
+        t.Run(name, func(t *testing.T) {
+            r := openImage(t, name+".sqfs")
+            if r.Compressor() != id {
+                t.Fatalf("image is compressed with %s", CompressorName(r.Compressor()))
+            }
+
+            // Each image has compressed metadata, compressed and stored data
+            // blocks and a compressed fragment.
+            in, err := r.lookup("stat", "multi.bin", true)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if in.blockSizes[0]&dataUncompressed != 0 {
+                t.Errorf("first block of multi.bin is not compressed")
+            }
+            checkFiles(t, r)
+            if err := walk(r); err != nil {
+                t.Error(err)
+            }
+        })
+    }
+}
+
+func TestUnsupportedCompressor(t *testing.T) {
+    if _, err := newDecompressor(7); err == nil {
+        t.Error("newDecompressor(7) succeeded")
+    }
+    if name := CompressorName(7); name != "unknown (7)" {
+        t.Errorf("CompressorName(7) = %q", name)
+    }
+}
+
+func TestDecompressedSizeLimit(t *testing.T) {
+    want := testFiles["multi.bin"][:4096]
+    for _, name := range compressorNames {
+        t.Run(name, func(t *testing.T) {
+            // A compressed block from the image, decompressed into a buffer
+            // one byte too small.
+            r := openImage(t, name+".sqfs")
+            in, err := r.lookup("stat", "multi.bin", true)
+            if err != nil {
+                t.Fatal(err)
+            }
+            block := make([]byte, in.blockSizes[0])
+            if _, err := r.r.ReadAt(block, int64(in.blocksStart)); err != nil {
+                t.Fatal(err)
+            }
+
+            if got, err := r.decompress(block, len(want)); err != nil || string(got) != want {
+                t.Fatalf("decompress() = %d bytes, %v", len(got), err)
+            }
+            if _, err := r.decompress(block, len(want)-1); err == nil {
+                t.Error("decompressing into a smaller buffer succeeded")
+            }
+        })
+    }
+}
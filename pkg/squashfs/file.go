@@ -0,0 +1,289 @@
+package squashfs
+
+import (
+    "fmt"
+    "io"
+    "io/fs"
+    "time"
+)
+
+type fileInfo struct {
+    name  string
+    inode *inode
+}
+
+func (fi *fileInfo) Name() string {
+    return fi.name
+}
+
+func (fi *fileInfo) Size() int64 {
+    switch {
+    case fi.inode.isRegular():
+        return int64(fi.inode.size)
+    case fi.inode.isSymlink():
+        return int64(len(fi.inode.target))
+    }
+    return 0
+}
+
+func (fi *fileInfo) Mode() fs.FileMode {
+    return fi.inode.mode()
+}
+
+func (fi *fileInfo) ModTime() time.Time {
+    return time.Unix(int64(fi.inode.ModTime), 0)
+}
+
+func (fi *fileInfo) IsDir() bool {
+    return fi.inode.isDir()
+}
+
+func (fi *fileInfo) Sys() any {
+    return nil
+}
+
+type dirEntry struct {
+    reader *Reader
+    entry  rawDirEntry
+}
+
+func (d *dirEntry) Name() string {
+    return d.entry.name
+}
+
+func (d *dirEntry) IsDir() bool {
+    return d.entry.typ == inodeDir || d.entry.typ == inodeExtDir
+}
+
+func (d *dirEntry) Type() fs.FileMode {
+    return (&inode{inodeHeader: inodeHeader{Type: d.entry.typ}}).mode().Type()
+}
+
+func (d *dirEntry) Info() (fs.FileInfo, error) {
+    in, err := d.reader.readInode(d.entry.ref)
+    if err != nil {
+        return nil, err
+    }
+    return &fileInfo{name: d.entry.name, inode: in}, nil
+}
+
+type dir struct {
+    reader  *Reader
+    info    *fileInfo
+    entries []rawDirEntry
+    pos     int
+}
+
+func (d *dir) Stat() (fs.FileInfo, error) {
+    return d.info, nil
+}
+
+func (d *dir) Read([]byte) (int, error) {
+    return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: fmt.Errorf("is a directory")}
+}
+
+func (d *dir) Close() error {
+    return nil
+}
+
+func (d *dir) ReadDir(n int) ([]fs.DirEntry, error) {
+    remaining := len(d.entries) - d.pos
+    if n > 0 && remaining == 0 {
+        return nil, io.EOF
+    }
+    if n > 0 && n < remaining {
+        remaining = n
+    }
+
+    result := make([]fs.DirEntry, remaining)
+    for i := range result {
+        result[i] = &dirEntry{reader: d.reader, entry: d.entries[d.pos+i]}
+    }
+    d.pos += remaining
+    return result, nil
+}
+
+type special struct {
+    info *fileInfo
+}
+
+func (s *special) Stat() (fs.FileInfo, error) {
+    return s.info, nil
+}
+
+func (s *special) Read([]byte) (int, error) {
+    return 0, &fs.PathError{Op: "read", Path: s.info.name, Err: fs.ErrInvalid}
+}
+
+func (s *special) Close() error {
+    return nil
+}
+
+type file struct {
+    reader *Reader
+    info   *fileInfo
+    inode  *inode
+    offset int64
+
+    blockOffsets []int64
+    blockIndex   int
+    block        []byte
+}
+
+func (f *file) Stat() (fs.FileInfo, error) {
+    return f.info, nil
+}
+
+func (f *file) Close() error {
+    f.block = nil
+    return nil
+}
+
+func (f *file) Read(p []byte) (int, error) {
+    n, err := f.ReadAt(p, f.offset)
+    f.offset += int64(n)
+    if err == io.EOF && n > 0 {
+        err = nil
+    }
+    return n, err
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+    switch whence {
+    case io.SeekStart:
+    case io.SeekCurrent:
+        offset += f.offset
+    case io.SeekEnd:
+        offset += int64(f.inode.size)
+    default:
+        return 0, fmt.Errorf("invalid whence %d", whence)
+    }
+
+    if offset < 0 {
+        return 0, fmt.Errorf("negative position")
+    }
+    f.offset = offset
+    return offset, nil
+}
+
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+    size := int64(f.inode.size)
+    if off >= size {
+        return 0, io.EOF
+    }
+
+    blockSize := int64(f.reader.sb.BlockSize)
+    n := 0
+    for n < len(p) && off < size {
+        data, err := f.readBlock(int(off / blockSize))
+        if err != nil {
+            return n, &fs.PathError{Op: "read", Path: f.info.name, Err: err}
+        }
+
+        copied := copy(p[n:], data[off%blockSize:])
+        n += copied
+        off += int64(copied)
+    }
+
+    if n < len(p) {
+        return n, io.EOF
+    }
+    return n, nil
+}
+
+func (f *file) readBlock(index int) ([]byte, error) {
+    if f.block != nil && f.blockIndex == index {
+        return f.block, nil
+    }
+
+    blockSize := int64(f.reader.sb.BlockSize)
+    length := int64(f.inode.size) - int64(index)*blockSize
+    if length > blockSize {
+        length = blockSize
+    }
+
+    var data []byte
+    var err error
+    if index < len(f.inode.blockSizes) {
+        data, err = f.readDataBlock(index, int(length))
+    } else {
+        data, err = f.reader.readFragment(f.inode, int(length))
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    if int64(len(data)) < length {
+        return nil, fmt.Errorf("short data block %d: got %d bytes, want %d", index, len(data), length)
+    }
+
+    f.block = data[:length]
+    f.blockIndex = index
+    return f.block, nil
+}
+
+func (f *file) readDataBlock(index int, length int) ([]byte, error) {
+    if f.blockOffsets == nil {
+        f.blockOffsets = make([]int64, len(f.inode.blockSizes))
+        pos := int64(f.inode.blocksStart)
+        for i, size := range f.inode.blockSizes {
+            f.blockOffsets[i] = pos
+            pos += int64(size &^ dataUncompressed)
+        }
+    }
+
+    size := f.inode.blockSizes[index]
+    if size == 0 {
+        return make([]byte, length), nil
+    }
+    return f.reader.readData(f.blockOffsets[index], size)
+}
+
+func (r *Reader) readData(pos int64, size uint32) ([]byte, error) {
+    compressed := size&dataUncompressed == 0
+    size &^= dataUncompressed
+    if size > r.sb.BlockSize {
+        return nil, fmt.Errorf("invalid data block size %d at %d", size, pos)
+    }
+
+    data := make([]byte, size)
+    if _, err := r.r.ReadAt(data, pos); err != nil {
+        return nil, fmt.Errorf("error reading data block at %d: %w", pos, err)
+    }
+
+    if !compressed {
+        return data, nil
+    }
+
+    data, err := r.decompress(data, int(r.sb.BlockSize))
+    if err != nil {
+        return nil, fmt.Errorf("error decompressing data block at %d: %w", pos, err)
+    }
+    return data, nil
+}
+
+func (r *Reader) readFragment(in *inode, length int) ([]byte, error) {
+    if in.fragmentIndex == noFragment || in.fragmentIndex >= r.sb.FragmentCount {
+        return nil, fmt.Errorf("invalid fragment index %d", in.fragmentIndex)
+    }
+
+    var entry struct {
+        Start  uint64
+        Size   uint32
+        Unused uint32
+    }
+    if err := r.readTableEntry(r.sb.FragTableStart, in.fragmentIndex, 16, &entry); err != nil {
+        return nil, fmt.Errorf("error reading fragment table: %w", err)
+    }
+
+    data, err := r.readData(int64(entry.Start), entry.Size)
+    if err != nil {
+        return nil, err
+    }
+
+    end := int(in.fragmentOffset) + length
+    if end > len(data) {
+        return nil, fmt.Errorf("fragment %d too short", in.fragmentIndex)
+    }
+    return data[in.fragmentOffset:end], nil
+}
@@ -0,0 +1,278 @@
+package squashfs
+
+import (
+    "encoding/binary"
+    "fmt"
+    "io"
+    "io/fs"
+)
+
+const (
+    inodeDir = iota + 1
+    inodeFile
+    inodeSymlink
+    inodeBlockDev
+    inodeCharDev
+    inodeFifo
+    inodeSocket
+    inodeExtDir
+    inodeExtFile
+    inodeExtSymlink
+    inodeExtBlockDev
+    inodeExtCharDev
+    inodeExtFifo
+    inodeExtSocket
+)
+
+type inodeHeader struct {
+    Type        uint16
+    Permissions uint16
+    UIDIndex    uint16
+    GIDIndex    uint16
+    ModTime     uint32
+    Number      uint32
+}
+
+type inode struct {
+    inodeHeader
+
+    dirStart  uint32
+    dirOffset uint16
+    dirSize   uint32
+
+    blocksStart    uint64
+    size           uint64
+    fragmentIndex  uint32
+    fragmentOffset uint32
+    blockSizes     []uint32
+
+    target string
+}
+
+func (in *inode) isDir() bool {
+    return in.Type == inodeDir || in.Type == inodeExtDir
+}
+
+func (in *inode) isRegular() bool {
+    return in.Type == inodeFile || in.Type == inodeExtFile
+}
+
+func (in *inode) isSymlink() bool {
+    return in.Type == inodeSymlink || in.Type == inodeExtSymlink
+}
+
+func (in *inode) mode() fs.FileMode {
+    mode := fs.FileMode(in.Permissions & 0777)
+    if in.Permissions&0o4000 != 0 {
+        mode |= fs.ModeSetuid
+    }
+    if in.Permissions&0o2000 != 0 {
+        mode |= fs.ModeSetgid
+    }
+    if in.Permissions&0o1000 != 0 {
+        mode |= fs.ModeSticky
+    }
+
+    switch in.Type {
+    case inodeDir, inodeExtDir:
+        mode |= fs.ModeDir
+    case inodeSymlink, inodeExtSymlink:
+        mode |= fs.ModeSymlink
+    case inodeBlockDev, inodeExtBlockDev:
+        mode |= fs.ModeDevice
+    case inodeCharDev, inodeExtCharDev:
+        mode |= fs.ModeDevice | fs.ModeCharDevice
+    case inodeFifo, inodeExtFifo:
+        mode |= fs.ModeNamedPipe
+    case inodeSocket, inodeExtSocket:
+        mode |= fs.ModeSocket
+    }
+    return mode
+}
+
+func (r *Reader) readInode(ref uint64) (*inode, error) {
+    mr, err := r.newMetadataReader(int64(r.sb.InodeTableStart+ref>>16), int(ref&0xFFFF))
+    if err != nil {
+        return nil, fmt.Errorf("error reading inode: %w", err)
+    }
+
+    in := &inode{}
+    if err := binary.Read(mr, binary.LittleEndian, &in.inodeHeader); err != nil {
+        return nil, fmt.Errorf("error reading inode header: %w", err)
+    }
+
+    switch in.Type {
+    case inodeDir:
+        var body struct {
+            BlockIndex  uint32
+            LinkCount   uint32
+            FileSize    uint16
+            BlockOffset uint16
+            ParentInode uint32
+        }
+        if err := binary.Read(mr, binary.LittleEndian, &body); err != nil {
+            return nil, fmt.Errorf("error reading directory inode: %w", err)
+        }
+        in.dirStart, in.dirOffset, in.dirSize = body.BlockIndex, body.BlockOffset, uint32(body.FileSize)
+    case inodeExtDir:
+        var body struct {
+            LinkCount   uint32
+            FileSize    uint32
+            BlockIndex  uint32
+            ParentInode uint32
+            IndexCount  uint16
+            BlockOffset uint16
+            XattrIndex  uint32
+        }
+        if err := binary.Read(mr, binary.LittleEndian, &body); err != nil {
+            return nil, fmt.Errorf("error reading directory inode: %w", err)
+        }
+        in.dirStart, in.dirOffset, in.dirSize = body.BlockIndex, body.BlockOffset, body.FileSize
+    case inodeFile:
+        var body struct {
+            BlocksStart    uint32
+            FragmentIndex  uint32
+            FragmentOffset uint32
+            FileSize       uint32
+        }
+        if err := binary.Read(mr, binary.LittleEndian, &body); err != nil {
+            return nil, fmt.Errorf("error reading file inode: %w", err)
+        }
+        in.blocksStart, in.size = uint64(body.BlocksStart), uint64(body.FileSize)
+        in.fragmentIndex, in.fragmentOffset = body.FragmentIndex, body.FragmentOffset
+        if err := r.readBlockSizes(mr, in); err != nil {
+            return nil, err
+        }
+    case inodeExtFile:
+        var body struct {
+            BlocksStart    uint64
+            FileSize       uint64
+            Sparse         uint64
+            LinkCount      uint32
+            FragmentIndex  uint32
+            FragmentOffset uint32
+            XattrIndex     uint32
+        }
+        if err := binary.Read(mr, binary.LittleEndian, &body); err != nil {
+            return nil, fmt.Errorf("error reading file inode: %w", err)
+        }
+        in.blocksStart, in.size = body.BlocksStart, body.FileSize
+        in.fragmentIndex, in.fragmentOffset = body.FragmentIndex, body.FragmentOffset
+        if err := r.readBlockSizes(mr, in); err != nil {
+            return nil, err
+        }
+    case inodeSymlink, inodeExtSymlink:
+        var body struct {
+            LinkCount  uint32
+            TargetSize uint32
+        }
+        if err := binary.Read(mr, binary.LittleEndian, &body); err != nil {
+            return nil, fmt.Errorf("error reading symlink inode: %w", err)
+        }
+        if body.TargetSize > 4096 {
+            return nil, fmt.Errorf("symlink target too long (%d bytes)", body.TargetSize)
+        }
+        target := make([]byte, body.TargetSize)
+        if _, err := io.ReadFull(mr, target); err != nil {
+            return nil, fmt.Errorf("error reading symlink target: %w", err)
+        }
+        in.target = string(target)
+    case inodeBlockDev, inodeCharDev, inodeFifo, inodeSocket,
+        inodeExtBlockDev, inodeExtCharDev, inodeExtFifo, inodeExtSocket:
+    default:
+        return nil, fmt.Errorf("unknown inode type %d", in.Type)
+    }
+
+    return in, nil
+}
+
+func (r *Reader) readBlockSizes(mr io.Reader, in *inode) error {
+    count := in.size / uint64(r.sb.BlockSize)
+    if in.fragmentIndex == noFragment && in.size%uint64(r.sb.BlockSize) != 0 {
+        count++
+    }
+
+    // Every block takes four bytes in the block list, which has to fit in
+    // the image, so the size of the inode cannot make us allocate more than
+    // the image holds.
+    if in.blocksStart > r.sb.BytesUsed || count > (r.sb.BytesUsed-in.blocksStart)/4 {
+        return fmt.Errorf("invalid block count %d for a file at %d", count, in.blocksStart)
+    }
+
+    in.blockSizes = make([]uint32, count)
+    if err := binary.Read(mr, binary.LittleEndian, in.blockSizes); err != nil {
+        return fmt.Errorf("error reading block list: %w", err)
+    }
+
+    // Sparse blocks take no space, the others must end within the image.
+    end := in.blocksStart
+    for _, size := range in.blockSizes {
+        end += uint64(size &^ dataUncompressed)
+    }
+    if end > r.sb.BytesUsed {
+        return fmt.Errorf("data blocks of a file at %d end past the image at %d", in.blocksStart, end)
+    }
+    return nil
+}
+
+type rawDirEntry struct {
+    name string
+    typ  uint16
+    ref  uint64
+}
+
+func (r *Reader) readDir(in *inode) ([]rawDirEntry, error) {
+    // The stored size counts the implicit "." and ".." entries.
+    if in.dirSize <= 3 {
+        return nil, nil
+    }
+
+    mr, err := r.newMetadataReader(int64(r.sb.DirTableStart)+int64(in.dirStart), int(in.dirOffset))
+    if err != nil {
+        return nil, fmt.Errorf("error reading directory: %w", err)
+    }
+
+    var entries []rawDirEntry
+    remaining := int64(in.dirSize) - 3
+    for remaining > 0 {
+        var header struct {
+            Count       uint32
+            Start       uint32
+            InodeNumber uint32
+        }
+        if err := binary.Read(mr, binary.LittleEndian, &header); err != nil {
+            return nil, fmt.Errorf("error reading directory header: %w", err)
+        }
+        remaining -= 12
+
+        if header.Count >= 256 {
+            return nil, fmt.Errorf("invalid directory header count %d", header.Count+1)
+        }
+
+        for i := uint32(0); i <= header.Count; i++ {
+            var entry struct {
+                Offset      uint16
+                InodeOffset int16
+                Type        uint16
+                NameSize    uint16
+            }
+            if err := binary.Read(mr, binary.LittleEndian, &entry); err != nil {
+                return nil, fmt.Errorf("error reading directory entry: %w", err)
+            }
+
+            name := make([]byte, int(entry.NameSize)+1)
+            if _, err := io.ReadFull(mr, name); err != nil {
+                return nil, fmt.Errorf("error reading directory entry name: %w", err)
+            }
+            remaining -= 8 + int64(len(name))
+
+            entries = append(entries, rawDirEntry{
+                name: string(name),
+                typ:  entry.Type,
+                ref:  uint64(header.Start)<<16 | uint64(entry.Offset),
+            })
+        }
+    }
+
+    return entries, nil
+}
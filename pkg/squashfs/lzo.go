@@ -0,0 +1,232 @@
+package squashfs
+
+import (
+    "errors"
+)
+
+var (
+    errLzoInputOverrun  = errors.New("lzo: input overrun")
+    errLzoOutputOverrun = errors.New("lzo: output overrun")
+    errLzoLookBehind    = errors.New("lzo: look-behind overrun")
+    errLzoTrailingInput = errors.New("lzo: trailing input")
+)
+
+const (
+    lzoStateInstruction = iota
+    lzoStateFirstLiteral
+    lzoStateMatch
+    lzoStateMatchDone
+)
+
+type lzoDecoder struct {
+    src  []byte
+    dst  []byte
+    ip   int
+    size int
+}
+
+// decompressLzo implements LZO1X decompression as done by
+// lzo1x_decompress_safe, which is what mksquashfs -comp lzo produces.
+func decompressLzo(src []byte, size int) ([]byte, error) {
+    d := &lzoDecoder{src: src, dst: make([]byte, 0, size), size: size}
+    if len(src) < 3 {
+        return nil, errLzoInputOverrun
+    }
+
+    state := lzoStateInstruction
+    var t int
+
+    if src[0] > 17 {
+        t = int(src[0]) - 17
+        d.ip = 1
+        if err := d.literals(t); err != nil {
+            return nil, err
+        }
+        state = lzoStateFirstLiteral
+        if t < 4 {
+            next, err := d.byte()
+            if err != nil {
+                return nil, err
+            }
+            state, t = lzoStateMatch, next
+        }
+    }
+
+    return d.run(state, t)
+}
+
+func (d *lzoDecoder) run(state, t int) ([]byte, error) {
+    var err error
+    for {
+        switch state {
+        case lzoStateInstruction:
+            if t, err = d.byte(); err != nil {
+                return nil, err
+            }
+            if t >= 16 {
+                state = lzoStateMatch
+                continue
+            }
+            if t == 0 {
+                if t, err = d.length(15); err != nil {
+                    return nil, err
+                }
+            }
+            if err = d.literals(t + 3); err != nil {
+                return nil, err
+            }
+            state = lzoStateFirstLiteral
+        case lzoStateFirstLiteral:
+            if t, err = d.byte(); err != nil {
+                return nil, err
+            }
+            if t >= 16 {
+                state = lzoStateMatch
+                continue
+            }
+            next, err := d.byte()
+            if err != nil {
+                return nil, err
+            }
+            if err = d.match(1+0x0800+(t>>2)+(next<<2), 3); err != nil {
+                return nil, err
+            }
+            state = lzoStateMatchDone
+        case lzoStateMatch:
+            done, err := d.instruction(t)
+            if err != nil {
+                return nil, err
+            }
+            if done {
+                if d.ip != len(d.src) {
+                    return nil, errLzoTrailingInput
+                }
+                return d.dst, nil
+            }
+            state = lzoStateMatchDone
+        case lzoStateMatchDone:
+            t = int(d.src[d.ip-2] & 3)
+            if t == 0 {
+                state = lzoStateInstruction
+                continue
+            }
+            if err = d.literals(t); err != nil {
+                return nil, err
+            }
+            if t, err = d.byte(); err != nil {
+                return nil, err
+            }
+            state = lzoStateMatch
+        }
+    }
+}
+
+func (d *lzoDecoder) instruction(t int) (bool, error) {
+    switch {
+    case t >= 64:
+        next, err := d.byte()
+        if err != nil {
+            return false, err
+        }
+        return false, d.match(1+((t>>2)&7)+(next<<3), (t>>5)+1)
+    case t >= 32:
+        length := t & 31
+        if length == 0 {
+            var err error
+            if length, err = d.length(31); err != nil {
+                return false, err
+            }
+        }
+        distance, err := d.distance()
+        if err != nil {
+            return false, err
+        }
+        return false, d.match(1+distance, length+2)
+    case t >= 16:
+        high := (t & 8) << 11
+        length := t & 7
+        if length == 0 {
+            var err error
+            if length, err = d.length(7); err != nil {
+                return false, err
+            }
+        }
+        distance, err := d.distance()
+        if err != nil {
+            return false, err
+        }
+        distance += high
+        if distance == 0 {
+            return true, nil
+        }
+        return false, d.match(distance+0x4000, length+2)
+    }
+
+    next, err := d.byte()
+    if err != nil {
+        return false, err
+    }
+    return false, d.match(1+(t>>2)+(next<<2), 2)
+}
+
+func (d *lzoDecoder) byte() (int, error) {
+    if d.ip >= len(d.src) {
+        return 0, errLzoInputOverrun
+    }
+    b := d.src[d.ip]
+    d.ip++
+    return int(b), nil
+}
+
+func (d *lzoDecoder) length(base int) (int, error) {
+    t := 0
+    for {
+        b, err := d.byte()
+        if err != nil {
+            return 0, err
+        }
+        if b != 0 {
+            return t + base + b, nil
+        }
+        t += 255
+        if t > d.size {
+            return 0, errLzoOutputOverrun
+        }
+    }
+}
+
+func (d *lzoDecoder) distance() (int, error) {
+    if d.ip+2 > len(d.src) {
+        return 0, errLzoInputOverrun
+    }
+    distance := (int(d.src[d.ip]) | int(d.src[d.ip+1])<<8) >> 2
+    d.ip += 2
+    return distance, nil
+}
+
+func (d *lzoDecoder) literals(n int) error {
+    if d.ip+n > len(d.src) {
+        return errLzoInputOverrun
+    }
+    if len(d.dst)+n > d.size {
+        return errLzoOutputOverrun
+    }
+    d.dst = append(d.dst, d.src[d.ip:d.ip+n]...)
+    d.ip += n
+    return nil
+}
+
+func (d *lzoDecoder) match(distance, n int) error {
+    if distance > len(d.dst) {
+        return errLzoLookBehind
+    }
+    if len(d.dst)+n > d.size {
+        return errLzoOutputOverrun
+    }
+
+    pos := len(d.dst) - distance
+    for i := 0; i < n; i++ {
+        d.dst = append(d.dst, d.dst[pos+i])
+    }
+    return nil
+}
@@ -0,0 +1,136 @@
+package squashfs
+
+import (
+    "bytes"
+    "encoding/hex"
+    "errors"
+    "math/rand"
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+func randomData(seed int64, n int) []byte {
+    data := make([]byte, n)
+    rand.New(rand.NewSource(seed)).Read(data)
+    return data
+}
+
+// lzoVectors are the output of the reference lzo1x_1 and lzo1x_999
+// compressors. The larger ones are in testdata/lzo.
+var lzoVectors = []struct {
+    name       string
+    data       []byte
+    compressed string
+}{
+    {"hello lzo1x_1", []byte("Hello, World! Hello, World!\n"),
+        "2d48656c6c6f2c20576f726c64212048656c6c6f2c20576f726c64210a110000"},
+    {"hello lzo1x_999", []byte("Hello, World! Hello, World!\n"),
+        "1f48656c6c6f2c20576f726c6421202b35000a110000"},
+    {"repeated lzo1x_1", bytes.Repeat([]byte("ABCD"), 1000),
+        "05414243444142434420000000000000000000000000000000860c00110000"},
+    {"repeated lzo1x_999", bytes.Repeat([]byte("ABCD"), 1000),
+        "15414243442000000000000000e60c002000000000000000820c00110000"},
+    {"zeros lzo1x_1", make([]byte, 4096),
+        "02000000000020000000000000000000000000000000e90000110000"},
+    {"zeros lzo1x_999", make([]byte, 4096),
+        "12002000000000000000e600002000000000000000e50000110000"},
+    {"text lzo1x_1", bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog. Pack my box with five dozen liquor jugs.\n"), 20),
+        "004954686520717569636b2062726f776e20666f78206a756d7073206f76657220746865206c617a7920646f672e205061636b206d7920626f782077697468206669766520646f7a656e206c6971756f72206a7567732e0a546865207120000000000000425401110000"},
+    {"text lzo1x_999", bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog. Pack my box with five dozen liquor jugs.\n"), 20),
+        "3154686520717569636b2062726f776e20666f78206a756d7073206f76657220745803096c617a7920646f672e2050615d046d010362520477690f0620666900094a037a65020e6c690a116f724c070167732e0a20000000000000475401110000"},
+}
+
+// lzoFiles are the vectors in testdata/lzo: incompressible data, which is
+// one long literal run, and data repeating after more than 16 KiB, which
+// needs the far matches.
+func lzoFiles() map[string][]byte {
+    far := randomData(1, 4096)
+    far = append(far, randomData(2, 20000)...)
+    far = append(far, far[:4096]...)
+
+    return map[string][]byte{
+        "random.lzo": randomData(3, 1000),
+        "far.lzo":    far,
+    }
+}
+
+func readLzoVector(t *testing.T, name string) []byte {
+    t.Helper()
+    data, err := os.ReadFile(filepath.Join("testdata", "lzo", name))
+    if err != nil {
+        t.Fatal(err)
+    }
+    return data
+}
+
+func TestDecompressLzo(t *testing.T) {
+    for _, v := range lzoVectors {
+        t.Run(v.name, func(t *testing.T) {
+            src, _ := hex.DecodeString(v.compressed)
+            got, err := decompressLzo(src, len(v.data))
+            if err != nil {
+                t.Fatal(err)
+            }
+            if !bytes.Equal(got, v.data) {
+                t.Errorf("decompressed %d bytes, want %d", len(got), len(v.data))
+            }
+        })
+    }
+
+    for name, want := range lzoFiles() {
+        t.Run(name, func(t *testing.T) {
+            // A larger buffer, as for squashfs blocks, is fine too.
+            got, err := decompressLzo(readLzoVector(t, name), len(want)+100)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if !bytes.Equal(got, want) {
+                t.Errorf("decompressed %d bytes, want %d", len(got), len(want))
+            }
+        })
+    }
+}
+
+func TestDecompressLzoErrors(t *testing.T) {
+    hello, _ := hex.DecodeString(lzoVectors[1].compressed)
+    zeros, _ := hex.DecodeString(lzoVectors[5].compressed)
+
+    tests := []struct {
+        name string
+        src  []byte
+        size int
+        err  error
+    }{
+        {"too short", []byte{0x11, 0x00}, 10, errLzoInputOverrun},
+        {"trailing input", append(bytes.Clone(hello), 0), 28, errLzoTrailingInput},
+        {"output overrun in a match", zeros, 100, errLzoOutputOverrun},
+        {"output overrun in literals", hello, 10, errLzoOutputOverrun},
+        // One literal, then a match 65 bytes back.
+        {"match before the start", []byte{0x12, 'A', 0x40, 0x08, 0x11, 0x00, 0x00}, 100, errLzoLookBehind},
+        {"missing end of stream", []byte{0x12, 'A', 0x00, 0x00}, 100, errLzoInputOverrun},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if _, err := decompressLzo(tt.src, tt.size); !errors.Is(err, tt.err) {
+                t.Errorf("decompressLzo() = %v, want %v", err, tt.err)
+            }
+        })
+    }
+}
+
+func TestDecompressLzoTruncated(t *testing.T) {
+    streams := [][]byte{readLzoVector(t, "far.lzo")}
+    for _, v := range lzoVectors {
+        src, _ := hex.DecodeString(v.compressed)
+        streams = append(streams, src)
+    }
+
+    for _, src := range streams {
+        for n := 0; n < len(src); n++ {
+            if _, err := decompressLzo(src[:n], 1<<16); err == nil {
+                t.Fatalf("stream truncated to %d of %d bytes decompressed", n, len(src))
+            }
+        }
+    }
+}
@@ -0,0 +1,361 @@
+package squashfs
+
+import (
+    "encoding/binary"
+    "fmt"
+    "io"
+    "io/fs"
+    "path"
+    "strings"
+    "sync"
+)
+
+const (
+    magic = 0x73717368
+
+    metadataSize         = 8192
+    metadataUncompressed = 1 << 15
+    dataUncompressed     = 1 << 24
+    noFragment           = 0xFFFFFFFF
+    maxSymlinkHops       = 40
+)
+
+type superblock struct {
+    Magic             uint32
+    InodeCount        uint32
+    ModTime           uint32
+    BlockSize         uint32
+    FragmentCount     uint32
+    Compressor        uint16
+    BlockLog          uint16
+    Flags             uint16
+    IDCount           uint16
+    VersionMajor      uint16
+    VersionMinor      uint16
+    RootInode         uint64
+    BytesUsed         uint64
+    IDTableStart      uint64
+    XattrIDTableStart uint64
+    InodeTableStart   uint64
+    DirTableStart     uint64
+    FragTableStart    uint64
+    ExportTableStart  uint64
+}
+
+type metadataBlock struct {
+    data []byte
+    next int64
+}
+
+type Reader struct {
+    r          io.ReaderAt
+    sb         superblock
+    decompress decompressor
+
+    mu    sync.Mutex
+    cache map[int64]metadataBlock
+}
+
+func NewReader(r io.ReaderAt) (*Reader, error) {
+    var sb superblock
+    if err := binary.Read(io.NewSectionReader(r, 0, int64(binary.Size(sb))), binary.LittleEndian, &sb); err != nil {
+        return nil, fmt.Errorf("error reading squashfs superblock: %w", err)
+    }
+
+    if sb.Magic != magic {
+        return nil, fmt.Errorf("not a squashfs filesystem (bad magic %#x)", sb.Magic)
+    }
+
+    if sb.VersionMajor != 4 {
+        return nil, fmt.Errorf("unsupported squashfs version %d.%d", sb.VersionMajor, sb.VersionMinor)
+    }
+
+    if sb.BlockSize == 0 || sb.BlockSize != 1<<sb.BlockLog {
+        return nil, fmt.Errorf("invalid squashfs block size %d", sb.BlockSize)
+    }
+
+    decompress, err := newDecompressor(sb.Compressor)
+    if err != nil {
+        return nil, err
+    }
+
+    return &Reader{
+        r:          r,
+        sb:         sb,
+        decompress: decompress,
+        cache:      make(map[int64]metadataBlock),
+    }, nil
+}
+
+func (r *Reader) BlockSize() uint32 {
+    return r.sb.BlockSize
+}
+
+func (r *Reader) Compressor() uint16 {
+    return r.sb.Compressor
+}
+
+func (r *Reader) Open(name string) (fs.File, error) {
+    in, err := r.lookup("open", name, true)
+    if err != nil {
+        return nil, err
+    }
+
+    info := &fileInfo{name: path.Base(name), inode: in}
+    switch {
+    case in.isDir():
+        entries, err := r.readDir(in)
+        if err != nil {
+            return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+        }
+        return &dir{reader: r, info: info, entries: entries}, nil
+    case in.isRegular():
+        return &file{reader: r, info: info, inode: in}, nil
+    }
+    return &special{info: info}, nil
+}
+
+func (r *Reader) Stat(name string) (fs.FileInfo, error) {
+    in, err := r.lookup("stat", name, true)
+    if err != nil {
+        return nil, err
+    }
+    return &fileInfo{name: path.Base(name), inode: in}, nil
+}
+
+func (r *Reader) Lstat(name string) (fs.FileInfo, error) {
+    in, err := r.lookup("lstat", name, false)
+    if err != nil {
+        return nil, err
+    }
+    return &fileInfo{name: path.Base(name), inode: in}, nil
+}
+
+func (r *Reader) ReadLink(name string) (string, error) {
+    in, err := r.lookup("readlink", name, false)
+    if err != nil {
+        return "", err
+    }
+
+    if !in.isSymlink() {
+        return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+    }
+    return in.target, nil
+}
+
+func (r *Reader) ReadDir(name string) ([]fs.DirEntry, error) {
+    in, err := r.lookup("readdir", name, true)
+    if err != nil {
+        return nil, err
+    }
+
+    if !in.isDir() {
+        return nil, &fs.PathError{Op: "readdir", Path: name, Err: fmt.Errorf("not a directory")}
+    }
+
+    entries, err := r.readDir(in)
+    if err != nil {
+        return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+    }
+
+    result := make([]fs.DirEntry, len(entries))
+    for i := range entries {
+        result[i] = &dirEntry{reader: r, entry: entries[i]}
+    }
+    return result, nil
+}
+
+// lookup resolves name from the root inode. Symlinks are followed relative to
+// the image root, so a target can never point outside the filesystem.
+func (r *Reader) lookup(op, name string, follow bool) (*inode, error) {
+    if !fs.ValidPath(name) {
+        return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+    }
+
+    pending := splitPath(name)
+    var resolved []string
+    hops := 0
+
+    current, err := r.readInode(r.sb.RootInode)
+    if err != nil {
+        return nil, &fs.PathError{Op: op, Path: name, Err: err}
+    }
+
+    for len(pending) > 0 {
+        component := pending[0]
+        pending = pending[1:]
+
+        if !current.isDir() {
+            return nil, &fs.PathError{Op: op, Path: name, Err: fmt.Errorf("not a directory")}
+        }
+
+        entries, err := r.readDir(current)
+        if err != nil {
+            return nil, &fs.PathError{Op: op, Path: name, Err: err}
+        }
+
+        var next *inode
+        for _, e := range entries {
+            if e.name == component {
+                next, err = r.readInode(e.ref)
+                if err != nil {
+                    return nil, &fs.PathError{Op: op, Path: name, Err: err}
+                }
+                break
+            }
+        }
+        if next == nil {
+            return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+        }
+
+        if next.isSymlink() && (follow || len(pending) > 0) {
+            hops++
+            if hops > maxSymlinkHops {
+                return nil, &fs.PathError{Op: op, Path: name, Err: fmt.Errorf("too many levels of symbolic links")}
+            }
+
+            target := next.target
+            if !strings.HasPrefix(target, "/") {
+                target = path.Join(append(resolved, target)...)
+            }
+            pending = append(splitPath(path.Clean("/"+target)), pending...)
+            resolved = nil
+
+            current, err = r.readInode(r.sb.RootInode)
+            if err != nil {
+                return nil, &fs.PathError{Op: op, Path: name, Err: err}
+            }
+            continue
+        }
+
+        resolved = append(resolved, component)
+        current = next
+    }
+
+    return current, nil
+}
+
+func splitPath(name string) []string {
+    var parts []string
+    for _, part := range strings.Split(name, "/") {
+        if part != "" && part != "." {
+            parts = append(parts, part)
+        }
+    }
+
+    cleaned := parts[:0]
+    for _, part := range parts {
+        if part == ".." {
+            if len(cleaned) > 0 {
+                cleaned = cleaned[:len(cleaned)-1]
+            }
+            continue
+        }
+        cleaned = append(cleaned, part)
+    }
+    return cleaned
+}
+
+func (r *Reader) readMetadataBlock(pos int64) (metadataBlock, error) {
+    r.mu.Lock()
+    block, ok := r.cache[pos]
+    r.mu.Unlock()
+    if ok {
+        return block, nil
+    }
+
+    header := make([]byte, 2)
+    if _, err := r.r.ReadAt(header, pos); err != nil {
+        return block, fmt.Errorf("error reading metadata header at %d: %w", pos, err)
+    }
+
+    size := binary.LittleEndian.Uint16(header)
+    compressed := size&metadataUncompressed == 0
+    size &^= metadataUncompressed
+    if size == 0 || size > metadataSize {
+        return block, fmt.Errorf("invalid metadata block size %d at %d", size, pos)
+    }
+
+    data := make([]byte, size)
+    if _, err := r.r.ReadAt(data, pos+2); err != nil {
+        return block, fmt.Errorf("error reading metadata block at %d: %w", pos, err)
+    }
+
+    if compressed {
+        var err error
+        data, err = r.decompress(data, metadataSize)
+        if err != nil {
+            return block, fmt.Errorf("error decompressing metadata block at %d: %w", pos, err)
+        }
+    }
+
+    block = metadataBlock{data: data, next: pos + 2 + int64(size)}
+    r.mu.Lock()
+    r.cache[pos] = block
+    r.mu.Unlock()
+    return block, nil
+}
+
+type metadataReader struct {
+    reader *Reader
+    next   int64
+    buf    []byte
+}
+
+func (r *Reader) newMetadataReader(start int64, offset int) (*metadataReader, error) {
+    block, err := r.readMetadataBlock(start)
+    if err != nil {
+        return nil, err
+    }
+
+    if offset > len(block.data) {
+        return nil, fmt.Errorf("metadata offset %d out of range", offset)
+    }
+
+    return &metadataReader{reader: r, next: block.next, buf: block.data[offset:]}, nil
+}
+
+func (m *metadataReader) Read(p []byte) (int, error) {
+    if len(m.buf) == 0 {
+        block, err := m.reader.readMetadataBlock(m.next)
+        if err != nil {
+            return 0, err
+        }
+        m.buf = block.data
+        m.next = block.next
+    }
+
+    n := copy(p, m.buf)
+    m.buf = m.buf[n:]
+    return n, nil
+}
+
+// readTableEntry reads entry i of an indexed table such as the id or fragment
+// table, where tableStart points at the list of metadata block locations.
+func (r *Reader) readTableEntry(tableStart uint64, i uint32, entrySize int, data any) error {
+    pos := int(i) * entrySize
+    block := pos / metadataSize
+
+    location := make([]byte, 8)
+    if _, err := r.r.ReadAt(location, int64(tableStart)+int64(block)*8); err != nil {
+        return fmt.Errorf("error reading table index: %w", err)
+    }
+
+    mr, err := r.newMetadataReader(int64(binary.LittleEndian.Uint64(location)), pos%metadataSize)
+    if err != nil {
+        return err
+    }
+    return binary.Read(mr, binary.LittleEndian, data)
+}
+
+func (r *Reader) readID(index uint16) (uint32, error) {
+    if index >= r.sb.IDCount {
+        return 0, fmt.Errorf("id index %d out of range", index)
+    }
+
+    var id uint32
+    if err := r.readTableEntry(r.sb.IDTableStart, uint32(index), 4, &id); err != nil {
+        return 0, fmt.Errorf("error reading id table: %w", err)
+    }
+    return id, nil
+}
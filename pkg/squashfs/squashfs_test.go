@@ -0,0 +1,317 @@
+package squashfs
+
+import (
+    "bytes"
+    "encoding/binary"
+    "errors"
+    "io"
+    "io/fs"
+    "math/rand"
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+// The images in testdata have 4 KiB blocks and hold the same tree, written
+// with each compressor:
+//
+//  hello.txt, AppRun, empty, etc/passwd  small files, in the fragment
+//  multi.bin                             three full blocks and a fragment tail
+//  dir/nested.txt                        mode 0600
+//  link -> hello.txt
+//  dir/up -> ../hello.txt
+//  absolute -> /dir/nested.txt
+//  dir/escape -> ../../../../etc/passwd
+//  dirlink -> dir
+//  loop -> loop
+
+// multiBin is the content of multi.bin: a block of text, one of random
+// bytes, one of zeros and a tail.
+func multiBin() []byte {
+    var b bytes.Buffer
+    for b.Len() < 4096 {
+        b.WriteString("The quick brown fox jumps over the lazy dog.\n")
+    }
+    b.Truncate(4096)
+    random := make([]byte, 4096)
+    rand.New(rand.NewSource(1)).Read(random)
+    b.Write(random)
+    b.Write(make([]byte, 4096))
+    b.WriteString("tail of the file stored in a fragment\n")
+    return b.Bytes()
+}
+
+var testFiles = map[string]string{
+    "hello.txt":      "Hello, squashfs!\n",
+    "AppRun":         "#!/bin/sh\n",
+    "empty":          "",
+    "etc/passwd":     "root:x:0:0:image:/root:/bin/sh\n",
+    "dir/nested.txt": "nested\n",
+    "multi.bin":      string(multiBin()),
+}
+
+func openImage(t *testing.T, name string) *Reader {
+    t.Helper()
+    f, err := os.Open(filepath.Join("testdata", name))
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { f.Close() })
+
+    r, err := NewReader(f)
+    if err != nil {
+        t.Fatalf("NewReader(%s): %v", name, err)
+    }
+    return r
+}
+
+// checkFiles reads every regular file of the test tree.
+func checkFiles(t *testing.T, r *Reader) {
+    t.Helper()
+    for name, want := range testFiles {
+        got, err := fs.ReadFile(r, name)
+        if err != nil {
+            t.Errorf("ReadFile(%s): %v", name, err)
+            continue
+        }
+        if string(got) != want {
+            t.Errorf("ReadFile(%s) = %d bytes, want %d", name, len(got), len(want))
+        }
+    }
+}
+
+func TestReadFiles(t *testing.T) {
+    checkFiles(t, openImage(t, "gzip.sqfs"))
+}
+
+func TestMultiBlockFile(t *testing.T) {
+    r := openImage(t, "gzip.sqfs")
+    want := multiBin()
+
+    in, err := r.lookup("stat", "multi.bin", true)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(in.blockSizes) != 3 || in.fragmentIndex == noFragment {
+        t.Fatalf("multi.bin has %d blocks, fragment %d; want 3 blocks and a fragment tail", len(in.blockSizes), in.fragmentIndex)
+    }
+    if in.blockSizes[0]&dataUncompressed != 0 || in.blockSizes[1]&dataUncompressed == 0 {
+        t.Errorf("block sizes %#x: want a compressed and a stored block", in.blockSizes)
+    }
+
+    f, err := r.Open("multi.bin")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer f.Close()
+    file := f.(*file)
+
+    // Reads across block boundaries and into the fragment.
+    for _, span := range [][2]int{{0, 10}, {4090, 4100}, {8000, 12300}, {12280, len(want)}, {100, len(want)}} {
+        buf := make([]byte, span[1]-span[0])
+        n, err := file.ReadAt(buf, int64(span[0]))
+        if err != nil && !(err == io.EOF && span[1] == len(want)) {
+            t.Errorf("ReadAt(%d): %v", span[0], err)
+        }
+        if !bytes.Equal(buf[:n], want[span[0]:span[1]]) {
+            t.Errorf("ReadAt(%d, %d bytes) returned wrong content", span[0], len(buf))
+        }
+    }
+
+    if _, err := file.ReadAt(make([]byte, 10), int64(len(want))-4); err != io.EOF {
+        t.Errorf("ReadAt past the end = %v, want io.EOF", err)
+    }
+
+    if _, err := file.Seek(-38, io.SeekEnd); err != nil {
+        t.Fatal(err)
+    }
+    tail, err := io.ReadAll(file)
+    if err != nil || string(tail) != "tail of the file stored in a fragment\n" {
+        t.Errorf("tail after Seek = %q, %v", tail, err)
+    }
+
+    info, err := f.Stat()
+    if err != nil || info.Size() != int64(len(want)) {
+        t.Errorf("Stat() = %v, %v", info, err)
+    }
+}
+
+func TestSymlinks(t *testing.T) {
+    r := openImage(t, "gzip.sqfs")
+
+    tests := []struct {
+        name    string
+        target  string
+        content string
+    }{
+        {"link", "hello.txt", testFiles["hello.txt"]},
+        {"dir/up", "../hello.txt", testFiles["hello.txt"]},
+        {"absolute", "/dir/nested.txt", testFiles["dir/nested.txt"]},
+        // Resolved inside the image, never on the host.
+        {"dir/escape", "../../../../etc/passwd", testFiles["etc/passwd"]},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if target, err := r.ReadLink(tt.name); err != nil || target != tt.target {
+                t.Errorf("ReadLink() = %q, %v; want %q", target, err, tt.target)
+            }
+            if info, err := r.Lstat(tt.name); err != nil || info.Mode()&fs.ModeSymlink == 0 {
+                t.Errorf("Lstat() = %v, %v; want a symlink", info, err)
+            }
+            if data, err := fs.ReadFile(r, tt.name); err != nil || string(data) != tt.content {
+                t.Errorf("ReadFile() = %q, %v; want %q", data, err, tt.content)
+            }
+        })
+    }
+
+    if data, err := fs.ReadFile(r, "dirlink/nested.txt"); err != nil || string(data) != testFiles["dir/nested.txt"] {
+        t.Errorf("ReadFile through a directory link = %q, %v", data, err)
+    }
+    if _, err := r.Stat("loop"); err == nil {
+        t.Error("Stat of a symlink loop succeeded")
+    }
+    if _, err := r.ReadLink("hello.txt"); !errors.Is(err, fs.ErrInvalid) {
+        t.Errorf("ReadLink of a file = %v, want fs.ErrInvalid", err)
+    }
+    for _, name := range []string{"../etc/passwd", "/etc/passwd", "dir/../../etc/passwd"} {
+        if _, err := r.Open(name); !errors.Is(err, fs.ErrInvalid) {
+            t.Errorf("Open(%s) = %v, want fs.ErrInvalid", name, err)
+        }
+    }
+}
+
+func TestReadDir(t *testing.T) {
+    r := openImage(t, "gzip.sqfs")
+    entries, err := r.ReadDir("dir")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    want := map[string]fs.FileMode{"escape": fs.ModeSymlink, "nested.txt": 0, "up": fs.ModeSymlink}
+    if len(entries) != len(want) {
+        t.Fatalf("ReadDir(dir) returned %d entries, want %d", len(entries), len(want))
+    }
+    for _, e := range entries {
+        mode, ok := want[e.Name()]
+        if !ok || e.Type() != mode {
+            t.Errorf("unexpected entry %s (%v)", e.Name(), e.Type())
+        }
+    }
+
+    info, err := r.Stat("dir/nested.txt")
+    if err != nil || info.Mode() != 0600 {
+        t.Errorf("Stat(dir/nested.txt) = %v, %v; want mode 0600", info, err)
+    }
+    if _, err := r.ReadDir("hello.txt"); err == nil {
+        t.Error("ReadDir of a file succeeded")
+    }
+}
+
+// walk opens and reads everything in the image and returns the first error.
+func walk(r *Reader) error {
+    var first error
+    fs.WalkDir(r, ".", func(name string, d fs.DirEntry, err error) error {
+        if err == nil && d.Type().IsRegular() {
+            _, err = fs.ReadFile(r, name)
+        }
+        if err != nil && first == nil {
+            first = err
+        }
+        return nil
+    })
+    return first
+}
+
+func TestCorruptImages(t *testing.T) {
+    image, err := os.ReadFile(filepath.Join("testdata", "gzip.sqfs"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    r := openImage(t, "gzip.sqfs")
+    sb := r.sb
+    multi, err := r.lookup("stat", "multi.bin", true)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    corrupt := func(offset int, data ...byte) []byte {
+        c := bytes.Clone(image)
+        copy(c[offset:], data)
+        return c
+    }
+
+    tests := []struct {
+        name string
+        data []byte
+        // open is set when the superblock is rejected.
+        open bool
+    }{
+        {"empty", nil, true},
+        {"truncated superblock", image[:40], true},
+        {"bad magic", corrupt(0, 'x'), true},
+        {"version 3", corrupt(28, 3), true},
+        {"block size mismatch", corrupt(22, 13), true},
+        {"unknown compressor", corrupt(20, 9), true},
+        {"truncated at the inode table", image[:sb.InodeTableStart+10], false},
+        {"truncated before the tables", image[:sb.InodeTableStart-100], false},
+        {"corrupt inode table", corrupt(int(sb.InodeTableStart)+2, bytes.Repeat([]byte{0x55}, 64)...), false},
+        {"corrupt directory table", corrupt(int(sb.DirTableStart)+2, bytes.Repeat([]byte{0xaa}, 32)...), false},
+        {"corrupt metadata header", corrupt(int(sb.InodeTableStart), 0xff, 0x7f), false},
+        {"corrupt data block", corrupt(int(multi.blocksStart)+20, bytes.Repeat([]byte{0xff}, 8)...), false},
+        {"fragment table out of range", corrupt(int(sb.FragTableStart), 0xff, 0xff, 0xff, 0x7f), false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            r, err := NewReader(bytes.NewReader(tt.data))
+            if tt.open {
+                if err == nil {
+                    t.Error("NewReader succeeded")
+                }
+                return
+            }
+            if err != nil {
+                t.Fatalf("NewReader: %v", err)
+            }
+            if err := walk(r); err == nil {
+                t.Error("reading the image succeeded")
+            }
+        })
+    }
+}
+
+func TestReadBlockSizes(t *testing.T) {
+    const blockSize = 4096
+    r := &Reader{sb: superblock{BlockSize: blockSize, BytesUsed: 1 << 20}}
+    sizes := func(n int, size uint32) []byte {
+        b := make([]byte, 4*n)
+        for i := 0; i < n; i++ {
+            binary.LittleEndian.PutUint32(b[4*i:], size)
+        }
+        return b
+    }
+
+    tests := []struct {
+        name        string
+        blocksStart uint64
+        size        uint64
+        list        []byte
+        ok          bool
+    }{
+        {"three blocks", 96, 3 * blockSize, sizes(3, 1000), true},
+        {"uncompressed blocks", 96, 3 * blockSize, sizes(3, blockSize|dataUncompressed), true},
+        {"sparse file", 96, 1000 * blockSize, sizes(1000, 0), true},
+        {"more blocks than the image has room for", 96, 1 << 18 * blockSize, sizes(1<<18, 0), false},
+        {"blocks past the image", 96, 300 * blockSize, sizes(300, blockSize), false},
+        {"starts past the image", 2 << 20, blockSize, sizes(1, 0), false},
+        {"truncated block list", 96, 3 * blockSize, sizes(2, 1000), false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            in := &inode{blocksStart: tt.blocksStart, size: tt.size, fragmentIndex: noFragment}
+            err := r.readBlockSizes(bytes.NewReader(tt.list), in)
+            if (err == nil) != tt.ok {
+                t.Errorf("readBlockSizes() = %v, want ok %v", err, tt.ok)
+            }
+        })
+    }
+}
@@ -1,18 +1,64 @@
 package types
 
+import "path/filepath"
+
+// Installation scopes: system-wide, or into the invoking user's XDG
+// directories.
+const (
+    ScopeSystem = "system"
+    ScopeUser   = "user"
+)
+
+// Policies for desktop entries that fail validation: strict refuses any
+// problem, repair fixes what it can and refuses remaining errors, warn
+// installs anyway.
+const (
+    VerifyStrict = "strict"
+    VerifyRepair = "repair"
+    VerifyWarn   = "warn"
+)
+
+var VerifyPolicies = []string{VerifyStrict, VerifyRepair, VerifyWarn}
+
 type Config struct {
-    Debug           bool
-
-    ExtractDir      string
-    AppExtractDir   string
-    ImgPath         string 
-    InputPath       string
-    InputFileName   string 
-    InputDir        string 
+    // Root is prepended to every install path on disk. Desktop entries and
+    // the registry keep the paths as seen from inside the root.
+    Root             string
+    Scope            string
+    Debug            bool
+    AllowExecExtract bool
+    Autostart        bool
+    VerifyPolicy     string
+
+    ExtractDir       string
+    AppExtractDir    string
+    ImgPath          string 
+    IconThemeDir     string
+    // IconPath is an icon file given on the command line, used instead of
+    // the AppImage's own.
+    IconPath         string
+    InputPath        string
+    // Source is recorded as where the AppImage came from instead of
+    // InputPath, for downloads installed from a temporary file.
+    Source           string
+    InputFileName    string 
+    InputDir         string 
     
-    ExecDir         string
-    ExecPath        string 
+    AppID            string
+    ExecDir          string
+    ExecPath         string 
+    KeepVersions     int
+
+    GnomeDesktopDir  string
+    AutostartDir     string
+
+    RegistryPath     string
+}
 
-    GnomeDesktopDir string
-    AutostartDir    string
-} 
\ No newline at end of file
+// Path returns where an installed path is on disk.
+func (c Config) Path(path string) string {
+    if c.Root == "" || path == "" {
+        return path
+    }
+    return filepath.Join(c.Root, path)
+}
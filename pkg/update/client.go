@@ -0,0 +1,207 @@
+package update
+
+import (
+    "fmt"
+    "io"
+    "net/http"
+    "net/url"
+    "os"
+    "time"
+)
+
+const defaultGitHubAPI = "https://api.github.com"
+
+type Client struct {
+    HTTP      *http.Client
+    GitHubAPI string
+    UserAgent string
+}
+
+func NewClient() *Client {
+    return &Client{
+        HTTP:      &http.Client{Timeout: 5 * time.Minute},
+        GitHubAPI: defaultGitHubAPI,
+        UserAgent: "appinstaller",
+    }
+}
+
+// Check is the result of looking for an update of an installed AppImage.
+type Check struct {
+    Info       *Info
+    ControlURL string
+    Control    *Control
+    Available  bool
+}
+
+// Stats describes how a target was assembled.
+type Stats struct {
+    Blocks     int
+    Reused     int
+    Downloaded int64
+}
+
+// Check resolves the update information of the AppImage at installedPath and
+// compares it with the published control file.
+func (c *Client) Check(updateInfo, installedPath string) (*Check, error) {
+    info, err := ParseInfo(updateInfo)
+    if err != nil {
+        return nil, err
+    }
+
+    controlURL, err := c.ControlURL(info)
+    if err != nil {
+        return nil, err
+    }
+
+    resp, err := c.get(controlURL, "")
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return nil, fmt.Errorf("error fetching %s: %s", controlURL, resp.Status)
+    }
+
+    control, err := ParseControl(resp.Body)
+    if err != nil {
+        return nil, fmt.Errorf("error reading %s: %w", controlURL, err)
+    }
+
+    upToDate, err := control.Matches(installedPath)
+    if err != nil {
+        return nil, err
+    }
+
+    return &Check{Info: info, ControlURL: controlURL, Control: control, Available: !upToDate}, nil
+}
+
+// TargetURL is the URL of the new AppImage, which the control file may give
+// relative to its own location.
+func (ch *Check) TargetURL() (string, error) {
+    if len(ch.Control.URLs) == 0 {
+        return "", fmt.Errorf("zsync control file %s has no URL", ch.ControlURL)
+    }
+
+    base, err := url.Parse(ch.ControlURL)
+    if err != nil {
+        return "", err
+    }
+    target, err := url.Parse(ch.Control.URLs[0])
+    if err != nil {
+        return "", fmt.Errorf("invalid target URL %q: %w", ch.Control.URLs[0], err)
+    }
+    return base.ResolveReference(target).String(), nil
+}
+
+// Download assembles the new AppImage at dest, reusing the blocks of seedPath
+// and fetching only the missing ones with HTTP range requests.
+func (c *Client) Download(check *Check, seedPath, dest string) (*Stats, error) {
+    control := check.Control
+    targetURL, err := check.TargetURL()
+    if err != nil {
+        return nil, err
+    }
+
+    out, err := os.OpenFile(dest, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0755)
+    if err != nil {
+        return nil, fmt.Errorf("error creating %s: %w", dest, err)
+    }
+    defer out.Close()
+    if err := out.Truncate(control.Length); err != nil {
+        return nil, err
+    }
+
+    found := make([]bool, len(control.blocks))
+    if seed, err := os.Open(seedPath); err == nil {
+        found, err = control.matchSeed(seed, out)
+        seed.Close()
+        if err != nil {
+            return nil, err
+        }
+    }
+
+    stats := &Stats{Blocks: len(found)}
+    for _, ok := range found {
+        if ok {
+            stats.Reused++
+        }
+    }
+
+    bs := int64(control.Blocksize)
+    for start := 0; start < len(found); {
+        if found[start] {
+            start++
+            continue
+        }
+        end := start
+        for end < len(found) && !found[end] {
+            end++
+        }
+
+        from, to := int64(start)*bs, int64(end)*bs
+        if to > control.Length {
+            to = control.Length
+        }
+        n, complete, err := c.fetchRange(targetURL, out, from, to)
+        stats.Downloaded += n
+        if err != nil {
+            return stats, err
+        }
+        if complete {
+            break
+        }
+        start = end
+    }
+
+    if err := out.Close(); err != nil {
+        return stats, err
+    }
+    ok, err := control.Matches(dest)
+    if err != nil {
+        return stats, err
+    }
+    if !ok {
+        return stats, fmt.Errorf("downloaded file does not match the SHA-1 of %s", check.ControlURL)
+    }
+    return stats, nil
+}
+
+// fetchRange writes bytes [from, to) of url to out. Servers that ignore the
+// range send the whole file, which then completes the target at once.
+func (c *Client) fetchRange(url string, out io.WriterAt, from, to int64) (int64, bool, error) {
+    resp, err := c.get(url, fmt.Sprintf("bytes=%d-%d", from, to-1))
+    if err != nil {
+        return 0, false, err
+    }
+    defer resp.Body.Close()
+
+    switch resp.StatusCode {
+    case http.StatusPartialContent:
+        n, err := io.Copy(io.NewOffsetWriter(out, from), io.LimitReader(resp.Body, to-from))
+        if err == nil && n != to-from {
+            err = fmt.Errorf("short range response from %s", url)
+        }
+        return n, false, err
+    case http.StatusOK:
+        n, err := io.Copy(io.NewOffsetWriter(out, 0), resp.Body)
+        return n, true, err
+    }
+    return 0, false, fmt.Errorf("error fetching %s: %s", url, resp.Status)
+}
+
+func (c *Client) get(url, byteRange string) (*http.Response, error) {
+    req, err := http.NewRequest(http.MethodGet, url, nil)
+    if err != nil {
+        return nil, err
+    }
+    req.Header.Set("User-Agent", c.UserAgent)
+    if byteRange != "" {
+        req.Header.Set("Range", byteRange)
+    }
+
+    resp, err := c.HTTP.Do(req)
+    if err != nil {
+        return nil, fmt.Errorf("error fetching %s: %w", url, err)
+    }
+    return resp, nil
+}
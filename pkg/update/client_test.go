@@ -0,0 +1,207 @@
+package update
+
+import (
+    "bytes"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+const testBlocksize = 1024
+
+// newServer serves a release: the target AppImage at /app.AppImage and its
+// control file at /app.zsync. Unless ranges is set, range requests are
+// answered with the whole file, as some servers do.
+func newServer(t *testing.T, target []byte, ranges bool) *httptest.Server {
+    t.Helper()
+    control := makeControl(target, testBlocksize, 2, 4, 16, "app.AppImage")
+
+    mux := http.NewServeMux()
+    mux.HandleFunc("/app.zsync", func(w http.ResponseWriter, r *http.Request) {
+        w.Write(control)
+    })
+    mux.HandleFunc("/app.AppImage", func(w http.ResponseWriter, r *http.Request) {
+        if !ranges {
+            r.Header.Del("Range")
+        }
+        http.ServeContent(w, r, "app.AppImage", time.Time{}, bytes.NewReader(target))
+    })
+    mux.HandleFunc("/repos/owner/app/releases/latest", func(w http.ResponseWriter, r *http.Request) {
+        fmt.Fprintf(w, `{"tag_name": "v2", "assets": [
+            {"name": "app-x86_64.AppImage", "browser_download_url": "http://%[1]s/app.AppImage"},
+            {"name": "app-x86_64.AppImage.zsync", "browser_download_url": "http://%[1]s/app.zsync"}
+        ]}`, r.Host)
+    })
+
+    server := httptest.NewServer(mux)
+    t.Cleanup(server.Close)
+    return server
+}
+
+func newTestClient(server *httptest.Server) *Client {
+    c := NewClient()
+    c.HTTP = server.Client()
+    c.GitHubAPI = server.URL
+    return c
+}
+
+func writeFile(t *testing.T, data []byte) string {
+    t.Helper()
+    path := filepath.Join(t.TempDir(), "installed.AppImage")
+    if err := os.WriteFile(path, data, 0755); err != nil {
+        t.Fatal(err)
+    }
+    return path
+}
+
+// olderVersion is target with a few blocks changed and some bytes inserted.
+func olderVersion(target []byte) []byte {
+    old := append(randomBytes(5, 100), target...)
+    for _, block := range []int{2, 9, 10} {
+        old[100+block*testBlocksize] ^= 0xff
+    }
+    return old
+}
+
+func TestCheck(t *testing.T) {
+    target := randomBytes(4, 20*testBlocksize+512)
+    server := newServer(t, target, true)
+    client := newTestClient(server)
+
+    tests := []struct {
+        name       string
+        updateInfo string
+        installed  []byte
+        available  bool
+    }{
+        {"newer version", "zsync|" + server.URL + "/app.zsync", olderVersion(target), true},
+        {"unchanged", "zsync|" + server.URL + "/app.zsync", target, false},
+        {"GitHub release", "gh-releases-zsync|owner|app|latest|app-*.AppImage.zsync", olderVersion(target), true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            check, err := client.Check(tt.updateInfo, writeFile(t, tt.installed))
+            if err != nil {
+                t.Fatal(err)
+            }
+            if check.Available != tt.available {
+                t.Errorf("Available = %v, want %v", check.Available, tt.available)
+            }
+            if check.ControlURL != server.URL+"/app.zsync" {
+                t.Errorf("ControlURL = %s", check.ControlURL)
+            }
+            if url, err := check.TargetURL(); err != nil || url != server.URL+"/app.AppImage" {
+                t.Errorf("TargetURL() = %s, %v", url, err)
+            }
+        })
+    }
+}
+
+func TestCheckErrors(t *testing.T) {
+    server := newServer(t, randomBytes(4, 3*testBlocksize), true)
+    client := newTestClient(server)
+
+    for _, updateInfo := range []string{
+        "zsync|" + server.URL + "/missing.zsync",
+        "zsync|" + server.URL + "/app.AppImage",
+        "gh-releases-zsync|owner|app|latest|other-*.zsync",
+        "bintray-zsync|owner|app|file",
+    } {
+        if _, err := client.Check(updateInfo, writeFile(t, nil)); err == nil {
+            t.Errorf("Check(%s) succeeded", updateInfo)
+        }
+    }
+}
+
+func TestFetchRange(t *testing.T) {
+    target := randomBytes(6, 8*testBlocksize)
+
+    t.Run("range", func(t *testing.T) {
+        client := newTestClient(newServer(t, target, true))
+        out := make(writerAt, len(target))
+        n, complete, err := client.fetchRange(client.GitHubAPI+"/app.AppImage", out, 2048, 5000)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if n != 5000-2048 || complete {
+            t.Errorf("fetchRange() = %d, %v, want %d, false", n, complete, 5000-2048)
+        }
+        if !bytes.Equal(out[2048:5000], target[2048:5000]) {
+            t.Error("range written with wrong content")
+        }
+        if bytes.ContainsFunc(out[:2048], func(r rune) bool { return r != 0 }) || bytes.ContainsFunc(out[5000:], func(r rune) bool { return r != 0 }) {
+            t.Error("bytes outside the range were written")
+        }
+    })
+
+    t.Run("full body", func(t *testing.T) {
+        client := newTestClient(newServer(t, target, false))
+        out := make(writerAt, len(target))
+        n, complete, err := client.fetchRange(client.GitHubAPI+"/app.AppImage", out, 2048, 5000)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if n != int64(len(target)) || !complete {
+            t.Errorf("fetchRange() = %d, %v, want %d, true", n, complete, len(target))
+        }
+        if !bytes.Equal(out, target) {
+            t.Error("full body written with wrong content")
+        }
+    })
+
+    t.Run("not found", func(t *testing.T) {
+        client := newTestClient(newServer(t, target, true))
+        if _, _, err := client.fetchRange(client.GitHubAPI+"/missing", make(writerAt, 10), 0, 10); err == nil {
+            t.Error("expected an error")
+        }
+    })
+}
+
+func TestDownload(t *testing.T) {
+    target := randomBytes(7, 30*testBlocksize+700)
+
+    tests := []struct {
+        name   string
+        ranges bool
+        seed   []byte
+        // reused is the number of blocks expected from the seed, downloaded
+        // the number of bytes fetched.
+        reused     int
+        downloaded int64
+    }{
+        // Blocks 1 and 8 match, but cannot be confirmed by the changed
+        // blocks following them.
+        {"ranges", true, olderVersion(target), 26, 5 * testBlocksize},
+        {"full body", false, olderVersion(target), 26, int64(len(target))},
+        {"unrelated seed", true, randomBytes(8, 4*testBlocksize), 0, int64(len(target))},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            client := newTestClient(newServer(t, target, tt.ranges))
+            seed := writeFile(t, tt.seed)
+            check, err := client.Check("zsync|"+client.GitHubAPI+"/app.zsync", seed)
+            if err != nil {
+                t.Fatal(err)
+            }
+
+            dest := filepath.Join(t.TempDir(), "new.AppImage")
+            stats, err := client.Download(check, seed, dest)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if got, _ := os.ReadFile(dest); !bytes.Equal(got, target) {
+                t.Error("downloaded file differs from the target")
+            }
+            if stats.Blocks != 31 || stats.Reused != tt.reused {
+                t.Errorf("stats = %+v, want 31 blocks, %d reused", stats, tt.reused)
+            }
+            if stats.Downloaded != tt.downloaded {
+                t.Errorf("downloaded %d bytes, want %d", stats.Downloaded, tt.downloaded)
+            }
+        })
+    }
+}
@@ -0,0 +1,111 @@
+package update
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "net/url"
+    "path"
+    "strings"
+)
+
+const (
+    TransportZsync       = "zsync"
+    TransportGitHubZsync = "gh-releases-zsync"
+)
+
+// Info is parsed AppImage update information, a "|" separated string whose
+// first field names the transport.
+type Info struct {
+    Transport string
+    Fields    []string
+}
+
+func ParseInfo(raw string) (*Info, error) {
+    parts := strings.Split(strings.TrimSpace(raw), "|")
+    if len(parts) < 2 || parts[0] == "" {
+        return nil, fmt.Errorf("invalid update information %q", raw)
+    }
+
+    info := &Info{Transport: parts[0], Fields: parts[1:]}
+    switch info.Transport {
+    case TransportZsync:
+        if len(info.Fields) != 1 {
+            return nil, fmt.Errorf("zsync update information needs exactly one URL: %q", raw)
+        }
+    case TransportGitHubZsync:
+        if len(info.Fields) != 4 {
+            return nil, fmt.Errorf("gh-releases-zsync update information needs owner, repo, tag and file: %q", raw)
+        }
+    default:
+        return nil, fmt.Errorf("unsupported update transport %q", info.Transport)
+    }
+    return info, nil
+}
+
+func (i *Info) String() string {
+    return strings.Join(append([]string{i.Transport}, i.Fields...), "|")
+}
+
+type release struct {
+    TagName string `json:"tag_name"`
+    Assets  []struct {
+        Name string `json:"name"`
+        URL  string `json:"browser_download_url"`
+    } `json:"assets"`
+}
+
+// ControlURL resolves the URL of the .zsync control file.
+func (c *Client) ControlURL(info *Info) (string, error) {
+    if info.Transport == TransportZsync {
+        return info.Fields[0], nil
+    }
+
+    owner, repo, tag, pattern := info.Fields[0], info.Fields[1], info.Fields[2], info.Fields[3]
+    base := strings.TrimSuffix(c.GitHubAPI, "/") + "/repos/" + url.PathEscape(owner) + "/" + url.PathEscape(repo) + "/releases"
+
+    var releases []release
+    switch tag {
+    case "latest":
+        var latest release
+        if err := c.getJSON(base+"/latest", &latest); err != nil {
+            return "", err
+        }
+        releases = []release{latest}
+    case "latest-pre", "latest-all":
+        if err := c.getJSON(base, &releases); err != nil {
+            return "", err
+        }
+    default:
+        var tagged release
+        if err := c.getJSON(base+"/tags/"+url.PathEscape(tag), &tagged); err != nil {
+            return "", err
+        }
+        releases = []release{tagged}
+    }
+
+    for _, r := range releases {
+        for _, asset := range r.Assets {
+            if matched, _ := path.Match(pattern, asset.Name); matched {
+                return asset.URL, nil
+            }
+        }
+    }
+    return "", fmt.Errorf("no release asset of %s/%s matches %s", owner, repo, pattern)
+}
+
+func (c *Client) getJSON(url string, v any) error {
+    resp, err := c.get(url, "")
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode != http.StatusOK {
+        return fmt.Errorf("error fetching %s: %s", url, resp.Status)
+    }
+    if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+        return fmt.Errorf("error decoding %s: %w", url, err)
+    }
+    return nil
+}
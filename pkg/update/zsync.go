@@ -0,0 +1,263 @@
+package update
+
+import (
+    "bufio"
+    "bytes"
+    "crypto/sha1"
+    "encoding/hex"
+    "fmt"
+    "io"
+    "os"
+    "strconv"
+    "strings"
+
+    "golang.org/x/crypto/md4"
+)
+
+// Control is a parsed .zsync control file: the target's metadata followed
+// by a weak rolling checksum and a truncated MD4 for every block.
+type Control struct {
+    Filename      string
+    Blocksize     int
+    Length        int64
+    SeqMatches    int
+    RsumBytes     int
+    ChecksumBytes int
+    URLs          []string
+    SHA1          string
+
+    blocks []blockSum
+}
+
+type blockSum struct {
+    rsum     rsum
+    checksum []byte
+}
+
+type rsum struct {
+    a, b uint16
+}
+
+func ParseControl(r io.Reader) (*Control, error) {
+    reader := bufio.NewReader(r)
+    c := &Control{SeqMatches: 1, RsumBytes: 4, ChecksumBytes: 16}
+
+    for {
+        line, err := reader.ReadString('\n')
+        if err != nil {
+            return nil, fmt.Errorf("error reading zsync header: %w", err)
+        }
+        line = strings.TrimRight(line, "\r\n")
+        if line == "" {
+            break
+        }
+
+        key, value, found := strings.Cut(line, ": ")
+        if !found {
+            return nil, fmt.Errorf("invalid zsync header line %q", line)
+        }
+
+        switch key {
+        case "Filename":
+            c.Filename = value
+        case "Blocksize":
+            c.Blocksize, err = strconv.Atoi(value)
+        case "Length":
+            c.Length, err = strconv.ParseInt(value, 10, 64)
+        case "Hash-Lengths":
+            var lengths []int
+            for _, field := range strings.Split(value, ",") {
+                n, convErr := strconv.Atoi(field)
+                if convErr != nil {
+                    err = convErr
+                    break
+                }
+                lengths = append(lengths, n)
+            }
+            if err == nil && len(lengths) != 3 {
+                err = fmt.Errorf("expected three values")
+            }
+            if err == nil {
+                c.SeqMatches, c.RsumBytes, c.ChecksumBytes = lengths[0], lengths[1], lengths[2]
+            }
+        case "URL":
+            c.URLs = append(c.URLs, value)
+        case "SHA-1":
+            c.SHA1 = strings.ToLower(value)
+        }
+        if err != nil {
+            return nil, fmt.Errorf("invalid zsync header %s: %w", key, err)
+        }
+    }
+
+    switch {
+    case c.Blocksize <= 0 || c.Blocksize&(c.Blocksize-1) != 0:
+        return nil, fmt.Errorf("invalid zsync block size %d", c.Blocksize)
+    case c.Length < 0:
+        return nil, fmt.Errorf("invalid zsync length %d", c.Length)
+    case c.SeqMatches < 1 || c.SeqMatches > 2:
+        return nil, fmt.Errorf("unsupported zsync sequential matches %d", c.SeqMatches)
+    case c.RsumBytes < 1 || c.RsumBytes > 4:
+        return nil, fmt.Errorf("invalid zsync rsum length %d", c.RsumBytes)
+    case c.ChecksumBytes < 3 || c.ChecksumBytes > md4.Size:
+        return nil, fmt.Errorf("invalid zsync checksum length %d", c.ChecksumBytes)
+    case len(c.SHA1) != 2*sha1.Size:
+        return nil, fmt.Errorf("missing SHA-1 in zsync header")
+    }
+
+    count := c.blockCount()
+    c.blocks = make([]blockSum, count)
+    entry := make([]byte, c.RsumBytes+c.ChecksumBytes)
+    for i := range c.blocks {
+        if _, err := io.ReadFull(reader, entry); err != nil {
+            return nil, fmt.Errorf("error reading checksum of block %d: %w", i, err)
+        }
+
+        // The trailing RsumBytes of the big endian a, b pair are stored.
+        var raw [4]byte
+        copy(raw[4-c.RsumBytes:], entry[:c.RsumBytes])
+        c.blocks[i] = blockSum{
+            rsum:     rsum{a: uint16(raw[0])<<8 | uint16(raw[1]), b: uint16(raw[2])<<8 | uint16(raw[3])},
+            checksum: append([]byte(nil), entry[c.RsumBytes:]...),
+        }
+    }
+
+    return c, nil
+}
+
+func (c *Control) blockCount() int {
+    return int((c.Length + int64(c.Blocksize) - 1) / int64(c.Blocksize))
+}
+
+// aMask drops the bytes of a that were not stored.
+func (c *Control) aMask() uint16 {
+    switch {
+    case c.RsumBytes < 3:
+        return 0
+    case c.RsumBytes == 3:
+        return 0xff
+    }
+    return 0xffff
+}
+
+// Matches reports whether the file at path is the target.
+func (c *Control) Matches(path string) (bool, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return false, err
+    }
+    defer file.Close()
+
+    hash := sha1.New()
+    if _, err := io.Copy(hash, file); err != nil {
+        return false, err
+    }
+    return hex.EncodeToString(hash.Sum(nil)) == c.SHA1, nil
+}
+
+func blockRsum(data []byte) rsum {
+    var r rsum
+    length := uint16(len(data))
+    for i, c := range data {
+        r.a += uint16(c)
+        r.b += (length - uint16(i)) * uint16(c)
+    }
+    return r
+}
+
+func (c *Control) checksumMatches(block int, data []byte) bool {
+    sum := md4.New()
+    sum.Write(data)
+    return bytes.Equal(sum.Sum(nil)[:c.ChecksumBytes], c.blocks[block].checksum)
+}
+
+// seedChunk is how much of the seed is buffered at a time.
+const seedChunk = 1 << 20
+
+// matchSeed scans seed with a rolling checksum and writes every target block
+// it contains to target. It returns which blocks were found.
+func (c *Control) matchSeed(seed io.Reader, target io.WriterAt) ([]bool, error) {
+    bs := c.Blocksize
+    found := make([]bool, len(c.blocks))
+    mask := c.aMask()
+
+    index := make(map[rsum][]int)
+    for i, block := range c.blocks {
+        key := rsum{a: block.rsum.a & mask, b: block.rsum.b}
+        index[key] = append(index[key], i)
+    }
+
+    buf := make([]byte, 0, seedChunk+2*bs)
+    eof := false
+    fill := func(need int) error {
+        for len(buf) < need && !eof {
+            n, err := seed.Read(buf[len(buf):cap(buf)])
+            buf = buf[:len(buf)+n]
+            if err == io.EOF {
+                // Short final blocks are checksummed zero padded, so pad
+                // the seed too.
+                eof = true
+                buf = append(buf, make([]byte, bs)...)
+            } else if err != nil {
+                return fmt.Errorf("error reading seed: %w", err)
+            }
+        }
+        return nil
+    }
+
+    if err := fill(bs); err != nil {
+        return nil, err
+    }
+    r := blockRsum(buf[:bs])
+
+    for pos := 0; ; {
+        if pos >= seedChunk {
+            copy(buf, buf[pos:])
+            buf = buf[:len(buf)-pos]
+            pos = 0
+        }
+        if err := fill(pos + 2*bs); err != nil {
+            return nil, err
+        }
+
+        window := buf[pos : pos+bs]
+        matched := false
+        for _, block := range index[rsum{a: r.a & mask, b: r.b}] {
+            if found[block] || !c.checksumMatches(block, window) {
+                continue
+            }
+            if c.SeqMatches > 1 && block+1 < len(c.blocks) {
+                if len(buf) < pos+2*bs || !c.checksumMatches(block+1, buf[pos+bs:pos+2*bs]) {
+                    continue
+                }
+            }
+
+            offset := int64(block) * int64(bs)
+            size := int64(bs)
+            if offset+size > c.Length {
+                size = c.Length - offset
+            }
+            if _, err := target.WriteAt(window[:size], offset); err != nil {
+                return nil, err
+            }
+            found[block] = true
+            matched = true
+        }
+
+        if matched && len(buf) >= pos+2*bs {
+            pos += bs
+            r = blockRsum(buf[pos : pos+bs])
+            continue
+        }
+        if len(buf) <= pos+bs {
+            break
+        }
+
+        out, in := buf[pos], buf[pos+bs]
+        r.a += uint16(in) - uint16(out)
+        r.b += r.a - uint16(bs)*uint16(out)
+        pos++
+    }
+
+    return found, nil
+}
@@ -0,0 +1,141 @@
+package update
+
+import (
+    "bytes"
+    "crypto/sha1"
+    "encoding/hex"
+    "fmt"
+    "math/rand"
+    "strings"
+    "testing"
+
+    "golang.org/x/crypto/md4"
+)
+
+// makeControl builds a .zsync control file for target the way zsyncmake does.
+func makeControl(target []byte, blocksize, seqMatches, rsumBytes, checksumBytes int, url string) []byte {
+    sum := sha1.Sum(target)
+
+    var b bytes.Buffer
+    fmt.Fprintf(&b, "zsync: 0.6.2\nFilename: app.AppImage\nBlocksize: %d\nLength: %d\n", blocksize, len(target))
+    fmt.Fprintf(&b, "Hash-Lengths: %d,%d,%d\nURL: %s\nSHA-1: %s\n\n", seqMatches, rsumBytes, checksumBytes, url, hex.EncodeToString(sum[:]))
+    for off := 0; off < len(target); off += blocksize {
+        block := make([]byte, blocksize)
+        copy(block, target[off:])
+        r := blockRsum(block)
+        raw := []byte{byte(r.a >> 8), byte(r.a), byte(r.b >> 8), byte(r.b)}
+        b.Write(raw[4-rsumBytes:])
+        h := md4.New()
+        h.Write(block)
+        b.Write(h.Sum(nil)[:checksumBytes])
+    }
+    return b.Bytes()
+}
+
+func randomBytes(seed int64, n int) []byte {
+    data := make([]byte, n)
+    rand.New(rand.NewSource(seed)).Read(data)
+    return data
+}
+
+func TestParseControl(t *testing.T) {
+    target := randomBytes(1, 5000)
+    c, err := ParseControl(bytes.NewReader(makeControl(target, 1024, 2, 3, 5, "app.AppImage")))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    sum := sha1.Sum(target)
+    switch {
+    case c.Filename != "app.AppImage", c.Blocksize != 1024, c.Length != 5000:
+        t.Errorf("header = %q %d %d", c.Filename, c.Blocksize, c.Length)
+    case c.SeqMatches != 2, c.RsumBytes != 3, c.ChecksumBytes != 5:
+        t.Errorf("hash lengths = %d,%d,%d", c.SeqMatches, c.RsumBytes, c.ChecksumBytes)
+    case len(c.URLs) != 1 || c.URLs[0] != "app.AppImage":
+        t.Errorf("URLs = %q", c.URLs)
+    case c.SHA1 != hex.EncodeToString(sum[:]):
+        t.Errorf("SHA-1 = %s", c.SHA1)
+    case len(c.blocks) != 5:
+        t.Errorf("%d blocks, want 5", len(c.blocks))
+    }
+
+    block := make([]byte, 1024)
+    copy(block, target[4096:])
+    if !c.checksumMatches(4, block) {
+        t.Error("checksum of the zero padded last block does not match")
+    }
+}
+
+func TestParseControlErrors(t *testing.T) {
+    valid := string(makeControl(randomBytes(1, 3000), 1024, 1, 4, 16, "app.AppImage"))
+    header, _, _ := strings.Cut(valid, "\n\n")
+
+    tests := map[string]string{
+        "block size not a power of two": strings.Replace(valid, "Blocksize: 1024", "Blocksize: 1000", 1),
+        "unsupported sequence matches":  strings.Replace(valid, "Hash-Lengths: 1,4,16", "Hash-Lengths: 3,4,16", 1),
+        "checksum too long":             strings.Replace(valid, "Hash-Lengths: 1,4,16", "Hash-Lengths: 1,4,17", 1),
+        "invalid length":                strings.Replace(valid, "Length: 3000", "Length: many", 1),
+        "missing SHA-1":                 strings.Replace(valid, "SHA-1:", "X-SHA-1:", 1),
+        "malformed header line":         "Blocksize 1024\n\n",
+        "header without end":            header,
+        "truncated checksums":           valid[:len(valid)-10],
+    }
+    for name, control := range tests {
+        t.Run(name, func(t *testing.T) {
+            if _, err := ParseControl(strings.NewReader(control)); err == nil {
+                t.Error("expected an error")
+            }
+        })
+    }
+}
+
+// writerAt collects what matchSeed writes.
+type writerAt []byte
+
+func (w writerAt) WriteAt(p []byte, off int64) (int, error) {
+    return copy(w[off:], p), nil
+}
+
+func TestMatchSeed(t *testing.T) {
+    const bs = 1024
+    target := randomBytes(2, 40*bs+300)
+
+    // The seed is the target shifted by an inserted prefix, with a few
+    // blocks changed.
+    changed := map[int]bool{3: true, 17: true, 40: true}
+    seed := append(randomBytes(3, 77), target...)
+    for block := range changed {
+        seed[77+block*bs+5] ^= 0xff
+    }
+
+    for _, lengths := range [][3]int{{1, 4, 16}, {2, 2, 5}, {2, 3, 8}} {
+        t.Run(fmt.Sprint(lengths), func(t *testing.T) {
+            control, err := ParseControl(bytes.NewReader(makeControl(target, bs, lengths[0], lengths[1], lengths[2], "app.AppImage")))
+            if err != nil {
+                t.Fatal(err)
+            }
+
+            out := make(writerAt, len(target))
+            found, err := control.matchSeed(bytes.NewReader(seed), out)
+            if err != nil {
+                t.Fatal(err)
+            }
+
+            for block, ok := range found {
+                end := min((block+1)*bs, len(target))
+                switch {
+                // With two sequential matches the block before a changed one
+                // cannot be confirmed either.
+                case changed[block] || (lengths[0] == 2 && changed[block+1]):
+                    if ok {
+                        t.Errorf("changed block %d reported as found", block)
+                    }
+                case !ok:
+                    t.Errorf("block %d not found", block)
+                case !bytes.Equal(out[block*bs:end], target[block*bs:end]):
+                    t.Errorf("block %d written with wrong content", block)
+                }
+            }
+        })
+    }
+}
@@ -0,0 +1,36 @@
+package xdg
+
+import (
+    "os"
+    "path/filepath"
+)
+
+// Base directories of the XDG Base Directory Specification. Relative values
+// in the environment are invalid and ignored.
+
+func DataHome() string {
+    return dir("XDG_DATA_HOME", ".local/share")
+}
+
+func ConfigHome() string {
+    return dir("XDG_CONFIG_HOME", ".config")
+}
+
+func StateHome() string {
+    return dir("XDG_STATE_HOME", ".local/state")
+}
+
+func CacheHome() string {
+    return dir("XDG_CACHE_HOME", ".cache")
+}
+
+func dir(env, fallback string) string {
+    if value := os.Getenv(env); filepath.IsAbs(value) {
+        return value
+    }
+    home, err := os.UserHomeDir()
+    if err != nil || home == "" {
+        home = "/"
+    }
+    return filepath.Join(home, fallback)
+}